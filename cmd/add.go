@@ -2,11 +2,10 @@ package cmd
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
 	"time"
 
 	"basal/db"
+	"basal/schedule"
 
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
@@ -24,15 +23,15 @@ func init() {
 }
 
 func runAdd(cmd *cobra.Command, args []string) error {
-	dbPath, err := getDBPath()
-	if err != nil {
-		return fmt.Errorf("error getting database path: %v", err)
+	if err := requireTerminal("'basal add' asks for the schedule interactively"); err != nil {
+		return err
 	}
-	database, err := db.InitDB(dbPath)
+
+	store, err := openStore()
 	if err != nil {
-		return fmt.Errorf("error initializing database: %v", err)
+		return err
 	}
-	defer database.Close()
+	defer store.Close()
 
 	// Get date
 	prompt := promptui.Prompt{
@@ -66,28 +65,28 @@ func runAdd(cmd *cobra.Command, args []string) error {
 	fmt.Println("Each interval must start where the previous one ended.")
 	fmt.Println("The last interval must end at 00:00 to complete the day.")
 
-	var intervals []db.BasalInterval
+	var intervals schedule.Schedule
 	for {
 		// Get start time
-		startTimeDefault := "00:00"
+		startTimeDefault := schedule.Midnight
 		if len(intervals) > 0 {
 			startTimeDefault = intervals[len(intervals)-1].EndTime
 		}
 
 		startPrompt := promptui.Prompt{
-			Label:   "Start time (HH:MM, H:MM, or HHMM format)",
-			Default: startTimeDefault,
+			Label:   "Start time (HH:MM, H:MM, HH:MM:SS, or HHMM format)",
+			Default: startTimeDefault.String(),
 			Validate: func(input string) error {
-				normalized, err := parseTimeFormat(input)
+				start, err := schedule.ParseTimeOfDay(input)
 				if err != nil {
 					return err
 				}
-				if len(intervals) == 0 && normalized != "00:00" {
+				if len(intervals) == 0 && start != schedule.Midnight {
 					return fmt.Errorf("first interval must start at 00:00")
 				}
 				if len(intervals) > 0 {
 					lastInterval := intervals[len(intervals)-1]
-					if normalized != lastInterval.EndTime {
+					if start != lastInterval.EndTime {
 						return fmt.Errorf("start time must match previous end time (%s)", lastInterval.EndTime)
 					}
 				}
@@ -95,46 +94,44 @@ func runAdd(cmd *cobra.Command, args []string) error {
 			},
 		}
 
-		startTime, err := startPrompt.Run()
+		startInput, err := startPrompt.Run()
 		if err != nil {
 			return fmt.Errorf("start time prompt failed: %v", err)
 		}
 
-		startTime, err = parseTimeFormat(startTime)
+		startTime, err := schedule.ParseTimeOfDay(startInput)
 		if err != nil {
 			return fmt.Errorf("invalid start time: %v", err)
 		}
 
 		// Get end time
 		endPrompt := promptui.Prompt{
-			Label: "End time (HH:MM, H:MM, or HHMM format)",
+			Label: "End time (HH:MM, H:MM, HH:MM:SS, or HHMM format)",
 			Validate: func(input string) error {
-				normalized, err := parseTimeFormat(input)
+				end, err := schedule.ParseTimeOfDay(input)
 				if err != nil {
 					return err
 				}
 
 				// Special case: Allow "00:00" as end time (represents midnight at end of day)
-				if normalized == "00:00" {
+				if end == schedule.Midnight {
 					return nil
 				}
 
 				// For other times, ensure end time is after start time
-				startMinutes := convertTimeToMinutes(startTime)
-				endMinutes := convertTimeToMinutes(normalized)
-				if endMinutes <= startMinutes {
+				if end <= startTime {
 					return fmt.Errorf("end time must be after start time (%s)", startTime)
 				}
 				return nil
 			},
 		}
 
-		endTime, err := endPrompt.Run()
+		endInput, err := endPrompt.Run()
 		if err != nil {
 			return fmt.Errorf("end time prompt failed: %v", err)
 		}
 
-		endTime, err = parseTimeFormat(endTime)
+		endTime, err := schedule.ParseTimeOfDay(endInput)
 		if err != nil {
 			return fmt.Errorf("invalid end time: %v", err)
 		}
@@ -160,20 +157,20 @@ func runAdd(cmd *cobra.Command, args []string) error {
 		var units float64
 		fmt.Sscanf(unitsStr, "%f", &units)
 
-		intervals = append(intervals, db.BasalInterval{
+		intervals = append(intervals, schedule.Segment{
 			StartTime:    startTime,
 			EndTime:      endTime,
 			UnitsPerHour: units,
 		})
 
 		// If we've completed the day (ended at 00:00), break
-		if endTime == "00:00" {
+		if endTime == schedule.Midnight {
 			break
 		}
 	}
 
 	// Calculate daily total
-	dailyTotal := db.CalculateDailyBasal(intervals)
+	dailyTotal := intervals.TotalUnits()
 
 	// Show summary and get confirmation
 	fmt.Printf("\nDaily Summary for %s:\n", date.Format(db.DateFormat))
@@ -195,7 +192,7 @@ func runAdd(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create the record
-	err = db.CreateBasalRecord(database, date, intervals)
+	_, err = store.CreateBasalRecord(date, intervals)
 	if err != nil {
 		return fmt.Errorf("error creating basal record: %v", err)
 	}
@@ -203,54 +200,3 @@ func runAdd(cmd *cobra.Command, args []string) error {
 	fmt.Println("Basal rates added successfully!")
 	return nil
 }
-
-// parseTimeFormat converts various time formats to a standardized HH:MM format
-func parseTimeFormat(input string) (string, error) {
-	// Remove any spaces
-	input = strings.TrimSpace(input)
-
-	// Try different formats
-	var hour, min int
-	var err error
-
-	// Try H:MM format
-	if n, _ := fmt.Sscanf(input, "%d:%d", &hour, &min); n == 2 {
-		if hour < 0 || hour > 23 || min < 0 || min > 59 {
-			return "", fmt.Errorf("invalid time: hours must be 0-23, minutes must be 0-59")
-		}
-		return fmt.Sprintf("%02d:%02d", hour, min), nil
-	}
-
-	// Try HMM format (e.g., "230" for 2:30)
-	if len(input) == 3 {
-		if hour, err = strconv.Atoi(input[:1]); err == nil {
-			if min, err = strconv.Atoi(input[1:]); err == nil {
-				if hour >= 0 && hour <= 23 && min >= 0 && min <= 59 {
-					return fmt.Sprintf("%02d:%02d", hour, min), nil
-				}
-			}
-		}
-	}
-
-	// Try HHMM format (e.g., "0230" for 02:30)
-	if len(input) == 4 {
-		if hour, err = strconv.Atoi(input[:2]); err == nil {
-			if min, err = strconv.Atoi(input[2:]); err == nil {
-				if hour >= 0 && hour <= 23 && min >= 0 && min <= 59 {
-					return fmt.Sprintf("%02d:%02d", hour, min), nil
-				}
-			}
-		}
-	}
-
-	return "", fmt.Errorf("invalid time format: use HH:MM, H:MM, HMM, or HHMM")
-}
-
-// convertTimeToMinutes converts HH:MM format to minutes since midnight
-func convertTimeToMinutes(timeStr string) int {
-	var hour, min int
-	fmt.Sscanf(timeStr, "%d:%d", &hour, &min)
-	return hour*60 + min
-}
-
-// hasTimeOverlap is no longer needed since we enforce continuous intervals
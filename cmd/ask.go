@@ -1,15 +1,18 @@
 package cmd
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"time"
 
-	"basal/cmd/ollama"
+	"basal/config"
 	"basal/db"
+	"basal/llm"
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
@@ -19,83 +22,52 @@ var askCmd = &cobra.Command{
 	Use:   "ask [natural language question]",
 	Short: "Ask questions about your basal rates",
 	Long: `Ask questions about your basal rates in natural language.
-Uses a local LLM via Ollama to convert natural language to SQL queries and interpret results.`,
-	Args: cobra.MinimumNArgs(1),
+Uses an LLM (Ollama or any OpenAI-compatible server) to convert natural language to SQL queries and interpret results.
+Common questions, such as the rate or total on a date or the highest total day,
+are answered directly without the LLM.`,
+	Args: askArgs,
 	RunE: runAsk,
 }
 
-// tableOutputWriter captures table output while also writing to stdout
-type tableOutputWriter struct {
-	output io.Writer
-	buf    strings.Builder
-}
-
-func (w *tableOutputWriter) Write(p []byte) (n int, err error) {
-	w.buf.Write(p)
-	return w.output.Write(p)
-}
-
 func init() {
 	rootCmd.AddCommand(askCmd)
+	askCmd.Flags().Int("max-rows", db.DefaultMaxRows, "Maximum number of result rows to show")
+	askCmd.Flags().Int("retries", 2, "How many times to ask the model to fix a failing query")
+	askCmd.Flags().BoolP("verbose", "v", false, "Show every generated query and why it failed, or every tool call")
+	askCmd.Flags().Bool("offline", false, "Only answer questions the built-in parser understands, without the LLM")
+	askCmd.Flags().Bool("tools", false, "Let the model look up data with tool calls instead of writing SQL")
+	askCmd.Flags().Bool("explain", false, "Show the query plan and where each value in the answer comes from")
+	askCmd.Flags().Bool("history", false, "List earlier questions")
+	askCmd.Flags().String("rerun", "", "Run the query of a history entry (ID or saved name) again, without the LLM")
+	askCmd.Flags().String("save", "", "Save the question and its query as a named report")
+	askCmd.Flags().String("delete", "", "Delete a history entry (ID or saved name)")
+	askCmd.Flags().Bool("no-cache", false, "Ask the model for a new query even if the question was answered before")
 }
 
-// validateSQLQuery performs basic validation of the SQL query
-func validateSQLQuery(query string) error {
-	query = strings.TrimSpace(strings.ToLower(query))
-	if !strings.HasPrefix(query, "select") {
-		return fmt.Errorf("invalid query: only SELECT queries are allowed")
-	}
-	if strings.Contains(query, "drop") || strings.Contains(query, "delete") || strings.Contains(query, "update") || strings.Contains(query, "insert") {
-		return fmt.Errorf("invalid query: only SELECT queries are allowed")
-	}
-	return nil
-}
+// errInterrupted is returned when Ctrl-C cancels a request to the model.
+var errInterrupted = errors.New("interrupted")
 
-// callOllama makes a request to the Ollama API and returns the response
-func callOllama(llmConfig *LLMConfig, prompt string) (string, error) {
-	req := ollama.Request{
-		Model: llmConfig.Model,
-		Messages: []ollama.Message{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
-		Stream: false,
-	}
+// interruptible returns a context that Ctrl-C cancels, so that a request to
+// the model can be abandoned without killing basal. Call stop to restore the
+// default Ctrl-C behaviour.
+func interruptible(parent context.Context) (ctx context.Context, stop context.CancelFunc) {
+	return signal.NotifyContext(parent, os.Interrupt)
+}
 
-	jsonData, err := json.Marshal(req)
+// getLLMInterpretation asks the LLM to interpret the query results, writing
+// the answer to w as it is generated.
+func getLLMInterpretation(ctx context.Context, provider llm.Provider, originalQuestion string, tableOutput string, w io.Writer) (string, error) {
+	data, err := newPromptData(originalQuestion)
 	if err != nil {
-		return "", fmt.Errorf("error marshaling request: %v", err)
+		return "", err
 	}
-
-	apiURL := fmt.Sprintf("%s/api/chat", llmConfig.Endpoint)
-	resp, err := http.Post(apiURL, "application/json", bytes.NewBuffer(jsonData))
+	data.Results = tableOutput
+	prompt, err := renderPrompt(promptInterpret, data)
 	if err != nil {
-		return "", fmt.Errorf("error calling Ollama API: %v", err)
-	}
-	defer resp.Body.Close()
-
-	var ollamaResp ollama.Response
-	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-		return "", fmt.Errorf("error decoding response: %v", err)
+		return "", err
 	}
 
-	return ollamaResp.Message.Content, nil
-}
-
-// getLLMInterpretation asks the LLM to interpret the query results
-func getLLMInterpretation(llmConfig *LLMConfig, originalQuestion string, tableOutput string) (string, error) {
-	prompt := fmt.Sprintf(`You are a helpful assistant that interprets SQL query results in natural language.
-The user asked: %s
-
-Here are the query results:
-%s
-
-Please provide a clear, concise answer to the user's question based on these results.
-Keep your response brief and focused on answering the specific question asked.`, originalQuestion, tableOutput)
-
-	return callOllama(llmConfig, prompt)
+	return llm.Stream(ctx, provider, []llm.Message{{Role: "user", Content: prompt}}, w)
 }
 
 // renderTable creates and renders a table with the given columns and rows
@@ -112,130 +84,299 @@ func renderTable(writer io.Writer, columns []string, rows [][]string) {
 	table.Render()
 }
 
-func runAsk(cmd *cobra.Command, args []string) error {
-	query := strings.Join(args, " ")
+// sqlPrompt returns the prompt asking the model to translate question into
+// SQL, describing the data with dataDescription from describeData. Without a
+// question it returns just the instructions.
+func sqlPrompt(question, dataDescription string) (string, error) {
+	data, err := newPromptData(question)
+	if err != nil {
+		return "", err
+	}
+	data.Data = dataDescription
+	return renderPrompt(promptSQL, data)
+}
 
-	// Get LLM configuration
-	llmConfig, err := getLLMConfig()
+// repairPrompt returns the follow-up sent when a generated query failed.
+func repairPrompt(queryErr error) (string, error) {
+	data, err := newPromptData("")
 	if err != nil {
-		return fmt.Errorf("error getting LLM configuration: %v", err)
+		return "", err
 	}
+	data.Error = queryErr.Error()
+	return renderPrompt(promptRepair, data)
+}
 
-	// Get database schema
-	schema := db.GetSchema()
+// extractSQL pulls the query out of a model reply, removing markdown code
+// fences and any text around them.
+func extractSQL(reply string) string {
+	reply = strings.TrimSpace(reply)
+
+	if start := strings.Index(reply, "```"); start >= 0 {
+		body := reply[start+3:]
+		// Drop the language tag, e.g. ```sql
+		if nl := strings.IndexByte(body, '\n'); nl >= 0 {
+			switch strings.ToLower(strings.TrimSpace(body[:nl])) {
+			case "", "sql", "sqlite":
+				body = body[nl+1:]
+			}
+		}
+		if end := strings.Index(body, "```"); end >= 0 {
+			body = body[:end]
+		}
+		reply = strings.TrimSpace(body)
+	}
 
-	prompt := fmt.Sprintf(`You are an SQL expert. Convert the following natural language question into a SQL query that will work with SQLite.
-Here's the database schema:
-%s
+	return strings.TrimSpace(strings.TrimPrefix(reply, "`"))
+}
 
-The query should return meaningful information about basal rates based on the user's question.
-Keep queries as simple as possible - don't add unnecessary complexity.
+// sqlAnswer is a query that ran successfully and its results.
+type sqlAnswer struct {
+	SQL      string
+	Result   *db.QueryResult
+	Attempts int
+}
 
-IMPORTANT:
-1. Return ONLY the SQL query, no explanations or markdown formatting
-2. Start with SELECT
-3. Use SQLite-specific syntax:
-   - Use strftime('%Y-%m-%d', date) for date formatting
-   - Use time('now') instead of now() for current time
-   - Use date('now') instead of now() for current date
-   - Use proper time format (HH:MM) for time comparisons
-   - Use proper date format (YYYY-MM-DD) for date comparisons
+// generateSQL asks the model for a query answering the last message of
+// conversation and runs it in sandbox. When the query is rejected or fails,
+// the error is sent back for up to retries more attempts. Each attempt is
+// reported to verbose if it is not nil. The returned conversation includes
+// the model's replies.
+func generateSQL(ctx context.Context, provider llm.Provider, sandbox *db.Sandbox, conversation []llm.Message, retries int, verbose io.Writer) (*sqlAnswer, []llm.Message, error) {
+	var lastErr error
+	for attempt := 1; attempt <= retries+1; attempt++ {
+		reply, err := provider.Chat(ctx, conversation)
+		if err != nil {
+			return nil, conversation, err
+		}
+		conversation = append(conversation, llm.Message{Role: "assistant", Content: reply})
 
-Examples:
-- For "which day has the greatest total_units": 
-  SELECT date, total_units FROM basal_records ORDER BY total_units DESC LIMIT 1
-- For "what was my basal rate on Dec 2, 2023":
-  SELECT * FROM basal_records WHERE date = '2023-12-02'
+		query := extractSQL(reply)
+		if verbose != nil {
+			fmt.Fprintf(verbose, "\nAttempt %d:\n%s\n", attempt, query)
+		}
 
-Natural language question: %s`, schema, query)
+		result, err := sandbox.Query(ctx, query)
+		if err == nil {
+			return &sqlAnswer{SQL: query, Result: result, Attempts: attempt}, conversation, nil
+		}
+		if ctx.Err() != nil {
+			return nil, conversation, ctx.Err()
+		}
 
-	sqlQuery, err := callOllama(llmConfig, prompt)
+		lastErr = err
+		if verbose != nil {
+			fmt.Fprintf(verbose, "Failed: %v\n", err)
+		}
+		repair, err := repairPrompt(err)
+		if err != nil {
+			return nil, conversation, err
+		}
+		conversation = append(conversation, llm.Message{Role: "user", Content: repair})
+	}
+
+	return nil, conversation, fmt.Errorf("could not answer the question: no working query after %d attempts (last error: %v)", retries+1, lastErr)
+}
+
+func runAsk(cmd *cobra.Command, args []string) error {
+	query := strings.Join(args, " ")
+
+	saveAs, _ := cmd.Flags().GetString("save")
+	if saveAs != "" {
+		if err := checkReportName(saveAs); err != nil {
+			return err
+		}
+	}
+
+	store, err := openStore()
 	if err != nil {
 		return err
 	}
+	defer store.Close()
 
-	sqlQuery = strings.TrimSpace(sqlQuery)
-	fmt.Printf("\nGenerated SQL query:\n%s\n\n", sqlQuery)
+	if history, _ := cmd.Flags().GetBool("history"); history {
+		return printAskHistory(cmd.OutOrStdout(), store)
+	}
+	if rerun, _ := cmd.Flags().GetString("rerun"); rerun != "" {
+		return rerunAsk(cmd, store, rerun, saveAs)
+	}
+	if ref, _ := cmd.Flags().GetString("delete"); ref != "" {
+		return deleteAsk(cmd.OutOrStdout(), store, ref)
+	}
 
-	// Validate SQL query before database operations
-	if err := validateSQLQuery(sqlQuery); err != nil {
-		return err
+	// Common questions are answered directly, without the LLM
+	offline, _ := cmd.Flags().GetBool("offline")
+	if intent, ok := parseIntent(query, time.Now()); ok {
+		fmt.Println()
+		if err := intent.answer(cmd.OutOrStdout(), store); err != nil {
+			return err
+		}
+		if saveAs != "" {
+			fmt.Fprintln(cmd.ErrOrStderr(), "\nNot saved: questions answered without the LLM have no query to save.")
+		}
+		return nil
+	}
+	if offline {
+		return fmt.Errorf("could not understand the question without the LLM; try questions like:\n  %s", strings.Join(offlineExamples, "\n  "))
 	}
 
-	// Execute the SQL query
-	dbPath, err := getDBPath()
+	cfg, err := loadConfig()
 	if err != nil {
-		return fmt.Errorf("error getting database path: %v", err)
+		return fmt.Errorf("error getting LLM configuration: %v", err)
 	}
-	database, err := db.InitDB(dbPath)
+	pc, err := llmConfig(cfg)
 	if err != nil {
-		return fmt.Errorf("error initializing database: %v", err)
+		return err
 	}
-	defer database.Close()
-
-	rows, err := database.Query(sqlQuery)
+	provider, err := llm.New(pc)
 	if err != nil {
-		return fmt.Errorf("error executing query: %v\nQuery: %s", err, sqlQuery)
+		return fmt.Errorf("error getting LLM configuration: %v", err)
+	}
+	privacy := newPrivacyPolicy(cfg)
+	start := time.Now()
+
+	ctx, stop := interruptible(cmd.Context())
+	defer stop()
+
+	var verbose io.Writer
+	if v, _ := cmd.Flags().GetBool("verbose"); v {
+		verbose = cmd.ErrOrStderr()
+	}
+
+	if useTools, _ := cmd.Flags().GetBool("tools"); useTools {
+		caller, ok := provider.(llm.ToolCaller)
+		if !ok {
+			return fmt.Errorf("the %s provider does not support tool calling", provider.Name())
+		}
+		if privacy.Results == config.ResultsAggregates {
+			return fmt.Errorf("--tools sends records to the model, which privacy.results = %s does not allow", config.ResultsAggregates)
+		}
+		answer, err := askWithTools(ctx, caller, store, query, privacy.MaxRows, verbose)
+		if err != nil {
+			if ctx.Err() != nil {
+				return errInterrupted
+			}
+			return err
+		}
+		fmt.Printf("\nAnswer: %s\n", answer)
+		recordAsk(cmd.ErrOrStderr(), store, db.AskEntry{
+			Question: query,
+			Model:    cfg.LLM.Model,
+			Latency:  time.Since(start),
+			Answer:   answer,
+		}, saveAs)
+		return nil
 	}
-	defer rows.Close()
 
-	// Get column names
-	columns, err := rows.Columns()
+	// Execute queries in a read-only sandbox
+	sandbox, err := db.OpenSandbox(store)
 	if err != nil {
-		return fmt.Errorf("error getting columns: %v", err)
+		return fmt.Errorf("error opening database for queries: %v", err)
 	}
+	defer sandbox.Close()
+	sandbox.MaxRows, _ = cmd.Flags().GetInt("max-rows")
 
-	// Create a custom writer to capture table output
-	outputWriter := &tableOutputWriter{
-		output: cmd.OutOrStdout(),
+	retries, _ := cmd.Flags().GetInt("retries")
+
+	description, err := describeData(ctx, sandbox, privacy.exampleRows(dataExampleRows))
+	if err != nil {
+		return err
 	}
 
-	// Prepare values holder
-	values := make([]interface{}, len(columns))
-	valuePtrs := make([]interface{}, len(columns))
-	for i := range values {
-		valuePtrs[i] = &values[i]
+	// A question asked before reuses its query, skipping the first model call
+	cacheKey, err := askCacheKey(query, cfg.LLM.Model, description, time.Now())
+	if err != nil {
+		return err
+	}
+	var answer *sqlAnswer
+	if noCache, _ := cmd.Flags().GetBool("no-cache"); !noCache {
+		var cached *db.AskEntry
+		answer, cached, err = cachedSQL(ctx, store, sandbox, cacheKey)
+		if err != nil {
+			return fmt.Errorf("error reading ask history: %v", err)
+		}
+		if cached != nil && answer == nil && verbose != nil {
+			fmt.Fprintf(verbose, "\nThe cached query from history entry %d failed; asking the model.\n", cached.ID)
+		}
+		if answer != nil {
+			fmt.Printf("\nSQL query (cached from history entry %d; use --no-cache to ask the model again):\n%s\n\n", cached.ID, answer.SQL)
+		}
 	}
 
-	// Collect all rows
-	var tableRows [][]string
-	for rows.Next() {
-		err := rows.Scan(valuePtrs...)
+	if answer == nil {
+		prompt, err := sqlPrompt(query, description)
+		if err != nil {
+			return err
+		}
+		conversation := []llm.Message{{Role: "user", Content: prompt}}
+		answer, _, err = generateSQL(ctx, provider, sandbox, conversation, retries, verbose)
 		if err != nil {
-			return fmt.Errorf("error scanning row: %v", err)
-		}
-
-		// Convert values to strings
-		stringValues := make([]string, len(columns))
-		for i, val := range values {
-			if val == nil {
-				stringValues[i] = "NULL"
-			} else {
-				switch v := val.(type) {
-				case []byte:
-					stringValues[i] = string(v)
-				default:
-					stringValues[i] = fmt.Sprintf("%v", v)
-				}
+			if ctx.Err() != nil {
+				return errInterrupted
 			}
+			return err
 		}
 
-		tableRows = append(tableRows, stringValues)
+		fmt.Printf("\nGenerated SQL query:\n%s\n\n", answer.SQL)
+		if answer.Attempts > 1 {
+			fmt.Printf("(corrected after %d attempts)\n\n", answer.Attempts)
+		}
 	}
 
-	if err = rows.Err(); err != nil {
-		return fmt.Errorf("error iterating rows: %v", err)
+	explain, _ := cmd.Flags().GetBool("explain")
+	if explain {
+		plan, err := sandbox.QueryPlan(ctx, answer.SQL)
+		if err != nil {
+			return fmt.Errorf("error getting query plan: %v", err)
+		}
+		fmt.Println("Query plan:")
+		for _, step := range plan {
+			fmt.Printf("  %s\n", step)
+		}
+		fmt.Printf("\nThe answer is derived from these %d rows:\n", len(answer.Result.Rows))
 	}
 
-	// Render the table
-	renderTable(outputWriter, columns, tableRows)
+	renderTable(cmd.OutOrStdout(), answer.Result.Columns, answer.Result.Rows)
+	if answer.Result.Truncated {
+		fmt.Printf("(showing the first %d rows)\n", sandbox.MaxRows)
+	}
 
-	// Get LLM interpretation of the results
-	interpretation, err := getLLMInterpretation(llmConfig, query, outputWriter.buf.String())
+	// The model only sees what the privacy settings allow
+	sharedText, shared := privacy.share(answer.Result, len(answer.Result.Rows))
+	summarized := privacy.Results == config.ResultsAggregates
+	if explain && (summarized || len(shared.Rows) < len(answer.Result.Rows)) {
+		fmt.Printf("\nThe model was only shown:\n%s", sharedText)
+	}
+
+	// Stream the LLM interpretation of the results
+	fmt.Print("\nAnswer: ")
+	interpretation, err := getLLMInterpretation(ctx, provider, query, sharedText, os.Stdout)
+	fmt.Println()
 	if err != nil {
+		if ctx.Err() != nil {
+			return errInterrupted
+		}
 		return fmt.Errorf("error getting interpretation: %v", err)
 	}
 
-	fmt.Printf("\nAnswer: %s\n", interpretation)
+	// Check the numbers in the answer against the results the model was given
+	mentions := traceAnswer(interpretation, query, shared, summarized)
+	if explain {
+		printProvenance(cmd.OutOrStdout(), mentions)
+	}
+	if missing := unsupported(mentions); len(missing) > 0 {
+		fmt.Fprintf(cmd.ErrOrStderr(), "\nWarning: values in the answer not found in the query results: %s. Check them before relying on the answer.\n",
+			strings.Join(missing, ", "))
+	}
+
+	recordAsk(cmd.ErrOrStderr(), store, db.AskEntry{
+		Question: query,
+		SQL:      answer.SQL,
+		Columns:  answer.Result.Columns,
+		Rows:     answer.Result.Rows,
+		Model:    cfg.LLM.Model,
+		Latency:  time.Since(start),
+		Answer:   interpretation,
+		CacheKey: cacheKey,
+	}, saveAs)
 	return nil
 }
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"basal/db"
+)
+
+// relativeWords make a question's query depend on the day it is asked, so
+// its cached query is only reused on the same day.
+var relativeWords = []string{
+	"today", "yesterday", "tomorrow", "now", "current", "currently",
+	"this", "last", "past", "previous", "recent", "recently", "ago",
+}
+
+// normalizeQuestion reduces a question to the words that matter, so that
+// "What's my total?" and "what's my total" share a cached query.
+func normalizeQuestion(question string) string {
+	fields := strings.Fields(strings.ToLower(question))
+	return strings.TrimRight(strings.Join(fields, " "), "?!. ")
+}
+
+// askCacheKey returns the key a query answering question is cached under. It
+// covers the normalized question, the model and the whole SQL prompt,
+// including dataDescription from describeData, so changes to the schema, the
+// prompt templates, the examples or the records all start a fresh cache.
+// Today's date is only included when the question refers to it.
+func askCacheKey(question, model, dataDescription string, now time.Time) (string, error) {
+	question = normalizeQuestion(question)
+	data, err := newPromptData(question)
+	if err != nil {
+		return "", err
+	}
+	data.Data = dataDescription
+	data.Today = ""
+	for _, word := range relativeWords {
+		if hasWord(question, word) {
+			data.Today = now.Format(db.DateFormat)
+			break
+		}
+	}
+	prompt, err := renderPrompt(promptSQL, data)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(model + "\x00" + prompt))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// cachedSQL runs the query cached under key. It returns the history entry
+// the query came from, if any, and the answer if the query still works.
+func cachedSQL(ctx context.Context, store db.Store, sandbox *db.Sandbox, key string) (*sqlAnswer, *db.AskEntry, error) {
+	entry, err := store.GetAskEntryByCacheKey(key)
+	if errors.Is(err, db.ErrNoAskEntry) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, err := sandbox.Query(ctx, entry.SQL)
+	if err != nil {
+		return nil, entry, nil
+	}
+	return &sqlAnswer{SQL: entry.SQL, Result: result}, entry, nil
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"basal/db"
+)
+
+var cacheTestDay = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// testCacheKey returns the key of question for the data in store.
+func testCacheKey(t *testing.T, store db.Store, question, model string, now time.Time) string {
+	t.Helper()
+	sandbox, err := db.OpenSandbox(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sandbox.Close()
+	description, err := describeData(context.Background(), sandbox, dataExampleRows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := askCacheKey(question, model, description, now)
+	if err != nil {
+		t.Fatalf("askCacheKey(%q): %v", question, err)
+	}
+	return key
+}
+
+// isolatePrompts points the prompts at an empty directory for the rest of
+// the test and returns it.
+func isolatePrompts(t *testing.T) string {
+	t.Helper()
+	configFlag = filepath.Join(t.TempDir(), "config.toml")
+	t.Cleanup(func() { configFlag = "" })
+	return filepath.Join(filepath.Dir(configFlag), "prompts")
+}
+
+func TestAskCacheKeyNormalizesQuestion(t *testing.T) {
+	isolatePrompts(t)
+	store := toolTestStore(t)
+	want := testCacheKey(t, store, "what was my highest total", "llama3", cacheTestDay)
+	for _, question := range []string{
+		"What was my highest total?",
+		"  what   was my\thighest total  ",
+		"WHAT WAS MY HIGHEST TOTAL!",
+		"what was my highest total...",
+	} {
+		if got := testCacheKey(t, store, question, "llama3", cacheTestDay); got != want {
+			t.Errorf("%q has a different key than the normalized question", question)
+		}
+	}
+	if got := testCacheKey(t, store, "what was my lowest total", "llama3", cacheTestDay); got == want {
+		t.Error("a different question has the same key")
+	}
+}
+
+func TestAskCacheKeyChanges(t *testing.T) {
+	dir := isolatePrompts(t)
+	store := toolTestStore(t)
+	base := testCacheKey(t, store, "what was my highest total", "llama3", cacheTestDay)
+
+	if got := testCacheKey(t, store, "what was my highest total", "mistral", cacheTestDay); got == base {
+		t.Error("a different model has the same key")
+	}
+
+	// Only questions about a relative day depend on the date
+	if got := testCacheKey(t, store, "what was my highest total", "llama3", cacheTestDay.AddDate(0, 0, 1)); got != base {
+		t.Error("the next day changed the key of a question without a relative day")
+	}
+	yesterday := testCacheKey(t, store, "what was my total yesterday", "llama3", cacheTestDay)
+	if got := testCacheKey(t, store, "what was my total yesterday", "llama3", cacheTestDay.AddDate(0, 0, 1)); got == yesterday {
+		t.Error("the next day has the same key for a question about yesterday")
+	}
+
+	// A changed prompt template
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sqlTemplate, _, err := readPromptFile(promptSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, promptSQL), []byte(sqlTemplate+"\nUse SQLite syntax only."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := testCacheKey(t, store, "what was my highest total", "llama3", cacheTestDay); got == base {
+		t.Error("a changed prompt template has the same key")
+	}
+}
+
+func TestAskCacheKeyFollowsRecords(t *testing.T) {
+	isolatePrompts(t)
+	store := toolTestStore(t)
+	before := testCacheKey(t, store, "what was my highest total", "llama3", cacheTestDay)
+
+	id := addTestRecord(t, store, "2024-09-01", 1.1)
+	inserted := testCacheKey(t, store, "what was my highest total", "llama3", cacheTestDay)
+	if inserted == before {
+		t.Error("an inserted record left the key unchanged")
+	}
+
+	if err := store.DeleteBasalRecord(id); err != nil {
+		t.Fatal(err)
+	}
+	deleted := testCacheKey(t, store, "what was my highest total", "llama3", cacheTestDay)
+	if deleted == inserted {
+		t.Error("a deleted record left the key unchanged")
+	}
+	if deleted != before {
+		t.Error("the same records have a different key")
+	}
+}
+
+func TestCachedSQLAfterInsert(t *testing.T) {
+	isolatePrompts(t)
+	store := toolTestStore(t)
+	question := "what was my highest total"
+	key := testCacheKey(t, store, question, "llama3", cacheTestDay)
+	if _, err := store.AddAskEntry(db.AskEntry{Question: question, SQL: "SELECT MAX(total_units) FROM basal_records", Model: "llama3", CacheKey: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func() *sqlAnswer {
+		t.Helper()
+		sandbox, err := db.OpenSandbox(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sandbox.Close()
+		answer, _, err := cachedSQL(context.Background(), store, sandbox, testCacheKey(t, store, question, "llama3", cacheTestDay))
+		if err != nil {
+			t.Fatalf("cachedSQL: %v", err)
+		}
+		return answer
+	}
+	if lookup() == nil {
+		t.Fatal("the query was not cached")
+	}
+	addTestRecord(t, store, "2024-09-01", 2.0)
+	if answer := lookup(); answer != nil {
+		t.Errorf("after an insert the cached query %q was reused", answer.SQL)
+	}
+}
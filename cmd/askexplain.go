@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"basal/db"
+)
+
+// valuePattern finds the values an answer quotes: dates or months, times of
+// day and numbers, with or without thousands separators, tried in that order
+// so "2024-03-01" is not read as three numbers.
+var valuePattern = regexp.MustCompile(`(?i)\b\d{4}-\d{2}(?:-\d{2})?\b|\b\d{1,2}:\d{2}(?:\s*[ap]\.?m\b)?|\b\d{1,3}(?:,\d{3})+(?:\.\d+)?\b|\d+(?:\.\d+)?`)
+
+// isoDate matches a whole YYYY-MM-DD date or YYYY-MM month.
+var isoDate = regexp.MustCompile(`^\d{4}-\d{2}(-\d{2})?$`)
+
+// isoDatePrefix matches a cell holding a date, with or without a time.
+var isoDatePrefix = regexp.MustCompile(`^(\d{4})-(\d{2})-(\d{2})`)
+
+// maxSourcesShown limits how many matching cells --explain lists per value.
+const maxSourcesShown = 3
+
+// mention is a value quoted in an answer and where it can be found.
+type mention struct {
+	Text    string
+	Sources []string // e.g. "row 1, total_units"; empty if not found
+}
+
+// traceAnswer finds every value quoted in answer and looks for it in result,
+// so that numbers the model made up or got wrong stand out. Values may also
+// be the number of rows or come from the question. A summarized result is
+// one made by summarizeResult, with a row of statistics per column.
+func traceAnswer(answer, question string, result *db.QueryResult, summarized bool) []mention {
+	var mentions []mention
+	seen := map[string]bool{}
+	for _, text := range valuePattern.FindAllString(answer, -1) {
+		if seen[text] {
+			continue
+		}
+		seen[text] = true
+
+		m := mention{Text: text}
+		for i, row := range result.Rows {
+			for j, cell := range row {
+				if !cellMatches(text, cell) || (summarized && j == 0) {
+					continue
+				}
+				if summarized {
+					m.Sources = append(m.Sources, fmt.Sprintf("%s of %s", result.Columns[j], row[0]))
+				} else {
+					m.Sources = append(m.Sources, fmt.Sprintf("row %d, %s", i+1, result.Columns[j]))
+				}
+			}
+		}
+		if n, err := strconv.Atoi(strings.ReplaceAll(text, ",", "")); err == nil && n == len(result.Rows) && !summarized {
+			m.Sources = append(m.Sources, "number of rows")
+		}
+		if strings.Contains(question, text) {
+			m.Sources = append(m.Sources, "the question")
+		}
+		mentions = append(mentions, m)
+	}
+	return mentions
+}
+
+// cellMatches reports whether a value quoted as text could have been read
+// from cell: the same number to the precision quoted, the same date or part
+// of one, or the same time of day, also as seconds after midnight.
+func cellMatches(text, cell string) bool {
+	cell = strings.TrimSpace(cell)
+
+	if isoDate.MatchString(text) {
+		return strings.HasPrefix(cell, text)
+	}
+
+	if strings.Contains(text, ":") {
+		seconds, ok := parseAnswerTime(text)
+		if !ok {
+			return false
+		}
+		if v, err := strconv.Atoi(cell); err == nil {
+			return v == seconds || (seconds == 0 && v == 86400)
+		}
+		return strings.Contains(cell, fmt.Sprintf("%02d:%02d", seconds/3600, seconds%3600/60))
+	}
+
+	text = strings.ReplaceAll(text, ",", "")
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return false
+	}
+	if v, err := strconv.ParseFloat(cell, 64); err == nil {
+		decimals := 0
+		if dot := strings.IndexByte(text, '.'); dot >= 0 {
+			decimals = len(text) - dot - 1
+		}
+		scale := math.Pow(10, float64(decimals))
+		return math.Round(v*scale) == math.Round(value*scale)
+	}
+	// A year, month or day written out, e.g. "March 1, 2024"
+	if m := isoDatePrefix.FindStringSubmatch(cell); m != nil && !strings.Contains(text, ".") {
+		for _, part := range m[1:] {
+			if n, _ := strconv.Atoi(part); float64(n) == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseAnswerTime reads "3:00", "15:30" or "3:00 pm" as seconds after midnight.
+func parseAnswerTime(text string) (int, bool) {
+	text = strings.ToLower(text)
+	pm := strings.Contains(text, "p")
+	am := strings.Contains(text, "a")
+	clock := strings.TrimRight(text, " apm.")
+
+	hour, minute, ok := strings.Cut(clock, ":")
+	if !ok {
+		return 0, false
+	}
+	h, err1 := strconv.Atoi(hour)
+	m, err2 := strconv.Atoi(minute)
+	if err1 != nil || err2 != nil || h > 23 || m > 59 {
+		return 0, false
+	}
+	if pm || am {
+		if h < 1 || h > 12 {
+			return 0, false
+		}
+		h %= 12
+		if pm {
+			h += 12
+		}
+	}
+	return h*3600 + m*60, true
+}
+
+// unsupported returns the mentions that were not found anywhere.
+func unsupported(mentions []mention) []string {
+	var values []string
+	for _, m := range mentions {
+		if len(m.Sources) == 0 {
+			values = append(values, m.Text)
+		}
+	}
+	return values
+}
+
+// printProvenance lists where each value quoted in the answer came from.
+func printProvenance(w io.Writer, mentions []mention) {
+	if len(mentions) == 0 {
+		fmt.Fprintln(w, "\nThe answer quotes no values.")
+		return
+	}
+
+	rows := make([][]string, len(mentions))
+	for i, m := range mentions {
+		source := "NOT IN THE RESULTS"
+		if len(m.Sources) > 0 {
+			source = strings.Join(m.Sources[:min(len(m.Sources), maxSourcesShown)], "; ")
+			if more := len(m.Sources) - maxSourcesShown; more > 0 {
+				source += fmt.Sprintf(" (+%d more)", more)
+			}
+		}
+		rows[i] = []string{m.Text, source}
+	}
+	fmt.Fprintln(w, "\nWhere the values in the answer come from:")
+	renderTable(w, []string{"Value", "Found in"}, rows)
+}
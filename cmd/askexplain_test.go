@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"basal/db"
+)
+
+func TestCellMatches(t *testing.T) {
+	tests := []struct {
+		text, cell string
+		want       bool
+	}{
+		// Numbers, to the precision quoted
+		{"2", "2", true},
+		{"2", "2.00", true},
+		{"2.00", "2", true},
+		{"2.0", "2.04", true},
+		{"2.00", "2.04", false},
+		{"2", "2.4", true},
+		{"2", "2.6", false},
+		{"22.8", "22.799999999999997", true},
+		{"0.85", "0.8", false},
+		{"3", "abc", false},
+
+		// Thousands separators
+		{"1,234", "1234", true},
+		{"1,234.5", "1234.5", true},
+		{"12,345.67", "12345.666", true},
+		{"1,234", "1243", false},
+
+		// Dates and months
+		{"2024-03-01", "2024-03-01", true},
+		{"2024-03-01", "2024-03-01 06:00:00", true},
+		{"2024-03", "2024-03-15", true},
+		{"2024-03-01", "2024-03-02", false},
+		{"2024-03-01", "2024-03", false},
+		{"2024", "2024-03-15", true},
+		{"15", "2024-03-15", true},
+		{"3", "2024-03-15", true},
+		{"16", "2024-03-15", false},
+		{"15.0", "2024-03-15", false},
+
+		// Times of day
+		{"06:00", "21600", true},
+		{"6:00", "06:00", true},
+		{"6:00 pm", "64800", true},
+		{"12:00 am", "0", true},
+		{"00:00", "86400", true},
+		{"7:30", "21600", false},
+		{"13:00 pm", "46800", false},
+	}
+	for _, tt := range tests {
+		if got := cellMatches(tt.text, tt.cell); got != tt.want {
+			t.Errorf("cellMatches(%q, %q) = %v, want %v", tt.text, tt.cell, got, tt.want)
+		}
+	}
+}
+
+// traced returns what traceAnswer found for each value, as "value: sources".
+func traced(mentions []mention) []string {
+	var lines []string
+	for _, m := range mentions {
+		lines = append(lines, m.Text+": "+strings.Join(m.Sources, "; "))
+	}
+	return lines
+}
+
+func TestTraceAnswer(t *testing.T) {
+	result := &db.QueryResult{
+		Columns: []string{"date", "total_units"},
+		Rows: [][]string{
+			{"2024-01-01", "22.8"},
+			{"2024-03-01", "1234.5"},
+			{"2024-06-01", "2.00"},
+		},
+	}
+	tests := []struct {
+		answer, question string
+		want             []string
+	}{
+		{"Your total was 22.80 units on 2024-01-01.", "",
+			[]string{"22.80: row 1, total_units", "2024-01-01: row 1, date"}},
+		{"It peaked at 1,234.5 units in 2024-03.", "",
+			[]string{"1,234.5: row 2, total_units", "2024-03: row 2, date"}},
+		// 2 is both a value and a day of a date in the result
+		{"On 2024-06-01 it was 2 units.", "",
+			[]string{"2024-06-01: row 3, date", "2: row 3, total_units"}},
+		{"There are 3 records.", "",
+			[]string{"3: row 2, date; number of rows"}},
+		// Values that are not in the result stand out
+		{"Your total was 25.4 units on 2024-02-01.", "",
+			[]string{"25.4: ", "2024-02-01: "}},
+		{"The total was 1,235.5 units.", "",
+			[]string{"1,235.5: "}},
+		{"Since 2023-12-01 the total rose.", "what changed since 2023-12-01?",
+			[]string{"2023-12-01: the question"}},
+		{"No values here.", "", nil},
+	}
+	for _, tt := range tests {
+		got := traced(traceAnswer(tt.answer, tt.question, result, false))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("traceAnswer(%q):\n got %q\nwant %q", tt.answer, got, tt.want)
+		}
+	}
+
+	mentions := traceAnswer("Your total was 25.4 units, or 22.8.", "", result, false)
+	if got := unsupported(mentions); !reflect.DeepEqual(got, []string{"25.4"}) {
+		t.Errorf("unsupported = %q, want only 25.4", got)
+	}
+}
+
+func TestTraceSummarizedAnswer(t *testing.T) {
+	summary := &db.QueryResult{
+		Columns: []string{"column", "min", "max"},
+		Rows: [][]string{
+			{"total_units", "22.8", "27.6"},
+		},
+	}
+	got := traced(traceAnswer("Between 22.8 and 27.6 units, across 1 column.", "", summary, true))
+	want := []string{"22.8: min of total_units", "27.6: max of total_units", "1: "}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("traceAnswer on a summary:\n got %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"basal/db"
+
+	"github.com/spf13/cobra"
+)
+
+// historyQuestionWidth is how much of each question 'ask --history' shows.
+const historyQuestionWidth = 60
+
+// askArgs requires a question unless --history, --rerun or --delete is
+// given, which take none.
+func askArgs(cmd *cobra.Command, args []string) error {
+	history, _ := cmd.Flags().GetBool("history")
+	rerun, _ := cmd.Flags().GetString("rerun")
+	del, _ := cmd.Flags().GetString("delete")
+	if history || rerun != "" || del != "" {
+		if len(args) > 0 {
+			return fmt.Errorf("--history, --rerun and --delete take no question")
+		}
+		return nil
+	}
+	return cobra.MinimumNArgs(1)(cmd, args)
+}
+
+// checkReportName rejects names that could be mistaken for an entry ID.
+func checkReportName(name string) error {
+	if _, err := strconv.ParseInt(name, 10, 64); err == nil {
+		return fmt.Errorf("report name %q cannot be a number", name)
+	}
+	return nil
+}
+
+// findAskEntry looks up an ask history entry by ID or saved name.
+func findAskEntry(store db.Store, ref string) (*db.AskEntry, error) {
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		return store.GetAskEntry(id)
+	}
+	return store.GetAskEntryByName(ref)
+}
+
+// recordAsk adds an answered question to the ask history and, if name is
+// set, saves it as a report. Failures are reported as warnings, since the
+// question has already been answered.
+func recordAsk(w io.Writer, store db.Store, entry db.AskEntry, name string) {
+	entry.Name = name
+	id, err := store.AddAskEntry(entry)
+	if err != nil {
+		fmt.Fprintf(w, "Warning: could not save the question to the history: %v\n", err)
+		return
+	}
+	if name != "" {
+		fmt.Fprintf(w, "\nSaved as %q (history entry %d). Run it again with 'basal ask --rerun %s'.\n", name, id, name)
+	}
+}
+
+// printAskHistory lists earlier questions, newest first.
+func printAskHistory(w io.Writer, store db.Store) error {
+	entries, err := store.ListAskEntries()
+	if err != nil {
+		return fmt.Errorf("error reading ask history: %v", err)
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No questions asked yet.")
+		return nil
+	}
+
+	rows := make([][]string, len(entries))
+	for i, e := range entries {
+		question := e.Question
+		if r := []rune(question); len(r) > historyQuestionWidth {
+			question = string(r[:historyQuestionWidth-3]) + "..."
+		}
+		mode := "sql"
+		if e.SQL == "" {
+			mode = "tools"
+		}
+		rows[i] = []string{
+			strconv.FormatInt(e.ID, 10),
+			e.CreatedAt.Local().Format("2006-01-02 15:04"),
+			e.Name,
+			question,
+			e.Model,
+			mode,
+			e.Latency.Round(time.Millisecond).String(),
+		}
+	}
+	renderTable(w, []string{"ID", "Asked", "Saved as", "Question", "Model", "Mode", "Latency"}, rows)
+	fmt.Fprintln(w, "\nRun a query again with 'basal ask --rerun <id or name>'.")
+	return nil
+}
+
+// rerunAsk runs the SQL saved with an ask history entry again, without the
+// LLM, and shows the current results.
+func rerunAsk(cmd *cobra.Command, store db.Store, ref, saveAs string) error {
+	entry, err := findAskEntry(store, ref)
+	if err != nil {
+		return err
+	}
+	if entry.SQL == "" {
+		return fmt.Errorf("entry %d was answered with tool calls and has no query to run again", entry.ID)
+	}
+
+	sandbox, err := db.OpenSandbox(store)
+	if err != nil {
+		return fmt.Errorf("error opening database for queries: %v", err)
+	}
+	defer sandbox.Close()
+	sandbox.MaxRows, _ = cmd.Flags().GetInt("max-rows")
+
+	result, err := sandbox.Query(cmd.Context(), entry.SQL)
+	if err != nil {
+		return fmt.Errorf("error running the saved query: %v", err)
+	}
+
+	w := cmd.OutOrStdout()
+	fmt.Fprintf(w, "\nQuestion: %s\n", entry.Question)
+	fmt.Fprintf(w, "Asked %s with %s\n", entry.CreatedAt.Local().Format("2006-01-02 15:04"), entry.Model)
+	fmt.Fprintf(w, "\nSQL query:\n%s\n\n", entry.SQL)
+	renderTable(w, result.Columns, result.Rows)
+	if result.Truncated {
+		fmt.Fprintf(w, "(showing the first %d rows)\n", sandbox.MaxRows)
+	}
+	if entry.Answer != "" {
+		fmt.Fprintf(w, "\nAnswer given then: %s\n", strings.TrimSpace(entry.Answer))
+	}
+
+	if saveAs != "" {
+		if err := store.NameAskEntry(entry.ID, saveAs); err != nil {
+			return fmt.Errorf("error saving report: %v", err)
+		}
+		fmt.Fprintf(w, "\nSaved as %q. Run it again with 'basal ask --rerun %s'.\n", saveAs, saveAs)
+	}
+	return nil
+}
+
+// deleteAsk removes an ask history entry, found by ID or saved name.
+func deleteAsk(w io.Writer, store db.Store, ref string) error {
+	entry, err := findAskEntry(store, ref)
+	if err != nil {
+		return err
+	}
+	if err := store.DeleteAskEntry(entry.ID); err != nil {
+		return fmt.Errorf("error deleting history entry: %v", err)
+	}
+	fmt.Fprintf(w, "Deleted history entry %d (%s).\n", entry.ID, entry.Question)
+	return nil
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"basal/db"
+)
+
+// historySetup writes a config using a database with one record and three ask
+// history entries: a query saved as "totals", a tool-call answer and another
+// query.
+func historySetup(t *testing.T) (configPath string, store func() db.Store) {
+	t.Helper()
+	configPath, dbPath := plaintextSetup(t)
+	open := func() db.Store {
+		s, err := db.OpenSQLiteStore(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+
+	s := open()
+	for _, entry := range []db.AskEntry{
+		{Question: "what are my daily totals", SQL: "SELECT total_units FROM basal_records", Model: "llama3", Answer: "22.8 units", Name: "totals"},
+		{Question: "what is my rate now", Model: "llama3", Answer: "0.8 units/hr"},
+		{Question: "when did I start", SQL: "SELECT MIN(date) AS first FROM basal_records", Model: "llama3"},
+	} {
+		if _, err := s.AddAskEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return configPath, open
+}
+
+func TestAskRerunByIDAndName(t *testing.T) {
+	configPath, _ := historySetup(t)
+
+	for _, ref := range []string{"1", "totals"} {
+		out, err := runBasal(t, configPath, "ask", "--rerun", ref)
+		if err != nil {
+			t.Fatalf("--rerun %s: %v", ref, err)
+		}
+		for _, want := range []string{"Question: what are my daily totals", "SELECT total_units FROM basal_records", "22.8", "Answer given then: 22.8 units"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("--rerun %s does not show %q:\n%s", ref, want, out)
+			}
+		}
+	}
+
+	failures := []struct {
+		ref, want string
+	}{
+		{"2", "tool calls"},
+		{"99", "no such ask history entry"},
+		{"missing", "no such ask history entry"},
+	}
+	for _, f := range failures {
+		if _, err := runBasal(t, configPath, "ask", "--rerun", f.ref); err == nil || !strings.Contains(err.Error(), f.want) {
+			t.Errorf("--rerun %s: %v, want an error about %q", f.ref, err, f.want)
+		}
+	}
+
+	if _, err := runBasal(t, configPath, "ask", "--rerun", "1", "what else"); err == nil {
+		t.Error("--rerun accepted a question")
+	}
+}
+
+func TestAskSaveMovesDuplicateName(t *testing.T) {
+	configPath, store := historySetup(t)
+
+	if _, err := runBasal(t, configPath, "ask", "--rerun", "3", "--save", "totals"); err != nil {
+		t.Fatalf("--rerun 3 --save totals: %v", err)
+	}
+	if _, err := runBasal(t, configPath, "ask", "--rerun", "3", "--save", "12"); err == nil {
+		t.Error("--save accepted a number as a name")
+	}
+
+	s := store()
+	if entry, err := s.GetAskEntryByName("totals"); err != nil || entry.ID != 3 {
+		t.Errorf("totals = %+v, %v; want entry 3", entry, err)
+	}
+	if entry, err := s.GetAskEntry(1); err != nil || entry.Name != "" {
+		t.Errorf("entry 1 = %+v, %v; want it without a name", entry, err)
+	}
+
+	out, err := runBasal(t, configPath, "ask", "--history")
+	if err != nil {
+		t.Fatalf("--history: %v", err)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "1":
+			if strings.Count(line, "totals") != 1 {
+				t.Errorf("entry 1 is still listed as totals: %s", line)
+			}
+		case "3":
+			if !strings.Contains(line, "totals") {
+				t.Errorf("entry 3 is not listed as totals: %s", line)
+			}
+		}
+	}
+}
+
+func TestAskDelete(t *testing.T) {
+	configPath, store := historySetup(t)
+
+	for _, ref := range []string{"totals", "2"} {
+		out, err := runBasal(t, configPath, "ask", "--delete", ref)
+		if err != nil {
+			t.Fatalf("--delete %s: %v", ref, err)
+		}
+		if !strings.Contains(out, "Deleted history entry") {
+			t.Errorf("--delete %s printed %q", ref, out)
+		}
+	}
+	if _, err := runBasal(t, configPath, "ask", "--delete", "totals"); err == nil {
+		t.Error("deleted the same entry twice")
+	}
+
+	entries, err := store().ListAskEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != 3 {
+		t.Errorf("entries left = %+v, want only entry 3", entries)
+	}
+	if _, err := store().GetAskEntryByName("totals"); !errors.Is(err, db.ErrNoAskEntry) {
+		t.Errorf("the deleted name still resolves: %v", err)
+	}
+}
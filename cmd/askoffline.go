@@ -0,0 +1,393 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"basal/db"
+	"basal/schedule"
+)
+
+// Kinds of question the offline parser understands.
+const (
+	intentSchedule = "schedule" // the schedule in effect on a date
+	intentRateAt   = "rate_at"  // the rate at a time on a date
+	intentTotal    = "total"    // the daily total on a date
+	intentHighest  = "highest"  // the schedule with the highest daily total
+	intentLowest   = "lowest"   // the schedule with the lowest daily total
+	intentLastSet  = "last_change"
+	intentCount    = "count"
+)
+
+// offlineExamples are shown when --offline can't understand a question.
+var offlineExamples = []string{
+	"what was my basal on 2024-01-05",
+	"what was my rate at 3am last Tuesday",
+	"total basal on Dec 2, 2023",
+	"highest total day",
+	"when did I last change my basal",
+	"how many basal changes",
+}
+
+// intent is a question understood without the LLM.
+type intent struct {
+	Kind string
+	Date time.Time
+	Time schedule.TimeOfDay
+}
+
+// monthPattern matches month names and their abbreviations.
+const monthPattern = `(jan(?:uary)?|feb(?:ruary)?|mar(?:ch)?|apr(?:il)?|may|june?|july?|aug(?:ust)?|sep(?:t(?:ember)?)?|oct(?:ober)?|nov(?:ember)?|dec(?:ember)?)`
+
+var (
+	isoDatePattern = regexp.MustCompile(`\b(\d{4})-(\d{1,2})-(\d{1,2})\b`)
+	// "dec 2, 2023", "december 2nd 2023", "dec 2"
+	monthDayPattern = regexp.MustCompile(`\b` + monthPattern + `\.?\s+(\d{1,2})(?:st|nd|rd|th)?\b(?:,?\s+(\d{4}))?`)
+	// "2 dec 2023", "2nd of december", "2 december"
+	dayMonthPattern = regexp.MustCompile(`\b(\d{1,2})(?:st|nd|rd|th)?\s+(?:of\s+)?` + monthPattern + `\b(?:,?\s+(\d{4}))?`)
+	agoPattern      = regexp.MustCompile(`\b(a|an|one|\d+)\s+(day|week|month|year)s?\s+ago\b`)
+	weekdayPattern  = regexp.MustCompile(`\b(last\s+)?(monday|tuesday|wednesday|thursday|friday|saturday|sunday)\b`)
+	// "3am", "3:30 pm", "at 14:00", "at 3", "at noon"
+	clockPattern = regexp.MustCompile(`\b(?:at\s+)?(\d{1,2})(?::(\d{2}))?\s*([ap])\.?m\b\.?|\bat\s+(\d{1,2})(?::(\d{2}))?\b|\b(?:at\s+)?(noon|midnight)\b`)
+	// Text that looks like part of a date: a year, a month or weekday name,
+	// "5th", "3/4", a named day or "on my ..."
+	dateLikePattern = regexp.MustCompile(`\b(?:19|20)\d{2}\b|\b` + monthPattern + `\b|\b(?:mon|tues?|wed(?:nes)?|thu(?:rs)?|fri|sat(?:ur)?|sun)(?:day)?\b|\b\d{1,2}(?:st|nd|rd|th)\b|\b\d{1,2}/\d{1,2}\b|\b(?:yesterday|today|tomorrow|tonight|birthday|christmas|holiday|weekend)\b|\bon\s+(?:my|the|a|an|our|that|this)\b`)
+)
+
+var monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+// rangeWords mark questions about spans of time or comparisons, which the
+// single-date intents would answer wrongly.
+var rangeWords = []string{"between", "from", "since", "until", "average", "mean", "compare", "differ", "change", "over", "each", "every", "trend", "week", "month", "year"}
+
+// parseIntent recognises common questions about basal rates, with dates
+// relative to now. It reports false for anything it is unsure of, so the
+// question can go to the LLM instead.
+func parseIntent(question string, now time.Time) (intent, bool) {
+	q := strings.ToLower(question)
+	q = strings.NewReplacer("?", " ", "!", " ", "’", "'").Replace(q)
+	q = strings.Join(strings.Fields(q), " ")
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	// These look at every record, so any limit on the dates must go to the LLM
+	kind := ""
+	switch {
+	case containsAny(q, "how many") && containsAny(q, "change", "record", "schedule"):
+		kind = intentCount
+	case containsAny(q, "last", "latest", "most recent") && containsAny(q, "change", "changed", "update"):
+		kind = intentLastSet
+	case containsAny(q, "total", "daily") && containsAny(q, "highest", "most", "greatest", "largest", "biggest", "max") && !strings.Contains(q, "most recent"):
+		kind = intentHighest
+	case containsAny(q, "total", "daily") && containsAny(q, "lowest", "least", "smallest", "minimum"):
+		kind = intentLowest
+	}
+	if kind != "" {
+		if limitsDates(q, today) {
+			return intent{}, false
+		}
+		return intent{Kind: kind}, true
+	}
+
+	if !containsAny(q, "basal", "rate", "schedule", "total", "insulin") {
+		return intent{}, false
+	}
+	// The words that give a date away may also be range words, e.g. "a week ago"
+	rest := agoPattern.ReplaceAllString(q, "")
+	if containsAny(rest, rangeWords...) {
+		return intent{}, false
+	}
+
+	date, dateText, hasDate := parseDatePhrase(q, today)
+	clock, clockText, hasTime := parseClock(q)
+	// Whatever else looks like a date, e.g. "march 2024" or "on my
+	// birthday", would be ignored and today used in its place
+	rest = strings.Replace(q, dateText, " ", 1)
+	rest = strings.Replace(rest, clockText, " ", 1)
+	if dateLikePattern.MatchString(rest) {
+		return intent{}, false
+	}
+	if hasWord(q, "now") {
+		if !hasTime {
+			clock, hasTime = schedule.TimeOfDay(now.Hour()*3600+now.Minute()*60), true
+		}
+		if !hasDate {
+			date, hasDate = today, true
+		}
+	}
+	if hasWord(q, "current") && !hasDate {
+		date, hasDate = today, true
+	}
+	if !hasDate {
+		if !hasTime {
+			return intent{}, false
+		}
+		date = today
+	}
+
+	switch {
+	case hasTime:
+		return intent{Kind: intentRateAt, Date: date, Time: clock}, true
+	case strings.Contains(q, "total"):
+		return intent{Kind: intentTotal, Date: date}, true
+	default:
+		return intent{Kind: intentSchedule, Date: date}, true
+	}
+}
+
+// limitsDates reports whether q limits a question about all records to some
+// dates, e.g. "in 2023", "since 2024-01-01" or "between january and march".
+func limitsDates(q string, today time.Time) bool {
+	for _, w := range rangeWords {
+		// Questions about every record are often about changes
+		if w != "change" && strings.Contains(q, w) {
+			return true
+		}
+	}
+	if containsAny(q, "before", "after", "during") || dateLikePattern.MatchString(q) {
+		return true
+	}
+	_, _, hasDate := parseDatePhrase(q, today)
+	return hasDate
+}
+
+// hasWord reports whether word appears in s as a whole word.
+func hasWord(s, word string) bool {
+	return slices.Contains(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), word)
+}
+
+// containsAny reports whether s contains any of words, even inside a longer word.
+func containsAny(s string, words ...string) bool {
+	for _, w := range words {
+		if strings.Contains(s, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDatePhrase finds a date in q, which must be lower case: an ISO date,
+// "dec 2, 2023", "2 december", "today", "yesterday", "3 days ago",
+// "tuesday" or "last tuesday", and returns it with the text it was read from.
+// Dates without a year are taken to be the most recent one.
+func parseDatePhrase(q string, today time.Time) (time.Time, string, bool) {
+	if m := isoDatePattern.FindStringSubmatch(q); m != nil {
+		date, ok := makeDate(atoi(m[1]), atoi(m[2]), atoi(m[3]))
+		return date, m[0], ok
+	}
+	if m := monthDayPattern.FindStringSubmatch(q); m != nil {
+		date, ok := monthDate(m[1], m[2], m[3], today)
+		return date, m[0], ok
+	}
+	if m := dayMonthPattern.FindStringSubmatch(q); m != nil {
+		date, ok := monthDate(m[2], m[1], m[3], today)
+		return date, m[0], ok
+	}
+	if m := agoPattern.FindStringSubmatch(q); m != nil {
+		n := 1
+		if m[1] != "a" && m[1] != "an" && m[1] != "one" {
+			n = atoi(m[1])
+		}
+		switch m[2] {
+		case "day":
+			return today.AddDate(0, 0, -n), m[0], true
+		case "week":
+			return today.AddDate(0, 0, -7*n), m[0], true
+		case "month":
+			return today.AddDate(0, -n, 0), m[0], true
+		default:
+			return today.AddDate(-n, 0, 0), m[0], true
+		}
+	}
+	if m := weekdayPattern.FindStringSubmatch(q); m != nil {
+		var weekday time.Weekday
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.ToLower(d.String()) == m[2] {
+				weekday = d
+			}
+		}
+		back := (int(today.Weekday()) - int(weekday) + 7) % 7
+		// "last tuesday" said on a Tuesday means a week ago
+		if back == 0 && m[1] != "" {
+			back = 7
+		}
+		return today.AddDate(0, 0, -back), m[0], true
+	}
+	switch {
+	case strings.Contains(q, "yesterday"):
+		return today.AddDate(0, 0, -1), "yesterday", true
+	case strings.Contains(q, "today"):
+		return today, "today", true
+	}
+	return time.Time{}, "", false
+}
+
+// monthDate builds a date from a month name, a day and an optional year.
+func monthDate(month, day, year string, today time.Time) (time.Time, bool) {
+	m := slices.Index(monthNames, month[:3]) + 1
+	if year != "" {
+		return makeDate(atoi(year), m, atoi(day))
+	}
+	date, ok := makeDate(today.Year(), m, atoi(day))
+	if ok && date.After(today) {
+		date, ok = makeDate(today.Year()-1, m, atoi(day))
+	}
+	return date, ok
+}
+
+// makeDate returns the date, rejecting days that don't exist rather than
+// letting time.Date roll them over.
+func makeDate(year, month, day int) (time.Time, bool) {
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if date.Year() != year || int(date.Month()) != month || date.Day() != day {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// parseClock finds a time of day in q: "3am", "3:30 pm", "at 14:00",
+// "at noon" or "at midnight", and returns it with the text it was read from.
+func parseClock(q string) (schedule.TimeOfDay, string, bool) {
+	m := clockPattern.FindStringSubmatch(q)
+	if m == nil {
+		return 0, "", false
+	}
+
+	var hour, minute int
+	switch {
+	case m[1] != "":
+		hour, minute = atoi(m[1]), atoi(m[2])
+		if hour < 1 || hour > 12 {
+			return 0, "", false
+		}
+		hour %= 12
+		if m[3] == "p" {
+			hour += 12
+		}
+	case m[4] != "":
+		hour, minute = atoi(m[4]), atoi(m[5])
+	case m[6] == "noon":
+		hour = 12
+	}
+
+	t, err := schedule.NewTimeOfDay(hour, minute, 0)
+	if err != nil {
+		return 0, "", false
+	}
+	return t, m[0], true
+}
+
+// answer looks up the answer to i in store and writes it to w.
+func (i intent) answer(w io.Writer, store db.Store) error {
+	day := i.Date.Format(db.DateFormat)
+
+	switch i.Kind {
+	case intentSchedule, intentTotal, intentRateAt:
+		record, sched, err := effectiveSchedule(store, i.Date)
+		if err != nil {
+			return fmt.Errorf("error retrieving basal record: %v", err)
+		}
+		if record == nil {
+			fmt.Fprintf(w, "Answer: No basal schedule was recorded on or before %s.\n", day)
+			return nil
+		}
+		since := record.Date.Format(db.DateFormat)
+
+		switch i.Kind {
+		case intentRateAt:
+			rate, ok := sched.RateAt(i.Time)
+			if !ok {
+				return fmt.Errorf("no segment covers %s", i.Time)
+			}
+			fmt.Fprintf(w, "Answer: At %s on %s your basal rate was %.2f units/hr (schedule set on %s).\n", i.Time, day, rate, since)
+		case intentTotal:
+			fmt.Fprintf(w, "Answer: Your total daily basal on %s was %.2f units (schedule set on %s).\n", day, sched.TotalUnits(), since)
+		default:
+			rows := make([][]string, len(sched))
+			for j, seg := range sched {
+				rows[j] = []string{fmt.Sprintf("%s - %s", seg.StartTime, seg.EndTime), fmt.Sprintf("%.2f", seg.UnitsPerHour)}
+			}
+			renderTable(w, []string{"Time Interval", "Units/hr"}, rows)
+			fmt.Fprintf(w, "\nAnswer: On %s your basal schedule was the one set on %s, totalling %.2f units a day.\n", day, since, sched.TotalUnits())
+		}
+		return nil
+	}
+
+	records, err := store.ListBasalRecords()
+	if err != nil {
+		return fmt.Errorf("error retrieving basal records: %v", err)
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(w, "Answer: No basal schedules have been recorded yet.")
+		return nil
+	}
+	records = effectiveRecords(records)
+
+	switch i.Kind {
+	case intentCount:
+		changes := fmt.Sprintf("%d times", len(records)-1)
+		if len(records) == 2 {
+			changes = "once"
+		}
+		fmt.Fprintf(w, "Answer: Your first basal schedule was set on %s, and it has changed %s since (%d schedules on record).\n",
+			records[0].Date.Format(db.DateFormat), changes, len(records))
+	case intentLastSet:
+		last := records[len(records)-1]
+		fmt.Fprintf(w, "Answer: Your basal schedule last changed on %s, to %.2f units a day.\n", last.Date.Format(db.DateFormat), last.TotalUnits)
+	case intentHighest, intentLowest:
+		best := 0
+		for j, r := range records {
+			if (i.Kind == intentHighest && r.TotalUnits > records[best].TotalUnits) ||
+				(i.Kind == intentLowest && r.TotalUnits < records[best].TotalUnits) {
+				best = j
+			}
+		}
+		period := "since " + records[best].Date.Format(db.DateFormat)
+		if best+1 < len(records) {
+			until := records[best+1].Date.AddDate(0, 0, -1)
+			period = fmt.Sprintf("from %s to %s", records[best].Date.Format(db.DateFormat), until.Format(db.DateFormat))
+		}
+		word := "highest"
+		if i.Kind == intentLowest {
+			word = "lowest"
+		}
+		fmt.Fprintf(w, "Answer: Your %s daily basal was %.2f units, %s.\n", word, records[best].TotalUnits, period)
+	}
+	return nil
+}
+
+// effectiveRecords returns one record per date, oldest first. Of the records
+// sharing a date the last one added wins, as in GetBasalRecordByDate, so the
+// others never took effect and are not changes.
+func effectiveRecords(records []db.BasalRecord) []db.BasalRecord {
+	sorted := slices.Clone(records)
+	slices.SortFunc(sorted, func(a, b db.BasalRecord) int {
+		if c := a.Date.Compare(b.Date); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	var out []db.BasalRecord
+	for _, r := range sorted {
+		if n := len(out); n > 0 && out[n-1].Date.Equal(r.Date) {
+			out[n-1] = r
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
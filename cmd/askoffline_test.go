@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"basal/db"
+	"basal/schedule"
+)
+
+func TestParseIntent(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2024, 6, 12, 15, 30, 0, 0, time.UTC)
+	date := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		question string
+		want     intent
+	}{
+		{"what was my basal on 2024-01-05", intent{Kind: intentSchedule, Date: date("2024-01-05")}},
+		{"what was my rate at 3am last Tuesday", intent{Kind: intentRateAt, Date: date("2024-06-11"), Time: 3 * 3600}},
+		{"total basal on Dec 2, 2023", intent{Kind: intentTotal, Date: date("2023-12-02")}},
+		{"what was my basal 3 days ago", intent{Kind: intentSchedule, Date: date("2024-06-09")}},
+		{"what is my rate at 14:00", intent{Kind: intentRateAt, Date: date("2024-06-12"), Time: 14 * 3600}},
+		{"what's my basal rate now", intent{Kind: intentRateAt, Date: date("2024-06-12"), Time: 15*3600 + 30*60}},
+		{"highest total day", intent{Kind: intentHighest}},
+		{"what was my lowest daily total", intent{Kind: intentLowest}},
+		{"when did I last change my basal", intent{Kind: intentLastSet}},
+		{"how many basal changes", intent{Kind: intentCount}},
+	}
+	for _, tt := range tests {
+		got, ok := parseIntent(tt.question, now)
+		if !ok {
+			t.Errorf("%q: not understood, want %+v", tt.question, tt.want)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.question, got, tt.want)
+		}
+	}
+}
+
+func TestParseIntentLeavesRangesToLLM(t *testing.T) {
+	now := time.Date(2024, 6, 12, 15, 30, 0, 0, time.UTC)
+	questions := []string{
+		// Limits on the all-time questions
+		"how many times did my basal change in 2023",
+		"how many schedule changes between January and March",
+		"highest total day in 2023",
+		"what was my lowest daily total since 2024-01-01",
+		"when did I last change my basal before 2024-01-01",
+		"what was my highest daily total last year",
+		// Dates the parser can't read
+		"what was my basal at 8 in march 2024",
+		"what rate did I have at 3pm on my birthday",
+		"what was my basal on the 5th",
+		"what was my total on 2024-01-05 and 2024-02-05",
+		// Spans and comparisons
+		"average basal rate over the last month",
+		"how did my total daily basal change from 2023-01-01 to 2024-01-01",
+		"what was my basal rate",
+	}
+	for _, q := range questions {
+		if got, ok := parseIntent(q, now); ok {
+			t.Errorf("%q: parsed as %+v, want it left to the LLM", q, got)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		text string
+		want schedule.TimeOfDay
+		ok   bool
+	}{
+		{"at 3am", 3 * 3600, true},
+		{"3:30 pm", 15*3600 + 30*60, true},
+		{"at 12am", 0, true},
+		{"at noon", 12 * 3600, true},
+		{"at midnight", 0, true},
+		{"at 25:00", 0, false},
+		{"13pm", 0, false},
+		{"no time here", 0, false},
+	}
+	for _, tt := range tests {
+		got, _, ok := parseClock(tt.text)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseClock(%q) = %v, %v; want %v, %v", tt.text, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIntentAnswer(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse(db.DateFormat, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	// Daily totals: 22.8 on Jan 1; 25.2 from Mar 1, where it replaced 27.6
+	// added earlier the same day; 20.4 from Jun 1
+	store := db.NewMemoryStore()
+	addTestRecord(t, store, "2024-01-01", 0.8)
+	addTestRecord(t, store, "2024-03-01", 1.0)
+	addTestRecord(t, store, "2024-06-01", 0.7)
+	addTestRecord(t, store, "2024-03-01", 0.9)
+
+	twoDays := db.NewMemoryStore()
+	addTestRecord(t, twoDays, "2024-01-01", 0.8)
+	addTestRecord(t, twoDays, "2024-02-01", 1.0)
+	addTestRecord(t, twoDays, "2024-02-01", 0.9)
+
+	tests := []struct {
+		name   string
+		store  db.Store
+		intent intent
+		want   string
+	}{
+		{"count ignores same-day replacements", store, intent{Kind: intentCount},
+			"Answer: Your first basal schedule was set on 2024-01-01, and it has changed 2 times since (3 schedules on record).\n"},
+		{"count of one change", twoDays, intent{Kind: intentCount},
+			"Answer: Your first basal schedule was set on 2024-01-01, and it has changed once since (2 schedules on record).\n"},
+		{"highest skips a replaced record", store, intent{Kind: intentHighest},
+			"Answer: Your highest daily basal was 25.20 units, from 2024-03-01 to 2024-05-31.\n"},
+		{"lowest runs to today", store, intent{Kind: intentLowest},
+			"Answer: Your lowest daily basal was 20.40 units, since 2024-06-01.\n"},
+		{"lowest of the first schedule", twoDays, intent{Kind: intentLowest},
+			"Answer: Your lowest daily basal was 22.80 units, from 2024-01-01 to 2024-01-31.\n"},
+		{"last change is the last record of its day", twoDays, intent{Kind: intentLastSet},
+			"Answer: Your basal schedule last changed on 2024-02-01, to 25.20 units a day.\n"},
+		{"rate at a time", store, intent{Kind: intentRateAt, Date: date("2024-03-15"), Time: 3 * 3600},
+			"Answer: At 03:00 on 2024-03-15 your basal rate was 0.90 units/hr (schedule set on 2024-03-01).\n"},
+		{"total on a date", store, intent{Kind: intentTotal, Date: date("2024-07-04")},
+			"Answer: Your total daily basal on 2024-07-04 was 20.40 units (schedule set on 2024-06-01).\n"},
+		{"total before the first record", store, intent{Kind: intentTotal, Date: date("2023-12-25")},
+			"Answer: Your total daily basal on 2023-12-25 was 22.80 units (schedule set on 2024-01-01).\n"},
+		{"schedule on a date", twoDays, intent{Kind: intentSchedule, Date: date("2024-02-01")},
+			"  TIME INTERVAL    UNITS/HR  \n" +
+				"----------------+-----------\n" +
+				"  00:00 - 06:00        0.90  \n" +
+				"  06:00 - 00:00        1.10  \n" +
+				"\nAnswer: On 2024-02-01 your basal schedule was the one set on 2024-02-01, totalling 25.20 units a day.\n"},
+		{"no records", db.NewMemoryStore(), intent{Kind: intentTotal, Date: date("2024-01-01")},
+			"Answer: No basal schedule was recorded on or before 2024-01-01.\n"},
+		{"no records to count", db.NewMemoryStore(), intent{Kind: intentCount},
+			"Answer: No basal schedules have been recorded yet.\n"},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		if err := tt.intent.answer(&out, tt.store); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
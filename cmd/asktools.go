@@ -0,0 +1,355 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"basal/db"
+	"basal/llm"
+	"basal/schedule"
+)
+
+// maxToolRounds limits how many rounds of tool calls one question may take.
+const maxToolRounds = 8
+
+// maxToolDays limits the date range daily_totals accepts.
+const maxToolDays = 366
+
+// basalTools are the tools offered to the model in tool-calling mode. They
+// are answered from the store, so no model-written SQL touches the database.
+var basalTools = []llm.Tool{
+	{
+		Name:        "get_schedule",
+		Description: "Get the basal schedule in effect on a date: each time segment with its rate in units per hour, and the total units for the day.",
+		Parameters:  toolParams([]string{"date"}, map[string]string{"date": "Date as YYYY-MM-DD"}),
+	},
+	{
+		Name:        "rate_at",
+		Description: "Get the basal rate in units per hour at a time of day on a date.",
+		Parameters: toolParams([]string{"date", "time"}, map[string]string{
+			"date": "Date as YYYY-MM-DD",
+			"time": "Time of day as HH:MM (24-hour)",
+		}),
+	},
+	{
+		Name:        "list_changes",
+		Description: "List the dates between two dates (inclusive) on which the basal schedule changed, with the new daily total and which time ranges changed from what rate to what rate.",
+		Parameters: toolParams([]string{"from", "to"}, map[string]string{
+			"from": "First date as YYYY-MM-DD",
+			"to":   "Last date as YYYY-MM-DD",
+		}),
+	},
+	{
+		Name:        "daily_totals",
+		Description: "Get the total basal units for every day between two dates (inclusive), at most one year.",
+		Parameters: toolParams([]string{"from", "to"}, map[string]string{
+			"from": "First date as YYYY-MM-DD",
+			"to":   "Last date as YYYY-MM-DD",
+		}),
+	},
+}
+
+// toolParams returns the JSON schema of an object of required string arguments.
+func toolParams(required []string, descriptions map[string]string) map[string]any {
+	properties := make(map[string]any, len(descriptions))
+	for name, desc := range descriptions {
+		properties[name] = map[string]string{"type": "string", "description": desc}
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// toolSystemPrompt explains tool-calling mode to the model.
+func toolSystemPrompt(today time.Time) string {
+	return fmt.Sprintf(`You answer questions about a person's insulin basal rates.
+Use the tools to look up the data; never guess numbers. Today is %s.
+Dates are YYYY-MM-DD and times are HH:MM; a segment ending at 00:00 runs to midnight. Rates are in units per hour and daily totals in units.
+When you have what you need, answer briefly in plain language.`, today.Format(db.DateFormat))
+}
+
+// askWithTools answers question by letting the model call basalTools, which
+// basal runs against store. Results longer than maxItems are refused. Each
+// call is reported to verbose if it is not nil.
+func askWithTools(ctx context.Context, caller llm.ToolCaller, store db.Store, question string, maxItems int, verbose io.Writer) (string, error) {
+	messages := []llm.Message{
+		{Role: "system", Content: toolSystemPrompt(time.Now())},
+		{Role: "user", Content: question},
+	}
+
+	for round := 0; round < maxToolRounds; round++ {
+		reply, err := caller.ChatWithTools(ctx, messages, basalTools)
+		if err != nil {
+			return "", err
+		}
+		if len(reply.ToolCalls) == 0 {
+			return reply.Content, nil
+		}
+
+		messages = append(messages, reply)
+		for _, call := range reply.ToolCalls {
+			result, err := runTool(store, call.Function.Name, call.Function.Arguments, maxItems)
+			if err != nil {
+				result = fmt.Sprintf(`{"error": %q}`, err.Error())
+			}
+			if verbose != nil {
+				fmt.Fprintf(verbose, "%s(%s) -> %s\n", call.Function.Name, call.Function.Arguments, result)
+			}
+			messages = append(messages, llm.Message{
+				Role:       "tool",
+				Content:    result,
+				ToolName:   call.Function.Name,
+				ToolCallID: call.ID,
+			})
+		}
+	}
+
+	return "", fmt.Errorf("could not answer the question: the model was still calling tools after %d rounds", maxToolRounds)
+}
+
+// toolArgs are the arguments any of basalTools may take.
+type toolArgs struct {
+	Date string `json:"date"`
+	Time string `json:"time"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// runTool runs the tool called name and returns its result as JSON. A list
+// of more than maxItems entries is refused, so that the model asks for less.
+func runTool(store db.Store, name string, rawArgs json.RawMessage, maxItems int) (string, error) {
+	var args toolArgs
+	if len(rawArgs) > 0 {
+		if err := json.Unmarshal(rawArgs, &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %v", err)
+		}
+	}
+
+	var result any
+	var err error
+	switch name {
+	case "get_schedule":
+		result, err = toolGetSchedule(store, args)
+	case "rate_at":
+		result, err = toolRateAt(store, args)
+	case "list_changes":
+		result, err = toolListChanges(store, args)
+	case "daily_totals":
+		result, err = toolDailyTotals(store, args)
+	default:
+		return "", fmt.Errorf("unknown tool %q", name)
+	}
+	if err != nil {
+		return "", err
+	}
+	if n := toolResultLen(result); maxItems > 0 && n > maxItems {
+		return "", fmt.Errorf("the result has %d entries, more than the %d that may be sent (privacy.max_rows); ask for a shorter range", n, maxItems)
+	}
+
+	content, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// toolResultLen returns the number of entries in a list result, or 1.
+func toolResultLen(result any) int {
+	switch r := result.(type) {
+	case []toolChange:
+		return len(r)
+	case []toolDailyTotal:
+		return len(r)
+	}
+	return 1
+}
+
+func parseToolDate(name, value string) (time.Time, error) {
+	date, err := time.Parse(db.DateFormat, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a date as YYYY-MM-DD, got %q", name, value)
+	}
+	return date, nil
+}
+
+func parseToolRange(args toolArgs) (from, to time.Time, err error) {
+	if from, err = parseToolDate("from", args.From); err != nil {
+		return
+	}
+	if to, err = parseToolDate("to", args.To); err != nil {
+		return
+	}
+	if to.Before(from) {
+		err = fmt.Errorf("to (%s) is before from (%s)", args.To, args.From)
+	}
+	return
+}
+
+// effectiveSchedule returns the record in effect on date, as
+// GetBasalRecordByDate picks it, or nil if there are no records.
+func effectiveSchedule(store db.Store, date time.Time) (*db.BasalRecord, schedule.Schedule, error) {
+	record, sched, err := store.GetBasalRecordByDate(date)
+	if errors.Is(err, db.ErrNoRecords) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return record, sched, nil
+}
+
+type toolSegment struct {
+	Start        string  `json:"start"`
+	End          string  `json:"end"`
+	UnitsPerHour float64 `json:"units_per_hour"`
+}
+
+func toolSegments(sched schedule.Schedule) []toolSegment {
+	segments := make([]toolSegment, len(sched))
+	for i, seg := range sched {
+		segments[i] = toolSegment{Start: seg.StartTime.String(), End: seg.EndTime.String(), UnitsPerHour: seg.UnitsPerHour}
+	}
+	return segments
+}
+
+func roundUnits(units float64) float64 {
+	return math.Round(units*100) / 100
+}
+
+func toolGetSchedule(store db.Store, args toolArgs) (any, error) {
+	date, err := parseToolDate("date", args.Date)
+	if err != nil {
+		return nil, err
+	}
+	record, sched, err := effectiveSchedule(store, date)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return map[string]string{"result": "no basal schedule was recorded on or before " + args.Date}, nil
+	}
+	return map[string]any{
+		"date":        args.Date,
+		"in_effect":   record.Date.Format(db.DateFormat),
+		"segments":    toolSegments(sched),
+		"total_units": roundUnits(sched.TotalUnits()),
+	}, nil
+}
+
+func toolRateAt(store db.Store, args toolArgs) (any, error) {
+	date, err := parseToolDate("date", args.Date)
+	if err != nil {
+		return nil, err
+	}
+	t, err := schedule.ParseTimeOfDay(args.Time)
+	if err != nil {
+		return nil, fmt.Errorf("time must be HH:MM: %v", err)
+	}
+	record, sched, err := effectiveSchedule(store, date)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return map[string]string{"result": "no basal schedule was recorded on or before " + args.Date}, nil
+	}
+	rate, ok := sched.RateAt(t)
+	if !ok {
+		return nil, fmt.Errorf("no segment covers %s", t)
+	}
+	return map[string]any{
+		"date":           args.Date,
+		"time":           t.String(),
+		"in_effect":      record.Date.Format(db.DateFormat),
+		"units_per_hour": rate,
+	}, nil
+}
+
+type toolChange struct {
+	Date       string          `json:"date"`
+	TotalUnits float64         `json:"total_units"`
+	Changes    []toolRateDelta `json:"changes,omitempty"`
+}
+
+type toolRateDelta struct {
+	Start string  `json:"start"`
+	End   string  `json:"end"`
+	From  float64 `json:"from_units_per_hour"`
+	To    float64 `json:"to_units_per_hour"`
+}
+
+func toolListChanges(store db.Store, args toolArgs) (any, error) {
+	from, to, err := parseToolRange(args)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := store.ListBasalRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []toolChange{}
+	var previous schedule.Schedule
+	// Oldest first, so each record can be compared with the one in effect
+	// before it
+	for _, r := range effectiveRecords(records) {
+		_, sched, err := store.GetBasalRecord(r.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !r.Date.Before(from) && !r.Date.After(to) {
+			change := toolChange{Date: r.Date.Format(db.DateFormat), TotalUnits: roundUnits(sched.TotalUnits())}
+			// The first record has nothing to compare with
+			if previous != nil {
+				for _, c := range previous.Diff(sched) {
+					change.Changes = append(change.Changes, toolRateDelta{
+						Start: c.StartTime.String(),
+						End:   c.EndTime.String(),
+						From:  c.From,
+						To:    c.To,
+					})
+				}
+			}
+			changes = append(changes, change)
+		}
+		previous = sched
+	}
+	return changes, nil
+}
+
+type toolDailyTotal struct {
+	Date       string   `json:"date"`
+	TotalUnits *float64 `json:"total_units"` // null without records
+}
+
+func toolDailyTotals(store db.Store, args toolArgs) (any, error) {
+	from, to, err := parseToolRange(args)
+	if err != nil {
+		return nil, err
+	}
+	if int(to.Sub(from).Hours()/24) >= maxToolDays {
+		return nil, fmt.Errorf("the range is longer than %d days", maxToolDays)
+	}
+
+	var totals []toolDailyTotal
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		total := toolDailyTotal{Date: day.Format(db.DateFormat)}
+		record, sched, err := effectiveSchedule(store, day)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			units := roundUnits(sched.TotalUnits())
+			total.TotalUnits = &units
+		}
+		totals = append(totals, total)
+	}
+	return totals, nil
+}
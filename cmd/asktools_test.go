@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"basal/db"
+	"basal/schedule"
+)
+
+// toolTestStore holds 0.8/1.0 U/h from Jan 1 and 1.0/1.2 U/h from Mar 1,
+// which replaced 0.5/0.7 U/h added earlier that day, and the same schedule
+// again on Jun 1. The records are added out of date order.
+func toolTestStore(t *testing.T) db.Store {
+	t.Helper()
+	store := db.NewMemoryStore()
+	addTestRecord(t, store, "2024-03-01", 0.5)
+	addTestRecord(t, store, "2024-06-01", 1.0)
+	addTestRecord(t, store, "2024-01-01", 0.8)
+	addTestRecord(t, store, "2024-03-01", 1.0)
+	return store
+}
+
+func runToolJSON(t *testing.T, store db.Store, name, args string) string {
+	t.Helper()
+	result, err := runTool(store, name, []byte(args), 0)
+	if err != nil {
+		t.Fatalf("%s(%s): %v", name, args, err)
+	}
+	return result
+}
+
+func TestToolListChanges(t *testing.T) {
+	store := toolTestStore(t)
+	tests := []struct {
+		from, to string
+		want     string
+	}{
+		{"2024-01-01", "2024-12-31", `[` +
+			`{"date":"2024-01-01","total_units":22.8},` +
+			`{"date":"2024-03-01","total_units":27.6,"changes":[` +
+			`{"start":"00:00","end":"06:00","from_units_per_hour":0.8,"to_units_per_hour":1},` +
+			`{"start":"06:00","end":"00:00","from_units_per_hour":1,"to_units_per_hour":1.2}]},` +
+			`{"date":"2024-06-01","total_units":27.6}]`},
+		// Compared with the record in effect before the range
+		{"2024-02-01", "2024-05-31", `[` +
+			`{"date":"2024-03-01","total_units":27.6,"changes":[` +
+			`{"start":"00:00","end":"06:00","from_units_per_hour":0.8,"to_units_per_hour":1},` +
+			`{"start":"06:00","end":"00:00","from_units_per_hour":1,"to_units_per_hour":1.2}]}]`},
+		{"2024-03-01", "2024-03-01", `[` +
+			`{"date":"2024-03-01","total_units":27.6,"changes":[` +
+			`{"start":"00:00","end":"06:00","from_units_per_hour":0.8,"to_units_per_hour":1},` +
+			`{"start":"06:00","end":"00:00","from_units_per_hour":1,"to_units_per_hour":1.2}]}]`},
+		{"2023-01-01", "2023-12-31", `[]`},
+	}
+	for _, tt := range tests {
+		got := runToolJSON(t, store, "list_changes", fmt.Sprintf(`{"from": %q, "to": %q}`, tt.from, tt.to))
+		if got != tt.want {
+			t.Errorf("list_changes %s to %s:\n got %s\nwant %s", tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	if _, err := runTool(store, "list_changes", []byte(`{"from": "2024-06-01", "to": "2024-01-01"}`), 0); err == nil {
+		t.Error("list_changes accepted a backwards range")
+	}
+	if _, err := runTool(store, "list_changes", []byte(`{"from": "2024-01-01", "to": "2024-12-31"}`), 2); err == nil ||
+		!strings.Contains(err.Error(), "privacy.max_rows") {
+		t.Errorf("list_changes over the item limit: %v", err)
+	}
+}
+
+func TestToolScheduleOnDate(t *testing.T) {
+	store := toolTestStore(t)
+	tests := []struct {
+		name, args, want string
+	}{
+		{"get_schedule", `{"date": "2024-03-15"}`,
+			`{"date":"2024-03-15","in_effect":"2024-03-01","segments":[` +
+				`{"start":"00:00","end":"06:00","units_per_hour":1},` +
+				`{"start":"06:00","end":"00:00","units_per_hour":1.2}],"total_units":27.6}`},
+		// Before the first record the earliest one is used, like GetBasalRecordByDate
+		{"get_schedule", `{"date": "2023-12-01"}`,
+			`{"date":"2023-12-01","in_effect":"2024-01-01","segments":[` +
+				`{"start":"00:00","end":"06:00","units_per_hour":0.8},` +
+				`{"start":"06:00","end":"00:00","units_per_hour":1}],"total_units":22.8}`},
+		{"rate_at", `{"date": "2024-03-01", "time": "07:30"}`,
+			`{"date":"2024-03-01","in_effect":"2024-03-01","time":"07:30","units_per_hour":1.2}`},
+		{"rate_at", `{"date": "2024-02-29", "time": "0530"}`,
+			`{"date":"2024-02-29","in_effect":"2024-01-01","time":"05:30","units_per_hour":0.8}`},
+		{"daily_totals", `{"from": "2024-02-29", "to": "2024-03-01"}`,
+			`[{"date":"2024-02-29","total_units":22.8},{"date":"2024-03-01","total_units":27.6}]`},
+	}
+	for _, tt := range tests {
+		if got := runToolJSON(t, store, tt.name, tt.args); got != tt.want {
+			t.Errorf("%s(%s):\n got %s\nwant %s", tt.name, tt.args, got, tt.want)
+		}
+	}
+
+	for _, bad := range []struct{ name, args string }{
+		{"get_schedule", `{"date": "March 1"}`},
+		{"rate_at", `{"date": "2024-03-01", "time": "7pm"}`},
+		{"rate_at", `{"date": "2024-03-01", "time": "24:00"}`},
+		{"daily_totals", `{"from": "2023-01-01", "to": "2024-12-31"}`},
+		{"get_schedule", `{"date": 20240301}`},
+		{"delete_everything", `{}`},
+	} {
+		if result, err := runTool(store, bad.name, []byte(bad.args), 0); err == nil {
+			t.Errorf("%s(%s) = %s, want an error", bad.name, bad.args, result)
+		}
+	}
+}
+
+func TestToolsWithoutRecords(t *testing.T) {
+	store := db.NewMemoryStore()
+	tests := []struct {
+		name, args, want string
+	}{
+		{"get_schedule", `{"date": "2024-03-01"}`, `{"result":"no basal schedule was recorded on or before 2024-03-01"}`},
+		{"rate_at", `{"date": "2024-03-01", "time": "07:00"}`, `{"result":"no basal schedule was recorded on or before 2024-03-01"}`},
+		{"list_changes", `{"from": "2024-01-01", "to": "2024-12-31"}`, `[]`},
+		{"daily_totals", `{"from": "2024-03-01", "to": "2024-03-02"}`, `[{"date":"2024-03-01","total_units":null},{"date":"2024-03-02","total_units":null}]`},
+	}
+	for _, tt := range tests {
+		if got := runToolJSON(t, store, tt.name, tt.args); got != tt.want {
+			t.Errorf("%s(%s):\n got %s\nwant %s", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
+
+// lookupErrorStore fails every lookup by date with err.
+type lookupErrorStore struct {
+	db.Store
+	err error
+}
+
+func (s lookupErrorStore) GetBasalRecordByDate(time.Time) (*db.BasalRecord, schedule.Schedule, error) {
+	return nil, nil, s.err
+}
+
+func TestEffectiveScheduleErrors(t *testing.T) {
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// Only a missing record means there is nothing in effect
+	noRecords := lookupErrorStore{db.NewMemoryStore(), fmt.Errorf("looking up: %w", db.ErrNoRecords)}
+	record, sched, err := effectiveSchedule(noRecords, date)
+	if record != nil || sched != nil || err != nil {
+		t.Errorf("with ErrNoRecords: %v, %v, %v; want nothing", record, sched, err)
+	}
+
+	broken := lookupErrorStore{db.NewMemoryStore(), errors.New("disk I/O error")}
+	if _, _, err := effectiveSchedule(broken, date); err == nil || err.Error() != "disk I/O error" {
+		t.Errorf("with a failing store: %v, want the store's error", err)
+	}
+	for _, name := range []string{"get_schedule", "rate_at", "daily_totals"} {
+		args := `{"date": "2024-03-01", "time": "07:00", "from": "2024-03-01", "to": "2024-03-01"}`
+		if result, err := runTool(broken, name, []byte(args), 0); err == nil {
+			t.Errorf("%s with a failing store = %s, want an error", name, result)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"basal/db"
+
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cobra"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup [path]",
+	Short: "Back up the database",
+	Long: `Write a consistent, verified copy of the database to the given path.
+If no path is provided, the backup is written to the current directory
+with a timestamped name. Use --list to show the automatic snapshots taken
+before destructive commands.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runBackup,
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <path>",
+	Short: "Restore the database from a backup",
+	Long: `Replace the database with a backup after verifying its integrity.
+A snapshot of the current database is taken first, so a restore can be undone.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRestore,
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+
+	backupCmd.Flags().Bool("list", false, "List automatic snapshots instead of taking a backup")
+	restoreCmd.Flags().BoolP("yes", "y", false, "Restore without asking for confirmation")
+}
+
+func runBackup(cmd *cobra.Command, args []string) error {
+	backend, dbPath, err := getStorageLocation()
+	if err != nil {
+		return err
+	}
+
+	if list, _ := cmd.Flags().GetBool("list"); list {
+		snapshots, err := db.ListSnapshots(dbPath)
+		if err != nil {
+			return fmt.Errorf("error listing snapshots: %v", err)
+		}
+		if len(snapshots) == 0 {
+			fmt.Println("No snapshots found.")
+			return nil
+		}
+		fmt.Printf("Snapshots in %s:\n", db.SnapshotDir(dbPath))
+		for _, snapshot := range snapshots {
+			fmt.Println(snapshot)
+		}
+		return nil
+	}
+
+	var dst string
+	if len(args) > 0 {
+		dst, err = cleanPath(args[0])
+		if err != nil {
+			return err
+		}
+	} else {
+		dst = fmt.Sprintf("basal-backup-%s%s", time.Now().Format("20060102-150405"), filepath.Ext(dbPath))
+	}
+
+	if err := db.Backup(backend, dbPath, dst); err != nil {
+		return fmt.Errorf("error backing up database: %v", err)
+	}
+
+	fmt.Printf("Backup written to: %s\n", dst)
+	return nil
+}
+
+func runRestore(cmd *cobra.Command, args []string) error {
+	backend, dbPath, err := getStorageLocation()
+	if err != nil {
+		return err
+	}
+
+	src, err := cleanPath(args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := db.Verify(backend, src); err != nil {
+		return fmt.Errorf("error verifying backup: %v", err)
+	}
+
+	if yes, _ := cmd.Flags().GetBool("yes"); !yes {
+		if err := requireTerminal("pass --yes to restore without confirmation"); err != nil {
+			return err
+		}
+		confirmPrompt := promptui.Prompt{
+			Label:     fmt.Sprintf("Replace %s with %s", dbPath, src),
+			IsConfirm: true,
+		}
+		if _, err := confirmPrompt.Run(); err != nil {
+			fmt.Println("Restore cancelled.")
+			return nil
+		}
+	}
+
+	if err := db.Restore(backend, src, dbPath); err != nil {
+		return fmt.Errorf("error restoring database: %v", err)
+	}
+
+	fmt.Printf("Database restored from: %s\n", src)
+	fmt.Printf("The previous database was kept in: %s\n", db.SnapshotDir(dbPath))
+	return nil
+}
+
+// snapshotBeforeChange takes an automatic snapshot of the database ahead of a
+// destructive command.
+func snapshotBeforeChange() error {
+	backend, dbPath, err := getStorageLocation()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Snapshot(backend, dbPath); err != nil {
+		return fmt.Errorf("error taking snapshot: %v", err)
+	}
+	return nil
+}
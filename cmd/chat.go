@@ -0,0 +1,322 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"basal/db"
+	"basal/llm"
+
+	"github.com/spf13/cobra"
+)
+
+var chatCmd = &cobra.Command{
+	Use:   "chat",
+	Short: "Ask follow-up questions about your basal rates",
+	Long: `Start an interactive session for asking questions about your basal rates.
+Earlier questions, queries and results are kept as context, so follow-ups such
+as "and what about a year before that?" work. Older turns are dropped when the
+conversation no longer fits in --max-context tokens.
+
+Commands:
+  /sql            Show the SQL behind the last answer
+  /explain        Ask the model to explain how the last query answers the question
+  /save [file]    Save the conversation as Markdown
+  /reset          Forget the conversation so far
+  /help           Show these commands
+  /quit           Leave the chat (or press Ctrl-D)`,
+	Args: cobra.NoArgs,
+	RunE: runChat,
+}
+
+func init() {
+	rootCmd.AddCommand(chatCmd)
+	chatCmd.Flags().Int("max-rows", db.DefaultMaxRows, "Maximum number of result rows to show")
+	chatCmd.Flags().Int("retries", 2, "How many times to ask the model to fix a failing query")
+	chatCmd.Flags().Int("max-context", 4096, "Approximate model context window in tokens")
+	chatCmd.Flags().BoolP("verbose", "v", false, "Show every generated query and why it failed")
+}
+
+// chatContextRows is how many result rows of earlier turns are sent back to
+// the model as context.
+const chatContextRows = 20
+
+// chatTurn is one question and its answer.
+type chatTurn struct {
+	Question string
+	SQL      string
+	Result   *db.QueryResult
+	Answer   string
+}
+
+// chatSession holds the state of a chat.
+type chatSession struct {
+	provider   llm.Provider
+	sandbox    *db.Sandbox
+	retries    int
+	maxContext int // approximate tokens
+	verbose    io.Writer
+	out        io.Writer // where results and answers are shown
+	privacy    privacyPolicy
+	data       string // describeData of the sandbox, for the instructions
+	turns      []chatTurn
+}
+
+// estimateTokens roughly counts the tokens in messages, at four characters
+// per token plus a little overhead per message.
+func estimateTokens(messages []llm.Message) int {
+	tokens := 0
+	for _, m := range messages {
+		tokens += len(m.Content)/4 + 4
+	}
+	return tokens
+}
+
+// messages builds the conversation sent for question: the instructions,
+// as many recent turns as fit in the context window, and the question.
+func (s *chatSession) messages(question string) ([]llm.Message, error) {
+	instructions, err := sqlPrompt("", s.data)
+	if err != nil {
+		return nil, err
+	}
+	system := llm.Message{Role: "system", Content: instructions + `
+
+This is a conversation. Later questions may refer to earlier ones; use the
+earlier queries and results to resolve words like "that", "then" or "before".`}
+	current := llm.Message{Role: "user", Content: "Reply with ONLY the SQL query for: " + question}
+
+	var history []llm.Message
+	for i := len(s.turns) - 1; i >= 0; i-- {
+		turn := s.turnMessages(s.turns[i])
+		candidate := append(turn, history...)
+		all := append(append([]llm.Message{system}, candidate...), current)
+		if estimateTokens(all) > s.maxContext {
+			break
+		}
+		history = candidate
+	}
+
+	return append(append([]llm.Message{system}, history...), current), nil
+}
+
+// turnMessages replays a finished turn as messages for the model.
+func (s *chatSession) turnMessages(turn chatTurn) []llm.Message {
+	result, _ := s.privacy.share(turn.Result, chatContextRows)
+	return []llm.Message{
+		{Role: "user", Content: "Reply with ONLY the SQL query for: " + turn.Question},
+		{Role: "assistant", Content: turn.SQL},
+		{Role: "user", Content: "Result of that query:\n" + result + "\nAnswer the question briefly in plain language."},
+		{Role: "assistant", Content: turn.Answer},
+	}
+}
+
+// resultText renders up to maxRows rows of result as a plain table.
+func resultText(result *db.QueryResult, maxRows int) string {
+	var buf strings.Builder
+	rows := result.Rows
+	if len(rows) > maxRows {
+		rows = rows[:maxRows]
+	}
+	renderTable(&buf, result.Columns, rows)
+	if len(rows) < len(result.Rows) || result.Truncated {
+		total := fmt.Sprint(len(result.Rows))
+		if result.Truncated {
+			total += "+"
+		}
+		fmt.Fprintf(&buf, "(%d of %s rows shown)\n", len(rows), total)
+	}
+	return buf.String()
+}
+
+// ask answers question, using the earlier turns as context. The result
+// table and the answer, as it is generated, are written to out.
+func (s *chatSession) ask(ctx context.Context, question string) error {
+	messages, err := s.messages(question)
+	if err != nil {
+		return err
+	}
+	answer, conversation, err := generateSQL(ctx, s.provider, s.sandbox, messages, s.retries, s.verbose)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(s.out)
+	renderTable(s.out, answer.Result.Columns, answer.Result.Rows)
+	if answer.Result.Truncated {
+		fmt.Fprintf(s.out, "(showing the first %d rows)\n", s.sandbox.MaxRows)
+	}
+
+	turn := chatTurn{Question: question, SQL: answer.SQL, Result: answer.Result}
+	shared, _ := s.privacy.share(answer.Result, chatContextRows)
+	conversation = append(conversation, llm.Message{
+		Role:    "user",
+		Content: "Result of that query:\n" + shared + "\nAnswer the question briefly in plain language.",
+	})
+	fmt.Fprint(s.out, "\nAnswer: ")
+	turn.Answer, err = llm.Stream(ctx, s.provider, conversation, s.out)
+	fmt.Fprintln(s.out)
+	if err != nil {
+		return fmt.Errorf("error getting interpretation: %v", err)
+	}
+
+	s.turns = append(s.turns, turn)
+	return nil
+}
+
+// explain asks the model how the last query answers its question and
+// writes the explanation to out as it is generated.
+func (s *chatSession) explain(ctx context.Context) error {
+	if len(s.turns) == 0 {
+		return fmt.Errorf("nothing to explain yet")
+	}
+	last := s.turns[len(s.turns)-1]
+	data, err := newPromptData(last.Question)
+	if err != nil {
+		return err
+	}
+	data.SQL = last.SQL
+	prompt, err := renderPrompt(promptExplain, data)
+	if err != nil {
+		return err
+	}
+	_, err = llm.Stream(ctx, s.provider, []llm.Message{{Role: "user", Content: prompt}}, s.out)
+	fmt.Fprintln(s.out)
+	return err
+}
+
+// save writes the conversation to path as Markdown.
+func (s *chatSession) save(path string) error {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "# basal chat, %s\n", time.Now().Format("2006-01-02 15:04"))
+	for _, turn := range s.turns {
+		fmt.Fprintf(&buf, "\n## %s\n\n```sql\n%s\n```\n\n```\n%s```\n\n%s\n", turn.Question, turn.SQL, resultText(turn.Result, len(turn.Result.Rows)), turn.Answer)
+	}
+	return os.WriteFile(path, []byte(buf.String()), 0600)
+}
+
+// reportChatError shows err without ending the chat.
+func reportChatError(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		err = errInterrupted
+	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+}
+
+func runChat(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("error getting LLM configuration: %v", err)
+	}
+	pc, err := llmConfig(cfg)
+	if err != nil {
+		return err
+	}
+	provider, err := llm.New(pc)
+	if err != nil {
+		return fmt.Errorf("error getting LLM configuration: %v", err)
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	sandbox, err := db.OpenSandbox(store)
+	if err != nil {
+		return fmt.Errorf("error opening database for queries: %v", err)
+	}
+	defer sandbox.Close()
+	sandbox.MaxRows, _ = cmd.Flags().GetInt("max-rows")
+
+	session := &chatSession{provider: provider, sandbox: sandbox, out: os.Stdout, privacy: newPrivacyPolicy(cfg)}
+	if session.data, err = describeData(cmd.Context(), sandbox, session.privacy.exampleRows(dataExampleRows)); err != nil {
+		return err
+	}
+	session.retries, _ = cmd.Flags().GetInt("retries")
+	session.maxContext, _ = cmd.Flags().GetInt("max-context")
+	if v, _ := cmd.Flags().GetBool("verbose"); v {
+		session.verbose = cmd.ErrOrStderr()
+	}
+
+	interactive := stdinIsTerminal()
+	if interactive {
+		fmt.Println("Ask a question about your basal rates. Type /help for commands, /quit to leave.")
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		if interactive {
+			fmt.Print("\n> ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "/") {
+			command, arg, _ := strings.Cut(line, " ")
+			arg = strings.TrimSpace(arg)
+			switch command {
+			case "/quit", "/exit":
+				return nil
+			case "/help":
+				fmt.Println("/sql, /explain, /save [file], /reset, /help, /quit")
+			case "/reset":
+				session.turns = nil
+				fmt.Println("Conversation cleared.")
+			case "/sql":
+				if len(session.turns) == 0 {
+					fmt.Println("No query yet.")
+				} else {
+					fmt.Println(session.turns[len(session.turns)-1].SQL)
+				}
+			case "/explain":
+				ctx, stop := interruptible(cmd.Context())
+				err := session.explain(ctx)
+				stop()
+				if err != nil {
+					reportChatError(ctx, err)
+				}
+			case "/save":
+				path := arg
+				if path == "" {
+					path = "basal-chat-" + time.Now().Format("20060102-150405") + ".md"
+				}
+				path, err := cleanPath(path)
+				if err == nil {
+					err = session.save(path)
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error saving conversation: %v\n", err)
+					continue
+				}
+				fmt.Printf("Conversation saved to: %s\n", path)
+			default:
+				fmt.Printf("Unknown command %s. Type /help for commands.\n", command)
+			}
+			continue
+		}
+
+		// Ctrl-C cancels the question being answered rather than the chat
+		ctx, stop := interruptible(cmd.Context())
+		err := session.ask(ctx, line)
+		stop()
+		if err != nil {
+			reportChatError(ctx, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading input: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"basal/config"
+	"basal/db"
+	"basal/llm"
+)
+
+// testChatSession returns a session holding n finished turns of equal size.
+func testChatSession(t *testing.T, n int) *chatSession {
+	t.Helper()
+	// Keep the user's prompt overrides out of the messages
+	configFlag = filepath.Join(t.TempDir(), "config.toml")
+	t.Cleanup(func() { configFlag = "" })
+
+	s := &chatSession{
+		maxContext: 1 << 20,
+		privacy:    privacyPolicy{Results: config.ResultsRows},
+		data:       "basal_records: 1 row",
+	}
+	for i := 1; i <= n; i++ {
+		s.turns = append(s.turns, chatTurn{
+			Question: fmt.Sprintf("question %d", i),
+			SQL:      fmt.Sprintf("SELECT %d", i),
+			Result:   &db.QueryResult{Columns: []string{"n"}, Rows: [][]string{{fmt.Sprint(i)}}},
+			Answer:   fmt.Sprintf("answer %d", i),
+		})
+	}
+	return s
+}
+
+// askedQuestions returns the questions of the turns replayed in messages.
+func askedQuestions(messages []llm.Message) []string {
+	var questions []string
+	for _, m := range messages[1 : len(messages)-1] {
+		if q, ok := strings.CutPrefix(m.Content, "Reply with ONLY the SQL query for: "); ok {
+			questions = append(questions, q)
+		}
+	}
+	return questions
+}
+
+func checkEnds(t *testing.T, messages []llm.Message, question string) {
+	t.Helper()
+	if len(messages) < 2 {
+		t.Fatalf("%d messages, want at least the instructions and the question", len(messages))
+	}
+	if first := messages[0]; first.Role != "system" || !strings.Contains(first.Content, "basal_records: 1 row") {
+		t.Errorf("first message = %+v, want the instructions", first)
+	}
+	if last := messages[len(messages)-1]; last.Role != "user" || !strings.HasSuffix(last.Content, question) {
+		t.Errorf("last message = %+v, want the question %q", last, question)
+	}
+}
+
+func TestChatMessagesKeepTurnsThatFit(t *testing.T) {
+	s := testChatSession(t, 3)
+	messages, err := s.messages("question 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEnds(t, messages, "question 4")
+	if len(messages) != 2+3*4 {
+		t.Errorf("%d messages, want the instructions, 3 turns of 4 and the question", len(messages))
+	}
+	if got := strings.Join(askedQuestions(messages), ", "); got != "question 1, question 2, question 3" {
+		t.Errorf("replayed %s, want all three turns oldest first", got)
+	}
+}
+
+func TestChatMessagesDropOldestTurnsFirst(t *testing.T) {
+	s := testChatSession(t, 5)
+	all, err := s.messages("question 6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	perTurn := estimateTokens(s.turnMessages(s.turns[0]))
+	base := estimateTokens(all) - 5*perTurn // the instructions and the question
+
+	tests := []struct {
+		maxContext int
+		want       string
+	}{
+		{base + 5*perTurn, "question 1, question 2, question 3, question 4, question 5"},
+		{base + 5*perTurn - 1, "question 2, question 3, question 4, question 5"},
+		{base + 2*perTurn, "question 4, question 5"},
+		{base + perTurn + perTurn/2, "question 5"},
+		{base, ""},
+		{1, ""}, // too small even for the question
+	}
+	for _, tt := range tests {
+		s.maxContext = tt.maxContext
+		messages, err := s.messages("question 6")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEnds(t, messages, "question 6")
+		if got := strings.Join(askedQuestions(messages), ", "); got != tt.want {
+			t.Errorf("max context %d: replayed %q, want %q", tt.maxContext, got, tt.want)
+		}
+		if tt.want != "" && estimateTokens(messages) > tt.maxContext {
+			t.Errorf("max context %d: messages take %d tokens", tt.maxContext, estimateTokens(messages))
+		}
+	}
+}
+
+func TestTurnMessages(t *testing.T) {
+	s := testChatSession(t, 0)
+	result := &db.QueryResult{Columns: []string{"date"}}
+	for i := 0; i < chatContextRows+5; i++ {
+		result.Rows = append(result.Rows, []string{fmt.Sprintf("row-%02d", i)})
+	}
+	turn := chatTurn{Question: "which dates", SQL: "SELECT date FROM basal_records", Result: result, Answer: "these"}
+
+	messages := s.turnMessages(turn)
+	roles := make([]string, len(messages))
+	for i, m := range messages {
+		roles[i] = m.Role
+	}
+	if got := strings.Join(roles, " "); got != "user assistant user assistant" {
+		t.Fatalf("roles = %s", got)
+	}
+	if !strings.HasSuffix(messages[0].Content, "which dates") || messages[1].Content != turn.SQL || messages[3].Content != "these" {
+		t.Errorf("messages = %+v", messages)
+	}
+	if shown := messages[2].Content; !strings.Contains(shown, fmt.Sprintf("row-%02d", chatContextRows-1)) || strings.Contains(shown, fmt.Sprintf("row-%02d", chatContextRows)) {
+		t.Errorf("result shows other than the first %d rows:\n%s", chatContextRows, shown)
+	}
+
+	// Only aggregates leave the machine when the policy says so
+	s.privacy = privacyPolicy{Results: config.ResultsAggregates}
+	if shown := s.turnMessages(turn)[2].Content; strings.Contains(shown, "row-00") {
+		t.Errorf("aggregates policy replayed a row:\n%s", shown)
+	}
+}
+
+func TestChatSaveErrorKeepsChatting(t *testing.T) {
+	configPath, _ := plaintextSetup(t)
+	dir := t.TempDir()
+	saved := filepath.Join(dir, "chat.md")
+
+	// Without a home directory ~ cannot be expanded
+	t.Setenv("HOME", "")
+	input := "/save ~/chat.md\n/save " + filepath.Join(dir, "missing", "chat.md") + "\n/save " + saved + "\n/quit\n"
+	stderr := withStdio(t, input)
+
+	if _, err := runBasal(t, configPath, "chat"); err != nil {
+		t.Fatalf("chat ended with %v", err)
+	}
+	if _, err := os.Stat(saved); err != nil {
+		t.Errorf("the last /save did not run: %v", err)
+	}
+	if got := strings.Count(stderr(), "Error saving conversation"); got != 2 {
+		t.Errorf("%d save errors reported, want 2:\n%s", got, stderr())
+	}
+}
+
+// withStdio replaces standard input with input and captures standard output
+// and error for the rest of the test. It returns a function reading what was
+// written to standard error.
+func withStdio(t *testing.T, input string) func() string {
+	t.Helper()
+	dir := t.TempDir()
+	files := make([]*os.File, 3)
+	for i, name := range []string{"stdin", "stdout", "stderr"} {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		files[i] = f
+	}
+	if _, err := files[0].WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := files[0].Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	stdin, stdout, stderr := os.Stdin, os.Stdout, os.Stderr
+	os.Stdin, os.Stdout, os.Stderr = files[0], files[1], files[2]
+	t.Cleanup(func() { os.Stdin, os.Stdout, os.Stderr = stdin, stdout, stderr })
+
+	return func() string {
+		content, _ := os.ReadFile(files[2].Name())
+		return string(content)
+	}
+}
@@ -2,11 +2,20 @@ package cmd
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
+
+	"basal/config"
+	"basal/db"
+	"basal/llm"
 
 	"github.com/manifoldco/promptui"
+	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
 
@@ -21,10 +30,31 @@ var configDBCmd = &cobra.Command{
 	Short: "Configure database location",
 	Long: `Show current database location or update it to a new path.
 If a path is provided as an argument, it will be used as the new location.
-Otherwise, an interactive prompt will be shown.`,
+Otherwise, an interactive prompt will be shown.
+
+Use --encrypt to convert the current database into an encrypted one, or
+--decrypt to convert an encrypted database back to plaintext SQLite. The
+path argument then names the converted file.
+
+After encrypting, the plaintext database and its snapshots are deleted once
+you confirm, or with --delete-plaintext. Without a terminal, either
+--delete-plaintext or --keep-plaintext is required.`,
 	RunE: runConfigDB,
 }
 
+var configStorageCmd = &cobra.Command{
+	Use:   "storage [backend]",
+	Short: "Configure storage backend",
+	Long: `Show the current storage backend or switch to another one.
+Available backends are sqlite (default), json (a plain JSON file that needs no cgo),
+encrypted (an AES-256-GCM encrypted file unlocked with a passphrase or key file)
+and memory (nothing is persisted; useful for trying things out).
+Existing records are not converted when switching backends; use
+'basal config db --encrypt' or '--decrypt' to convert a database.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runConfigStorage,
+}
+
 var configLLMCmd = &cobra.Command{
 	Use:   "llm",
 	Short: "Configure LLM settings",
@@ -32,16 +62,65 @@ var configLLMCmd = &cobra.Command{
 	RunE:  runConfigLLM,
 }
 
-// LLMConfig holds the LLM configuration settings
-type LLMConfig struct {
-	Endpoint string
-	Model    string
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show all settings",
+	Long: `Show the effective value of every setting and where it came from: the
+default, the config file, an environment variable or a flag.`,
+	Args: cobra.NoArgs,
+	RunE: runConfigShow,
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the value of a setting",
+	Long:  `Print the effective value of a setting, e.g. 'basal config get llm.model'.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  runConfigGet,
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Change a setting",
+	Long: `Change a setting in the config file, e.g. 'basal config set llm.model qwen2.5'.
+The value is checked before it is saved: the LLM endpoint must answer, the
+database directory must be writable and a key file must be readable. An
+endpoint on another machine is not contacted unless privacy.allow_remote is
+true. Secret values are not printed. Use
+--no-check to skip these checks. Changing database.path does not move the
+database; use 'basal config db' for that.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runConfigSet,
+}
+
+var configPromptsCmd = &cobra.Command{
+	Use:   "prompts",
+	Short: "Write the prompt templates out for editing",
+	Long: `Copy the prompt templates used by ask and chat into the prompts directory
+next to the config file, where they replace the built-in ones. Files that are
+already there are kept. Add few-shot examples to examples.toml; they are used
+along with the built-in ones. Delete a file to go back to the built-in version.`,
+	Args: cobra.NoArgs,
+	RunE: runConfigPrompts,
 }
 
 func init() {
 	rootCmd.AddCommand(configCmd)
 	configCmd.AddCommand(configDBCmd)
+	configCmd.AddCommand(configStorageCmd)
 	configCmd.AddCommand(configLLMCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configPromptsCmd)
+
+	configDBCmd.Flags().Bool("encrypt", false, "Convert the database to the encrypted backend")
+	configDBCmd.Flags().Bool("decrypt", false, "Convert an encrypted database back to SQLite")
+	configDBCmd.Flags().String("key-file", "", "Key file to encrypt with instead of a passphrase")
+	configDBCmd.Flags().Bool("delete-plaintext", false, "After encrypting, delete the plaintext database and its snapshots")
+	configDBCmd.Flags().Bool("keep-plaintext", false, "After encrypting, keep the plaintext database and its snapshots")
+	configStorageCmd.Flags().String("key-file", "", "Key file for the encrypted backend")
+	configSetCmd.Flags().Bool("no-check", false, "Save the value without checking it")
 }
 
 func cleanPath(path string) (string, error) {
@@ -76,17 +155,39 @@ func validatePath(path string) error {
 }
 
 func runConfigDB(cmd *cobra.Command, args []string) error {
-	configDir, err := os.UserConfigDir()
-	if err != nil {
-		return fmt.Errorf("error getting config directory: %v", err)
+	encrypt, _ := cmd.Flags().GetBool("encrypt")
+	decrypt, _ := cmd.Flags().GetBool("decrypt")
+	if encrypt && decrypt {
+		return fmt.Errorf("--encrypt and --decrypt cannot be used together")
+	}
+	deletePlaintext, _ := cmd.Flags().GetBool("delete-plaintext")
+	keepPlaintext, _ := cmd.Flags().GetBool("keep-plaintext")
+	if (deletePlaintext || keepPlaintext) && !encrypt {
+		return fmt.Errorf("--delete-plaintext and --keep-plaintext are only used with --encrypt")
+	}
+	if deletePlaintext && keepPlaintext {
+		return fmt.Errorf("--delete-plaintext and --keep-plaintext cannot be used together")
+	}
+	if encrypt || decrypt {
+		keyFile, _ := cmd.Flags().GetString("key-file")
+		plaintext := plaintextAsk
+		switch {
+		case deletePlaintext:
+			plaintext = plaintextDelete
+		case keepPlaintext:
+			plaintext = plaintextKeep
+		}
+		return convertDatabase(encrypt, keyFile, strings.Join(args, " "), plaintext)
 	}
 
-	configFile := filepath.Join(configDir, "basal", "config.txt")
-	currentPath := ""
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("error getting configuration: %v", err)
+	}
 
-	// Try to read existing path
-	if content, err := os.ReadFile(configFile); err == nil {
-		currentPath = string(content)
+	// Show the existing path
+	currentPath := cfg.Database.Path
+	if currentPath != "" {
 		fmt.Printf("Current database location: %s\n", currentPath)
 	} else {
 		fmt.Println("No database location configured yet.")
@@ -106,6 +207,9 @@ func runConfigDB(cmd *cobra.Command, args []string) error {
 		}
 	} else {
 		// Interactive mode
+		if err := requireTerminal("pass the new database path as an argument"); err != nil {
+			return err
+		}
 		updatePrompt := promptui.Prompt{
 			Label:     "Would you like to update the database location",
 			IsConfirm: true,
@@ -147,17 +251,10 @@ func runConfigDB(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("error creating database directory: %v", err)
 	}
 
-	// Save path
-	if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
-		return fmt.Errorf("error creating config directory: %v", err)
-	}
-
-	if err := os.WriteFile(configFile, []byte(newPath), 0644); err != nil {
-		return fmt.Errorf("error saving database path: %v", err)
-	}
-
-	// If there was an existing database, offer to copy it
-	if currentPath != "" && currentPath != newPath {
+	// If there was an existing database, offer to copy it. The copy is
+	// made and verified before the new path is saved, so that a failed copy
+	// leaves the configuration pointing at the data.
+	if currentPath != "" && currentPath != newPath && stdinIsTerminal() {
 		copyPrompt := promptui.Prompt{
 			Label:     "Would you like to copy existing database to the new location",
 			IsConfirm: true,
@@ -165,22 +262,102 @@ func runConfigDB(cmd *cobra.Command, args []string) error {
 
 		if _, err := copyPrompt.Run(); err == nil {
 			// User wants to copy
-			if err := copyFile(currentPath, newPath); err != nil {
-				return fmt.Errorf("error copying database: %v", err)
+			if err := copyDatabase(currentPath, newPath); err != nil {
+				return fmt.Errorf("error copying database, the location was not changed: %v", err)
 			}
 			fmt.Println("Database copied successfully!")
 		}
 	}
 
+	// Save path
+	if err := saveDBPath(newPath); err != nil {
+		return fmt.Errorf("error saving database path: %v", err)
+	}
+
 	fmt.Printf("Database location updated to: %s\n", newPath)
 	return nil
 }
 
+func runConfigStorage(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("error getting storage configuration: %v", err)
+	}
+	fmt.Printf("Current storage backend: %s\n", cfg.Database.Backend)
+
+	var backend string
+	if len(args) > 0 {
+		backend = strings.ToLower(strings.TrimSpace(args[0]))
+	} else {
+		if err := requireTerminal("pass the backend as an argument"); err != nil {
+			return err
+		}
+		backendPrompt := promptui.Select{
+			Label: "Storage backend",
+			Items: db.Backends,
+		}
+		if _, backend, err = backendPrompt.Run(); err != nil {
+			return fmt.Errorf("backend prompt failed: %v", err)
+		}
+	}
+
+	if !slices.Contains(db.Backends, backend) {
+		return fmt.Errorf("unknown storage backend %q (want one of %v)", backend, db.Backends)
+	}
+
+	keyFile, _ := cmd.Flags().GetString("key-file")
+	if keyFile, err = cleanPath(keyFile); err != nil {
+		return err
+	}
+
+	err = updateFileConfig(func(cfg *config.Config) error {
+		cfg.Database.Backend = backend
+		if cmd.Flags().Changed("key-file") {
+			cfg.Database.KeyFile = keyFile
+		}
+		if backend != db.BackendEncrypted {
+			cfg.Database.KeyFile = ""
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error writing config: %v", err)
+	}
+
+	fmt.Printf("Storage backend updated to: %s\n", backend)
+	return nil
+}
+
 func runConfigLLM(cmd *cobra.Command, args []string) error {
+	current, _, err := loadFileConfig()
+	if err != nil {
+		return fmt.Errorf("error getting configuration: %v", err)
+	}
+	fmt.Printf("Current LLM provider: %s\n", current.LLM.Provider)
+	fmt.Printf("Current LLM endpoint: %s\n", current.LLM.Endpoint)
+	fmt.Printf("Current LLM model: %s\n", current.LLM.Model)
+
+	if err := requireTerminal("use 'basal config set llm.endpoint <url>' and 'basal config set llm.model <name>'"); err != nil {
+		return err
+	}
+
+	// Get provider
+	providerPrompt := promptui.Select{
+		Label: "LLM Provider",
+		Items: llm.Providers,
+	}
+	if i := slices.Index(llm.Providers, current.LLM.Provider); i >= 0 {
+		providerPrompt.CursorPos = i
+	}
+	_, provider, err := providerPrompt.Run()
+	if err != nil {
+		return fmt.Errorf("provider prompt failed: %v", err)
+	}
+
 	// Get endpoint
 	endpointPrompt := promptui.Prompt{
 		Label:   "LLM API Endpoint",
-		Default: "http://localhost:11434",
+		Default: current.LLM.Endpoint,
 	}
 
 	endpoint, err := endpointPrompt.Run()
@@ -191,7 +368,7 @@ func runConfigLLM(cmd *cobra.Command, args []string) error {
 	// Get model
 	modelPrompt := promptui.Prompt{
 		Label:   "LLM Model",
-		Default: "llama3.2:latest",
+		Default: current.LLM.Model,
 	}
 
 	model, err := modelPrompt.Run()
@@ -200,78 +377,213 @@ func runConfigLLM(cmd *cobra.Command, args []string) error {
 	}
 
 	// Save configuration
-	configDir := filepath.Join(os.Getenv("HOME"), ".config", "basal")
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return fmt.Errorf("error creating config directory: %v", err)
+	err = updateFileConfig(func(cfg *config.Config) error {
+		cfg.LLM.Provider = provider
+		cfg.LLM.Endpoint = endpoint
+		cfg.LLM.Model = model
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error writing config: %v", err)
 	}
 
-	configFile := filepath.Join(configDir, "llm_config")
-	f, err := os.Create(configFile)
+	fmt.Println("LLM configuration updated successfully!")
+	return nil
+}
+
+// copyDatabase writes a verified copy of the database at src to dst. A missing
+// source is not an error, since there is nothing to copy yet.
+func copyDatabase(src, dst string) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	}
+
+	cfg, err := loadConfig()
 	if err != nil {
-		return fmt.Errorf("error creating config file: %v", err)
+		return err
 	}
-	defer f.Close()
+	return db.Backup(cfg.Database.Backend, src, dst)
+}
 
-	_, err = fmt.Fprintf(f, "endpoint=%s\nmodel=%s\n", endpoint, model)
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
 	if err != nil {
-		return fmt.Errorf("error writing config: %v", err)
+		return fmt.Errorf("error getting configuration: %v", err)
+	}
+	path, err := getConfigPath()
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("LLM configuration updated successfully!")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		fmt.Printf("Config file: %s (not created yet)\n\n", path)
+	} else {
+		fmt.Printf("Config file: %s\n\n", path)
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Setting", "Value", "Source"})
+	table.SetBorder(false)
+	table.SetColumnSeparator("  ")
+	table.SetAutoWrapText(false)
+	for _, setting := range config.Settings {
+		table.Append([]string{setting.Key, shownValue(cfg, setting), cfg.Source(setting.Key)})
+	}
+	table.Render()
 	return nil
 }
 
-func copyFile(src, dst string) error {
-	// Read the source file
-	content, err := os.ReadFile(src)
+// shownValue is how config show and set display the value of setting, with
+// secrets hidden.
+func shownValue(cfg *config.Config, setting config.Setting) string {
+	value, _ := cfg.Get(setting.Key)
+	switch {
+	case value == "":
+		return "(not set)"
+	case setting.Secret:
+		return "(hidden)"
+	}
+	return value
+}
+
+func runConfigGet(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("error getting configuration: %v", err)
+	}
+	value, err := cfg.Get(args[0])
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Source file doesn't exist yet, which is fine
-			return nil
-		}
 		return err
 	}
+	fmt.Println(value)
+	return nil
+}
 
-	// Write to destination
-	return os.WriteFile(dst, content, 0644)
+func runConfigPrompts(cmd *cobra.Command, args []string) error {
+	dir, err := promptDir()
+	if err != nil {
+		return err
+	}
+	written, err := writeDefaultPrompts(dir)
+	for _, path := range written {
+		fmt.Printf("Wrote %s\n", path)
+	}
+	if err != nil {
+		return err
+	}
+	if len(written) == 0 {
+		fmt.Printf("The prompt files in %s already exist and were kept.\n", dir)
+	}
+	return nil
 }
 
-// getLLMConfig reads the LLM configuration from the config file
-func getLLMConfig() (*LLMConfig, error) {
-	configDir, err := os.UserConfigDir()
+func runConfigSet(cmd *cobra.Command, args []string) error {
+	setting, err := config.LookupSetting(args[0])
 	if err != nil {
-		return nil, fmt.Errorf("getting config directory: %w", err)
+		return err
+	}
+	value := strings.TrimSpace(args[1])
+
+	switch setting.Key {
+	case "database.path", "database.key_file":
+		if value, err = cleanPath(value); err != nil {
+			return err
+		}
+	case "database.backend", "llm.provider":
+		value = strings.ToLower(value)
 	}
 
-	configFile := filepath.Join(configDir, "basal", "llm_config")
-	content, err := os.ReadFile(configFile)
+	out := cmd.OutOrStdout()
+	noCheck, _ := cmd.Flags().GetBool("no-check")
+	check := !noCheck
+	// Contacting an endpoint on another machine is what allow_remote forbids
+	if check && setting.Key == "llm.endpoint" && !llm.IsLocal(value) {
+		if cfg, err := loadConfig(); err != nil || !cfg.Privacy.AllowRemote {
+			fmt.Fprintf(out, "Note: %s is not on this machine, so it was not contacted. basal will not use it until you run 'basal config set privacy.allow_remote true'.\n", value)
+			check = false
+		}
+	}
+	if check {
+		if err := checkSetting(setting.Key, value); err != nil {
+			return fmt.Errorf("%s: %v (use --no-check to save it anyway)", setting.Key, err)
+		}
+	}
+
+	var shown string
+	err = updateFileConfig(func(cfg *config.Config) error {
+		if err := cfg.Set(setting.Key, value); err != nil {
+			return err
+		}
+		if setting.Key == "database.backend" && value != db.BackendEncrypted {
+			cfg.Database.KeyFile = ""
+		}
+		shown = shownValue(cfg, setting)
+		return nil
+	})
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Return default config if file doesn't exist
-			return &LLMConfig{
-				Endpoint: "http://localhost:11434",
-				Model:    "llama3.2:latest",
-			}, nil
+		return fmt.Errorf("error writing config: %v", err)
+	}
+	fmt.Fprintf(out, "%s set to: %s\n", setting.Key, shown)
+
+	// Let the user know if the new value is not the one in effect.
+	if cfg, err := loadConfig(); err == nil {
+		if source := cfg.Source(setting.Key); source != config.SourceFile && source != config.SourceDefault {
+			fmt.Fprintf(out, "Note: %s is currently overridden by %s\n", setting.Key, source)
 		}
-		return nil, fmt.Errorf("reading config file: %w", err)
 	}
+	return nil
+}
 
-	config := &LLMConfig{}
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
+// checkSetting checks a new value against the outside world, beyond what
+// config.Validate can tell from the value alone.
+func checkSetting(key, value string) error {
+	switch key {
+	case "database.path":
+		if value == "" {
+			return fmt.Errorf("path cannot be empty")
 		}
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-		switch key {
-		case "endpoint":
-			config.Endpoint = value
-		case "model":
-			config.Model = value
+		return checkWritableDir(filepath.Dir(value))
+	case "database.key_file":
+		if value == "" {
+			return nil
 		}
+		_, err := storeSecret(value, false)()
+		return err
+	case "llm.endpoint":
+		return pingEndpoint(value)
 	}
+	return nil
+}
 
-	return config, nil
+// checkWritableDir creates dir if needed and checks a file can be written there.
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot create directory: %v", err)
+	}
+	probe, err := os.CreateTemp(dir, ".basal-write-check-*")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %v", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
+// pingEndpoint checks that an HTTP server answers at endpoint.
+func pingEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", endpoint)
+	}
+
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return fmt.Errorf("endpoint is not reachable: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
 }
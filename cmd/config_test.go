@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"basal/config"
+)
+
+// runBasal runs basal with args against a config file in a temporary
+// directory and returns what it printed.
+func runBasal(t *testing.T, configPath string, args ...string) (string, error) {
+	t.Helper()
+	resetFlags()
+	t.Cleanup(func() {
+		configFlag = ""
+		resetFlags()
+	})
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	rootCmd.SetErr(&out)
+	rootCmd.SetArgs(append([]string{"--config", configPath}, args...))
+	err := rootCmd.Execute()
+	return out.String(), err
+}
+
+// resetFlags clears the flags the tests set, since cobra keeps flag values
+// between runs.
+func resetFlags() {
+	configSetCmd.Flags().Set("no-check", "false")
+	for _, flag := range []string{"encrypt", "decrypt", "delete-plaintext", "keep-plaintext"} {
+		configDBCmd.Flags().Set(flag, "false")
+	}
+	configDBCmd.Flags().Set("key-file", "")
+	askCmd.Flags().Set("history", "false")
+	for _, flag := range []string{"rerun", "save", "delete"} {
+		askCmd.Flags().Set(flag, "")
+	}
+}
+
+func TestConfigSetHidesSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	out, err := runBasal(t, path, "config", "set", "llm.api_key", "sk-very-secret")
+	if err != nil {
+		t.Fatalf("config set: %v\n%s", err, out)
+	}
+	if strings.Contains(out, "sk-very-secret") {
+		t.Errorf("config set printed the API key: %q", out)
+	}
+	if !strings.Contains(out, "llm.api_key set to: (hidden)") {
+		t.Errorf("output = %q, want the key shown as (hidden)", out)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "sk-very-secret") {
+		t.Error("the API key was not saved")
+	}
+}
+
+func TestConfigSetDoesNotContactForbiddenEndpoint(t *testing.T) {
+	t.Setenv(config.EnvPrivacyAllowRemote, "")
+	path := filepath.Join(t.TempDir(), "config.toml")
+	// A documentation address that nothing answers on; a ping would fail
+	out, err := runBasal(t, path, "config", "set", "llm.endpoint", "http://192.0.2.1:11434")
+	if err != nil {
+		t.Fatalf("config set: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, "not contacted") || !strings.Contains(out, "privacy.allow_remote") {
+		t.Errorf("output = %q, want a note that the endpoint was not contacted", out)
+	}
+}
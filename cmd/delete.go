@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"strconv"
 
-	"basal/db"
-
 	"github.com/spf13/cobra"
 )
 
@@ -27,17 +25,17 @@ func runDelete(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid ID: %v", err)
 	}
 
-	dbPath, err := getDBPath()
-	if err != nil {
-		return fmt.Errorf("error getting database path: %v", err)
+	if err := snapshotBeforeChange(); err != nil {
+		return err
 	}
-	database, err := db.InitDB(dbPath)
+
+	store, err := openStore()
 	if err != nil {
-		return fmt.Errorf("error initializing database: %v", err)
+		return err
 	}
-	defer database.Close()
+	defer store.Close()
 
-	if err := db.DeleteBasalRecord(database, id); err != nil {
+	if err := store.DeleteBasalRecord(id); err != nil {
 		return fmt.Errorf("error deleting record: %v", err)
 	}
 
@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"basal/config"
+	"basal/db"
+
+	"github.com/manifoldco/promptui"
+)
+
+// passphraseEnv lets scripts unlock an encrypted database without a prompt.
+const passphraseEnv = "BASAL_PASSPHRASE"
+
+// storeSecret returns a function that reads the key file if one is configured,
+// otherwise the passphrase from the environment or an interactive prompt.
+// When creating a new database the prompted passphrase must be entered twice.
+func storeSecret(keyFile string, confirm bool) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		if keyFile != "" {
+			content, err := os.ReadFile(keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading key file: %w", err)
+			}
+			key := bytes.TrimSpace(content)
+			if len(key) == 0 {
+				return nil, fmt.Errorf("key file %s is empty", keyFile)
+			}
+			return key, nil
+		}
+
+		if pass := os.Getenv(passphraseEnv); pass != "" {
+			return []byte(pass), nil
+		}
+
+		if err := requireTerminal(fmt.Sprintf("set %s or configure a key file to unlock the database", passphraseEnv)); err != nil {
+			return nil, err
+		}
+
+		passPrompt := promptui.Prompt{
+			Label: "Database passphrase",
+			Mask:  '*',
+		}
+		pass, err := passPrompt.Run()
+		if err != nil {
+			return nil, fmt.Errorf("passphrase prompt failed: %v", err)
+		}
+
+		if confirm {
+			confirmPrompt := promptui.Prompt{
+				Label: "Confirm passphrase",
+				Mask:  '*',
+			}
+			again, err := confirmPrompt.Run()
+			if err != nil {
+				return nil, fmt.Errorf("passphrase prompt failed: %v", err)
+			}
+			if again != pass {
+				return nil, fmt.Errorf("passphrases do not match")
+			}
+		}
+
+		return []byte(pass), nil
+	}
+}
+
+// What convertDatabase does with the plaintext copies left after encrypting.
+const (
+	plaintextAsk    = "ask"    // ask, and refuse to run without a terminal
+	plaintextDelete = "delete" // delete them
+	plaintextKeep   = "keep"   // keep them
+)
+
+// rememberSecret calls secret at most once, so that a store can be reopened
+// without asking for the passphrase again.
+func rememberSecret(secret func() ([]byte, error)) func() ([]byte, error) {
+	var (
+		once sync.Once
+		key  []byte
+		err  error
+	)
+	return func() ([]byte, error) {
+		once.Do(func() { key, err = secret() })
+		return key, err
+	}
+}
+
+// convertDatabase copies the current database into a new file using the
+// encrypted backend (or, when decrypting, the SQLite backend) and switches
+// the configuration over to it. After encrypting, plaintext says what happens
+// to the old database and its snapshots, which hold the same data in the clear.
+func convertDatabase(encrypt bool, keyFile, newPath, plaintext string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("error getting storage configuration: %v", err)
+	}
+	currentPath, err := getDBPath()
+	if err != nil {
+		return fmt.Errorf("error getting database path: %v", err)
+	}
+
+	if encrypt && cfg.Database.Backend == db.BackendEncrypted {
+		return fmt.Errorf("the database is already encrypted")
+	}
+	if !encrypt && cfg.Database.Backend != db.BackendEncrypted {
+		return fmt.Errorf("the database is not encrypted")
+	}
+	if cfg.Database.Backend == db.BackendMemory {
+		return fmt.Errorf("the memory backend has nothing to convert")
+	}
+	// Decide before converting, so that no plaintext is left behind unasked
+	if encrypt && plaintext == plaintextAsk && !stdinIsTerminal() {
+		return fmt.Errorf("stdin is not a terminal: pass --delete-plaintext to delete the plaintext database and its snapshots after encrypting, or --keep-plaintext to keep them")
+	}
+
+	if newPath == "" {
+		if encrypt {
+			newPath = currentPath + ".enc"
+		} else if trimmed := strings.TrimSuffix(currentPath, ".enc"); trimmed != currentPath {
+			newPath = trimmed
+		} else {
+			newPath = currentPath + ".db"
+		}
+	}
+	if newPath, err = cleanPath(newPath); err != nil {
+		return err
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("%s already exists", newPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return fmt.Errorf("error creating database directory: %v", err)
+	}
+
+	src, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	next := config.Database{Path: newPath, Backend: db.BackendSQLite}
+	if encrypt {
+		next.Backend = db.BackendEncrypted
+		if keyFile != "" {
+			if next.KeyFile, err = cleanPath(keyFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	storeConfig := db.StoreConfig{
+		Backend: next.Backend,
+		Path:    newPath,
+		Secret:  rememberSecret(storeSecret(next.KeyFile, true)),
+	}
+	dst, err := db.OpenStore(storeConfig)
+	if err != nil {
+		return fmt.Errorf("error creating converted database: %v", err)
+	}
+	if err := db.CopyStore(dst, src); err != nil {
+		dst.Close()
+		os.Remove(newPath)
+		return fmt.Errorf("error converting database: %v", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(newPath)
+		return fmt.Errorf("error writing converted database: %v", err)
+	}
+	if err := verifyConverted(storeConfig, src); err != nil {
+		os.Remove(newPath)
+		return fmt.Errorf("error verifying converted database: %v", err)
+	}
+
+	err = updateFileConfig(func(cfg *config.Config) error {
+		cfg.Database = next
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error saving configuration: %v", err)
+	}
+
+	if !encrypt {
+		fmt.Printf("Decrypted database written to: %s\n", newPath)
+		deletePrompt := promptui.Prompt{
+			Label:     fmt.Sprintf("Delete the old database at %s", currentPath),
+			IsConfirm: true,
+		}
+		if !stdinIsTerminal() {
+			fmt.Printf("The old database was kept at: %s\n", currentPath)
+		} else if _, err := deletePrompt.Run(); err == nil {
+			if err := os.Remove(currentPath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error deleting old database: %v", err)
+			}
+			fmt.Println("Old database deleted.")
+		}
+		return nil
+	}
+
+	fmt.Printf("Encrypted database written to: %s\n", newPath)
+	// Nothing may hold the old files open while they are deleted
+	src.Close()
+	leftovers := plaintextCopies(currentPath)
+	if len(leftovers) == 0 {
+		return nil
+	}
+	if plaintext == plaintextAsk {
+		deletePrompt := promptui.Prompt{
+			Label:     fmt.Sprintf("Delete the unencrypted copies of your data (%s)", strings.Join(leftovers, ", ")),
+			IsConfirm: true,
+		}
+		plaintext = plaintextKeep
+		if _, err := deletePrompt.Run(); err == nil {
+			plaintext = plaintextDelete
+		}
+	}
+	if plaintext == plaintextKeep {
+		fmt.Printf("Warning: your data is still unencrypted in: %s\n", strings.Join(leftovers, ", "))
+		return nil
+	}
+	for _, path := range leftovers {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("error deleting plaintext copy: %v", err)
+		}
+	}
+	fmt.Println("Plaintext database and snapshots deleted.")
+	return nil
+}
+
+// plaintextCopies returns the files holding the unencrypted data of the
+// database at path: the database, any journal beside it and its snapshot
+// directory.
+func plaintextCopies(path string) []string {
+	var copies []string
+	for _, p := range []string{path, path + "-journal", path + "-wal", path + "-shm", db.SnapshotDir(path)} {
+		if _, err := os.Stat(p); err == nil {
+			copies = append(copies, p)
+		}
+	}
+	return copies
+}
+
+// verifyConverted reopens the converted database and checks it holds the
+// same records as src.
+func verifyConverted(storeConfig db.StoreConfig, src db.Store) error {
+	converted, err := db.OpenStore(storeConfig)
+	if err != nil {
+		return err
+	}
+	defer converted.Close()
+
+	want, err := src.ListBasalRecords()
+	if err != nil {
+		return err
+	}
+	got, err := converted.ListBasalRecords()
+	if err != nil {
+		return err
+	}
+	if len(got) != len(want) {
+		return fmt.Errorf("%d records were copied, want %d", len(got), len(want))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"basal/config"
+	"basal/db"
+	"basal/schedule"
+)
+
+// addTestRecord stores a schedule running rate until 06:00 and rate+0.2 for
+// the rest of the day.
+func addTestRecord(t *testing.T, store db.Store, date string, rate float64) int64 {
+	t.Helper()
+	d, err := time.Parse(db.DateFormat, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := store.CreateBasalRecord(d, schedule.Schedule{
+		{StartTime: schedule.Midnight, EndTime: 6 * 3600, UnitsPerHour: rate},
+		{StartTime: 6 * 3600, EndTime: schedule.Midnight, UnitsPerHour: rate + 0.2},
+	})
+	if err != nil {
+		t.Fatalf("CreateBasalRecord: %v", err)
+	}
+	return id
+}
+
+// plaintextSetup writes a config using a SQLite database that holds a record
+// and has a snapshot, and returns the config and database paths.
+func plaintextSetup(t *testing.T) (configPath, dbPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	configPath = filepath.Join(dir, "config.toml")
+	dbPath = filepath.Join(dir, "basal.db")
+
+	store, err := db.OpenSQLiteStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestRecord(t, store, "2024-01-01", 0.8)
+	store.Close()
+	if _, err := db.Snapshot(db.BackendSQLite, dbPath); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.Database.Path = dbPath
+	if err := cfg.Save(configPath); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(passphraseEnv, "correct horse")
+	return configPath, dbPath
+}
+
+func TestEncryptRequiresPlaintextChoiceWithoutTerminal(t *testing.T) {
+	configPath, dbPath := plaintextSetup(t)
+	out, err := runBasal(t, configPath, "config", "db", "--encrypt")
+	if err == nil || !strings.Contains(err.Error(), "--delete-plaintext") {
+		t.Fatalf("err = %v, want a request for --delete-plaintext or --keep-plaintext\n%s", err, out)
+	}
+	if _, err := os.Stat(dbPath + ".enc"); !os.IsNotExist(err) {
+		t.Errorf("an encrypted copy was written before refusing: %v", err)
+	}
+}
+
+func TestEncryptDeletesPlaintext(t *testing.T) {
+	configPath, dbPath := plaintextSetup(t)
+	if out, err := runBasal(t, configPath, "config", "db", "--encrypt", "--delete-plaintext"); err != nil {
+		t.Fatalf("config db --encrypt: %v\n%s", err, out)
+	}
+
+	for _, path := range []string{dbPath, db.SnapshotDir(dbPath)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s is still there: %v", path, err)
+		}
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Backend != db.BackendEncrypted || cfg.Database.Path != dbPath+".enc" {
+		t.Errorf("database config = %+v", cfg.Database)
+	}
+	store, err := db.OpenEncryptedStore(cfg.Database.Path, storeSecret("", false))
+	if err != nil {
+		t.Fatalf("opening the encrypted copy: %v", err)
+	}
+	defer store.Close()
+	if records, _ := store.ListBasalRecords(); len(records) != 1 {
+		t.Errorf("encrypted copy has %d records, want 1", len(records))
+	}
+}
+
+func TestEncryptKeepsPlaintextWhenAsked(t *testing.T) {
+	configPath, dbPath := plaintextSetup(t)
+	out, err := runBasal(t, configPath, "config", "db", "--encrypt", "--keep-plaintext")
+	if err != nil {
+		t.Fatalf("config db --encrypt: %v\n%s", err, out)
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		t.Errorf("plaintext database was deleted: %v", err)
+	}
+}
+
+func TestStoreSecret(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "basal.key")
+	if err := os.WriteFile(keyFile, []byte("  key-file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(passphraseEnv, "env-passphrase")
+
+	// A configured key file wins over the passphrase
+	if key, err := storeSecret(keyFile, false)(); err != nil || string(key) != "key-file-secret" {
+		t.Errorf("key file: %q, %v", key, err)
+	}
+	if key, err := storeSecret("", false)(); err != nil || string(key) != "env-passphrase" {
+		t.Errorf("passphrase: %q, %v", key, err)
+	}
+
+	empty := filepath.Join(dir, "empty.key")
+	if err := os.WriteFile(empty, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storeSecret(empty, false)(); err == nil {
+		t.Error("an empty key file was accepted")
+	}
+	if _, err := storeSecret(filepath.Join(dir, "missing.key"), false)(); err == nil {
+		t.Error("a missing key file was accepted")
+	}
+}
@@ -22,6 +22,11 @@ func runHelp(cmd *cobra.Command, args []string) error {
 Basal - Insulin Basal Rate Tracker
 
 Available Commands:
+  init                 Create the config file and database
+    Usage: basal init --yes
+    Uses --db or BASAL_DB, else the configured path, else the default
+    location in the user data directory. --yes skips the prompt.
+
   add                  Add a new basal rate record
     Usage: basal add
     Interactively add a new basal rate record with time intervals.
@@ -33,6 +38,7 @@ Available Commands:
   delete [id]          Delete a basal rate record
     Usage: basal delete 123
     Deletes the basal rate record with the specified ID.
+    A snapshot of the database is taken first.
 
   show [YYYY-MM-DD]    Show basal rates for a specific date
     Usage: basal show 2024-03-15
@@ -42,16 +48,109 @@ Available Commands:
   ask [text]           Ask questions about your basal rates
     Usage: basal ask "what was my basal rate on Dec 2, 2023"
     Converts natural language to SQL and queries the database.
-    Requires Ollama to be running locally.
+    Common questions (the rate or total on a date, "last Tuesday at 3am",
+    the highest or lowest total, the last change) are answered directly
+    without the LLM; --offline never uses the LLM.
+    Requires Ollama or an OpenAI-compatible server (see llm.provider).
+    The model is told what the schema means, the range of the data and a
+    few example rows, and can query the effective_schedule view and the
+    daily_basal view, which has the record in effect on every day.
+    Queries run read-only in a sandbox that only allows a single SELECT
+    on the basal tables. --max-rows limits the rows shown (default 1000).
+    Failing queries are sent back to the model to fix, up to --retries
+    times (default 2); --verbose shows every attempt.
+    The answer is printed as the model writes it; Ctrl-C stops it.
+    With --tools the model calls typed lookups (get_schedule, rate_at,
+    list_changes, daily_totals) instead of writing SQL.
+    Numbers in the answer that are not in the results are flagged;
+    --explain also shows the query plan and where each value came from.
+    Every answer is kept: --history lists them, --rerun <id or name> runs
+    a saved query again without the LLM and --save <name> names a
+    question as a report.
+    A question asked before with the same model and prompts reuses its
+    query instead of asking the model again; --no-cache skips this.
+
+  chat                 Ask follow-up questions about your basal rates
+    Usage: basal chat
+    Like ask, but earlier questions and results are kept as context.
+    Ctrl-C cancels the current answer without leaving the chat.
+    Commands: /sql, /explain, /save [file], /reset, /help, /quit.
+    --max-context sets the model context window in tokens (default 4096).
+
+  llm doctor           Check that the LLM endpoint and model work
+    Usage: basal llm doctor
+    Checks that the server answers, that the model is installed and that
+    it replies, and explains how to fix the first problem found.
+
+  llm eval             Measure how well models answer questions
+    Usage: basal llm eval --model llama3.2,qwen2.5
+    Asks a suite of questions about a fixture database and compares the
+    rows each model's query returns with a reference query. --suite runs
+    your own suite and --min-accuracy fails the run below a percentage.
+
+  backup [path]        Back up the database
+    Usage: basal backup ~/basal-backup.db
+    Writes a verified copy of the database. Use --list to show automatic snapshots.
+
+  restore <path>       Restore the database from a backup
+    Usage: basal restore ~/basal-backup.db
+    Verifies the backup and replaces the database, snapshotting the current one first.
 
   config               Configuration commands
     Usage: basal config [command]
     Available subcommands:
       db [path]        Configure database location
                       Can provide path directly or use interactive prompt
+                      --encrypt/--decrypt convert the database to or from
+                      the encrypted backend; --delete-plaintext or
+                      --keep-plaintext says what happens to the plaintext
+                      database and its snapshots after encrypting
+      storage [name]   Choose the storage backend (sqlite, json, encrypted, memory)
       llm              Configure LLM settings
+      show             Show every setting and where its value came from
+      get <key>        Print a setting, e.g. basal config get llm.model
+      set <key> <val>  Change a setting after checking it (--no-check to skip)
+      prompts          Write the ask and chat prompt templates out for editing
 
   help                 Show this help message
-    Usage: basal help`)
+    Usage: basal help
+
+Global Flags:
+  --config <file>      Config file to use instead of the default
+  --db <path>          Database path to use instead of the configured one
+
+Configuration:
+  Settings are stored in config.toml in the user config directory:
+    [database]
+      path      Database location
+      backend   sqlite, json, encrypted or memory
+      key_file  Key file for the encrypted backend (optional)
+    [llm]
+      provider  ollama (default) or openai for OpenAI-compatible servers
+                such as llama.cpp, LM Studio and vLLM
+      endpoint  LLM API endpoint, e.g. http://localhost:11434
+      model     LLM model name, e.g. llama3.2:latest
+      api_key   API key sent as a bearer token (optional)
+      timeout   How long to wait for the model to start replying (default 2m)
+    [llm.headers]
+      Extra HTTP headers sent with every request (optional); config show
+      and get list their names with the values hidden
+    [privacy]
+      allow_remote  true to allow an LLM endpoint that is not on this
+                    machine (default false)
+      results       What the LLM sees of query results: rows (default)
+                    or aggregates (per-column counts, ranges and means)
+      max_rows      Most result rows sent to the LLM (default 50)
+      audit_log     Where requests to a remote LLM are logged (default
+                    llm-audit.log in the data directory)
+
+  Environment variables override the file, and flags override both:
+    BASAL_CONFIG, BASAL_DB, BASAL_DB_BACKEND, BASAL_DB_KEY_FILE,
+    BASAL_LLM_PROVIDER, BASAL_LLM_ENDPOINT, BASAL_LLM_MODEL,
+    BASAL_LLM_API_KEY, BASAL_LLM_TIMEOUT, BASAL_LLM_HEADERS (Name=value,...),
+    BASAL_PRIVACY_ALLOW_REMOTE, BASAL_PRIVACY_RESULTS, BASAL_PRIVACY_MAX_ROWS,
+    BASAL_PRIVACY_AUDIT_LOG, BASAL_PASSPHRASE
+
+  When stdin is not a terminal, commands fail instead of prompting.`)
 	return nil
 }
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"basal/config"
+	"basal/db"
+
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cobra"
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create the config file and database",
+	Long: `Create the config file and an empty database without any other prompts.
+The database goes to --db or BASAL_DB if set, otherwise the configured path,
+otherwise the default location in the user data directory.`,
+	Args: cobra.NoArgs,
+	RunE: runInit,
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().BoolP("yes", "y", false, "Accept the database location without prompting")
+}
+
+func runInit(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("error getting configuration: %v", err)
+	}
+	if cfg.Database.Backend == db.BackendMemory {
+		return fmt.Errorf("the memory backend keeps nothing on disk; choose another backend with 'basal config storage'")
+	}
+
+	dbPath := cfg.Database.Path
+	if dbPath == "" {
+		if dbPath, err = config.DefaultDBPath(cfg.Database.Backend); err != nil {
+			return err
+		}
+	}
+
+	if yes, _ := cmd.Flags().GetBool("yes"); !yes {
+		if err := requireTerminal("pass --yes to accept the database location"); err != nil {
+			return err
+		}
+		pathPrompt := promptui.Prompt{
+			Label:   "Database path",
+			Default: dbPath,
+		}
+		if dbPath, err = pathPrompt.Run(); err != nil {
+			return fmt.Errorf("path prompt failed: %v", err)
+		}
+	}
+
+	if dbPath, err = cleanPath(dbPath); err != nil {
+		return err
+	}
+	if dbPath == "" {
+		return fmt.Errorf("path cannot be empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return fmt.Errorf("error creating database directory: %v", err)
+	}
+
+	err = updateFileConfig(func(file *config.Config) error {
+		file.Database.Path = dbPath
+		file.Database.Backend = cfg.Database.Backend
+		file.Database.KeyFile = cfg.Database.KeyFile
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error writing config: %v", err)
+	}
+	configPath, err := getConfigPath()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Configuration written to: %s\n", configPath)
+
+	_, statErr := os.Stat(dbPath)
+	exists := statErr == nil
+
+	store, err := db.OpenStore(db.StoreConfig{
+		Backend: cfg.Database.Backend,
+		Path:    dbPath,
+		Secret:  storeSecret(cfg.Database.KeyFile, !exists),
+	})
+	if err != nil {
+		return fmt.Errorf("error initializing database: %v", err)
+	}
+	// The JSON and encrypted stores only write their file on the first
+	// change, so copy in an empty store to create it now.
+	if !exists {
+		if err := db.CopyStore(store, db.NewMemoryStore()); err != nil {
+			store.Close()
+			return fmt.Errorf("error initializing database: %v", err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		return fmt.Errorf("error closing database: %v", err)
+	}
+
+	if exists {
+		fmt.Printf("Using existing database: %s\n", dbPath)
+	} else {
+		fmt.Printf("Database created at: %s\n", dbPath)
+	}
+	return nil
+}
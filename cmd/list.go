@@ -20,17 +20,13 @@ func init() {
 }
 
 func runList(cmd *cobra.Command, args []string) error {
-	dbPath, err := getDBPath()
+	store, err := openStore()
 	if err != nil {
-		return fmt.Errorf("error getting database path: %v", err)
+		return err
 	}
-	database, err := db.InitDB(dbPath)
-	if err != nil {
-		return fmt.Errorf("error initializing database: %v", err)
-	}
-	defer database.Close()
+	defer store.Close()
 
-	records, err := db.ListBasalRecords(database)
+	records, err := store.ListBasalRecords()
 	if err != nil {
 		return fmt.Errorf("error listing records: %v", err)
 	}
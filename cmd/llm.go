@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"basal/llm"
+
+	"github.com/spf13/cobra"
+)
+
+var llmCmd = &cobra.Command{
+	Use:   "llm",
+	Short: "Check the language model used by ask and chat",
+}
+
+var llmDoctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that the LLM endpoint and model work",
+	Long: `Check the configured LLM step by step: that the server answers, that the
+model is installed, and that the model replies to a short prompt. The first
+failing step is reported along with how to fix it.`,
+	Args: cobra.NoArgs,
+	RunE: runLLMDoctor,
+}
+
+var llmEvalCmd = &cobra.Command{
+	Use:   "eval",
+	Short: "Measure how well models answer questions",
+	Long: `Ask a suite of questions about a fixture database and check the answers.
+A question is answered correctly when the rows returned by the model's query
+match those of a reference query; the SQL itself is not compared. Accuracy,
+latency and failures are reported for each model.`,
+	Args: cobra.NoArgs,
+	RunE: runLLMEval,
+}
+
+func init() {
+	rootCmd.AddCommand(llmCmd)
+	llmCmd.AddCommand(llmDoctorCmd)
+	llmCmd.AddCommand(llmEvalCmd)
+
+	llmEvalCmd.Flags().String("suite", "", "Suite file to run instead of the built-in one")
+	llmEvalCmd.Flags().StringSlice("model", nil, "Model to evaluate; repeat or separate with commas (default is the configured model)")
+	llmEvalCmd.Flags().Int("retries", 2, "How many times to ask the model to fix a failing query")
+	llmEvalCmd.Flags().Float64("min-accuracy", 0, "Fail unless every model answers at least this percentage correctly")
+	llmEvalCmd.Flags().BoolP("verbose", "v", false, "Show every generated query and why it failed")
+}
+
+// hasModel reports whether model is among the installed models. Ollama adds
+// the ":latest" tag to names given without one.
+func hasModel(models []string, model string) bool {
+	if slices.Contains(models, model) {
+		return true
+	}
+	return !strings.Contains(model, ":") && slices.Contains(models, model+":latest")
+}
+
+func runLLMDoctor(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("error getting configuration: %v", err)
+	}
+	pc, err := llmConfig(cfg)
+	if err != nil {
+		return err
+	}
+	provider, err := llm.New(pc)
+	if err != nil {
+		return fmt.Errorf("error getting LLM configuration: %v", err)
+	}
+
+	ctx, stop := interruptible(cmd.Context())
+	defer stop()
+
+	fmt.Printf("Provider: %s\nEndpoint: %s\nModel:    %s\n\n", provider.Name(), cfg.LLM.Endpoint, cfg.LLM.Model)
+
+	// 1. The server answers; OpenAI-compatible servers have no common way
+	// to ask, so the next steps have to tell
+	if ollama, ok := provider.(*llm.Ollama); ok {
+		fmt.Print("Checking the server... ")
+		version, err := ollama.Version(ctx)
+		if err != nil {
+			fmt.Println("failed")
+			return fmt.Errorf("%v\nIs Ollama running? Start it with 'ollama serve', or point basal at it with 'basal config set llm.endpoint <url>'", err)
+		}
+		fmt.Printf("ok (Ollama %s)\n", version)
+	}
+
+	// 2. The model is installed
+	fmt.Print("Checking the model... ")
+	var models []string
+	if lister, ok := provider.(llm.ModelLister); ok {
+		models, err = lister.Models(ctx)
+	} else {
+		err = fmt.Errorf("not supported by the %s provider", provider.Name())
+	}
+	switch {
+	case err != nil && provider.Name() == llm.ProviderOllama:
+		fmt.Println("failed")
+		return fmt.Errorf("error listing models: %v", err)
+	case err != nil:
+		// Not every OpenAI-compatible server lists its models
+		fmt.Printf("skipped (the server does not list models: %v)\n", err)
+	case !hasModel(models, cfg.LLM.Model):
+		fmt.Println("failed")
+		installed := "none"
+		if len(models) > 0 {
+			installed = strings.Join(models, ", ")
+		}
+		if provider.Name() == llm.ProviderOllama {
+			return fmt.Errorf("model %q is not installed. Install it with 'ollama pull %s', or choose an installed one with 'basal config set llm.model <name>'.\nInstalled models: %s", cfg.LLM.Model, cfg.LLM.Model, installed)
+		}
+		return fmt.Errorf("the server does not offer model %q. Choose one with 'basal config set llm.model <name>'.\nAvailable models: %s", cfg.LLM.Model, installed)
+	default:
+		fmt.Println("ok")
+	}
+
+	// 3. The model replies
+	fmt.Print("Asking the model to reply... ")
+	start := time.Now()
+	if _, err := llm.Ask(ctx, provider, "Reply with the single word OK."); err != nil {
+		fmt.Println("failed")
+		if ctx.Err() == context.Canceled {
+			return errInterrupted
+		}
+		return fmt.Errorf("%v\nIf the model is slow to load, raise the timeout with 'basal config set llm.timeout 5m'", err)
+	}
+	fmt.Printf("ok (%.1fs)\n", time.Since(start).Seconds())
+
+	fmt.Println("\nThe LLM is ready for 'basal ask' and 'basal chat'.")
+	return nil
+}
+
+func runLLMEval(cmd *cobra.Command, args []string) error {
+	suitePath, _ := cmd.Flags().GetString("suite")
+	models, _ := cmd.Flags().GetStringSlice("model")
+	retries, _ := cmd.Flags().GetInt("retries")
+	minAccuracy, _ := cmd.Flags().GetFloat64("min-accuracy")
+	var verbose io.Writer
+	if v, _ := cmd.Flags().GetBool("verbose"); v {
+		verbose = cmd.ErrOrStderr()
+	}
+
+	suite, err := loadEvalSuite(suitePath)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := interruptible(cmd.Context())
+	defer stop()
+
+	sandbox, description, err := openEvalFixture(ctx, suite)
+	if err != nil {
+		return err
+	}
+	defer sandbox.Close()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("error getting configuration: %v", err)
+	}
+	providerConfig, err := llmConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if len(models) == 0 {
+		models = []string{cfg.LLM.Model}
+	}
+
+	var rows [][]string
+	var failures []string
+	var belowMin []string
+	for _, model := range models {
+		providerConfig.Model = model
+		provider, err := llm.New(providerConfig)
+		if err != nil {
+			return fmt.Errorf("error getting LLM configuration: %v", err)
+		}
+
+		fmt.Printf("\nEvaluating %s on %d questions\n", model, len(suite.Cases))
+		var correct, errors, retried int
+		var total, slowest time.Duration
+		for i := range suite.Cases {
+			outcome := runEvalCase(ctx, provider, sandbox, description, &suite.Cases[i], retries, verbose)
+			if ctx.Err() != nil {
+				return errInterrupted
+			}
+
+			status := "ok  "
+			switch {
+			case outcome.Err != nil:
+				status = "FAIL"
+				errors++
+				failures = append(failures, fmt.Sprintf("%s: %s\n  error: %v", model, outcome.Case.Question, outcome.Err))
+			case !outcome.Correct:
+				status = "FAIL"
+				failures = append(failures, fmt.Sprintf("%s: %s\n  wrong result from: %s", model, outcome.Case.Question, strings.Join(strings.Fields(outcome.SQL), " ")))
+			default:
+				correct++
+			}
+			if outcome.Attempts > 1 {
+				retried++
+			}
+			total += outcome.Latency
+			if outcome.Latency > slowest {
+				slowest = outcome.Latency
+			}
+			fmt.Printf("  %s %s (%.1fs)\n", status, outcome.Case.Question, outcome.Latency.Seconds())
+		}
+
+		accuracy := 100 * float64(correct) / float64(len(suite.Cases))
+		if accuracy < minAccuracy {
+			belowMin = append(belowMin, fmt.Sprintf("%s (%.0f%%)", model, accuracy))
+		}
+		rows = append(rows, []string{
+			model,
+			fmt.Sprintf("%d/%d", correct, len(suite.Cases)),
+			fmt.Sprintf("%.0f%%", accuracy),
+			fmt.Sprintf("%.1fs", (total / time.Duration(len(suite.Cases))).Seconds()),
+			fmt.Sprintf("%.1fs", slowest.Seconds()),
+			fmt.Sprint(retried),
+			fmt.Sprint(errors),
+		})
+	}
+
+	fmt.Println()
+	renderTable(cmd.OutOrStdout(), []string{"Model", "Correct", "Accuracy", "Avg latency", "Max latency", "Retried", "Errors"}, rows)
+	if len(failures) > 0 {
+		fmt.Printf("\nFailures:\n%s\n", strings.Join(failures, "\n"))
+	}
+
+	if len(belowMin) > 0 {
+		return fmt.Errorf("accuracy below %.0f%%: %s", minAccuracy, strings.Join(belowMin, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"basal/db"
+	"basal/llm"
+	"basal/schedule"
+
+	"github.com/BurntSushi/toml"
+)
+
+// defaultEvalSuite is the suite run by 'basal llm eval' without --suite.
+//
+//go:embed evalsuite.toml
+var defaultEvalSuite string
+
+// evalSuite is a fixture database and the questions asked about it.
+type evalSuite struct {
+	Records []evalRecord `toml:"record"`
+	Cases   []evalCase   `toml:"case"`
+}
+
+type evalRecord struct {
+	Date  string   `toml:"date"`
+	Rates []string `toml:"rates"` // "HH:MM units/hr", each running to the next
+}
+
+type evalCase struct {
+	Question string `toml:"question"`
+	SQL      string `toml:"sql"` // reference query
+
+	expected *db.QueryResult
+}
+
+// evalOutcome is how one model did on one case.
+type evalOutcome struct {
+	Case     *evalCase
+	SQL      string
+	Correct  bool
+	Err      error
+	Attempts int
+	Latency  time.Duration
+}
+
+// loadEvalSuite reads the suite at path, or the built-in one if path is empty.
+func loadEvalSuite(path string) (*evalSuite, error) {
+	content := defaultEvalSuite
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading suite: %v", err)
+		}
+		content = string(data)
+	}
+
+	var suite evalSuite
+	meta, err := toml.Decode(content, &suite)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing suite: %v", err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("error parsing suite: unknown key %s", undecoded[0])
+	}
+	if len(suite.Cases) == 0 {
+		return nil, fmt.Errorf("the suite has no cases")
+	}
+	return &suite, nil
+}
+
+// fixture builds the suite's database in memory.
+func (s *evalSuite) fixture() (db.Store, error) {
+	store := db.NewMemoryStore()
+	for _, r := range s.Records {
+		date, err := time.Parse(db.DateFormat, r.Date)
+		if err != nil {
+			return nil, fmt.Errorf("record %q: invalid date: %v", r.Date, err)
+		}
+		sched, err := parseEvalRates(r.Rates)
+		if err != nil {
+			return nil, fmt.Errorf("record %s: %v", r.Date, err)
+		}
+		if _, err := store.CreateBasalRecord(date, sched); err != nil {
+			return nil, fmt.Errorf("record %s: %v", r.Date, err)
+		}
+	}
+	return store, nil
+}
+
+// parseEvalRates turns "HH:MM units/hr" entries into a schedule in which each
+// segment runs until the next one starts and the last runs to midnight.
+func parseEvalRates(rates []string) (schedule.Schedule, error) {
+	sched := make(schedule.Schedule, len(rates))
+	for i, entry := range rates {
+		start, rate, ok := strings.Cut(strings.TrimSpace(entry), " ")
+		if !ok {
+			return nil, fmt.Errorf("rate %q: want \"HH:MM units/hr\"", entry)
+		}
+		t, err := schedule.ParseTimeOfDay(start)
+		if err != nil {
+			return nil, fmt.Errorf("rate %q: %v", entry, err)
+		}
+		units, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if err != nil {
+			return nil, fmt.Errorf("rate %q: invalid rate", entry)
+		}
+		sched[i] = schedule.Segment{StartTime: t, UnitsPerHour: units}
+		if i > 0 {
+			sched[i-1].EndTime = t
+		}
+	}
+	if len(sched) > 0 {
+		sched[len(sched)-1].EndTime = schedule.Midnight
+	}
+	return sched, sched.Validate()
+}
+
+// midnightDate matches a date stored with a zero time of day, which drivers
+// render in several ways.
+var midnightDate = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})[ T]00:00:00`)
+
+// normalizeValue makes equal values from differently written queries
+// compare equal: numbers are rounded and dates lose a zero time of day.
+func normalizeValue(value string) string {
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return strconv.FormatFloat(math.Round(f*1e4)/1e4, 'f', -1, 64)
+	}
+	if m := midnightDate.FindStringSubmatch(value); m != nil {
+		return m[1]
+	}
+	return value
+}
+
+// resultsMatch reports whether actual answers the question as well as
+// expected: it has the same number of rows, and each expected row's values
+// all appear in a different actual row. Column names, column order, row order
+// and extra columns are ignored.
+func resultsMatch(expected, actual *db.QueryResult) bool {
+	if len(expected.Rows) != len(actual.Rows) {
+		return false
+	}
+
+	used := make([]bool, len(actual.Rows))
+	for _, want := range expected.Rows {
+		found := false
+		for i, got := range actual.Rows {
+			if !used[i] && rowContains(got, want) {
+				used[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// rowContains reports whether every value of want appears in got, counting
+// repeated values.
+func rowContains(got, want []string) bool {
+	counts := make(map[string]int, len(got))
+	for _, v := range got {
+		counts[normalizeValue(v)]++
+	}
+	for _, v := range want {
+		v = normalizeValue(v)
+		if counts[v] == 0 {
+			return false
+		}
+		counts[v]--
+	}
+	return true
+}
+
+// openEvalFixture builds the suite's fixture database, runs the reference
+// query of each case on it and describes its data for the prompt. The caller
+// must close the sandbox.
+func openEvalFixture(ctx context.Context, suite *evalSuite) (*db.Sandbox, string, error) {
+	store, err := suite.fixture()
+	if err != nil {
+		return nil, "", fmt.Errorf("error building fixture database: %v", err)
+	}
+	defer store.Close()
+	sandbox, err := db.OpenSandbox(store)
+	if err != nil {
+		return nil, "", fmt.Errorf("error opening fixture database for queries: %v", err)
+	}
+
+	for i := range suite.Cases {
+		c := &suite.Cases[i]
+		if c.expected, err = sandbox.Query(ctx, c.SQL); err != nil {
+			sandbox.Close()
+			return nil, "", fmt.Errorf("case %q: reference query failed: %v", c.Question, err)
+		}
+	}
+	// The fixture holds no one's data, so the model may see example rows
+	description, err := describeData(ctx, sandbox, dataExampleRows)
+	if err != nil {
+		sandbox.Close()
+		return nil, "", err
+	}
+	return sandbox, description, nil
+}
+
+// runEvalCase asks provider the case's question, with dataDescription
+// describing the fixture, and checks the result.
+func runEvalCase(ctx context.Context, provider llm.Provider, sandbox *db.Sandbox, dataDescription string, c *evalCase, retries int, verbose io.Writer) evalOutcome {
+	outcome := evalOutcome{Case: c}
+	prompt, err := sqlPrompt(c.Question, dataDescription)
+	if err != nil {
+		outcome.Err = err
+		return outcome
+	}
+	conversation := []llm.Message{{Role: "user", Content: prompt}}
+
+	start := time.Now()
+	answer, _, err := generateSQL(ctx, provider, sandbox, conversation, retries, verbose)
+	outcome.Latency = time.Since(start)
+	if err != nil {
+		outcome.Err = err
+		return outcome
+	}
+
+	outcome.SQL = answer.SQL
+	outcome.Attempts = answer.Attempts
+	outcome.Correct = resultsMatch(c.expected, answer.Result)
+	return outcome
+}
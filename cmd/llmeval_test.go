@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"basal/llm"
+)
+
+// fakeOllama starts a scripted Ollama server that answers each question of
+// suite with replies[question] if set, and otherwise with the case's
+// reference query.
+func fakeOllama(t *testing.T, suite *evalSuite, replies map[string]string) llm.Provider {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []llm.Message `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"error": "invalid request"}`, http.StatusBadRequest)
+			return
+		}
+
+		reply := "SELECT 'unknown question'"
+		for _, c := range suite.Cases {
+			if len(req.Messages) > 0 && strings.Contains(req.Messages[0].Content, c.Question) {
+				reply = c.SQL
+				if scripted, ok := replies[c.Question]; ok {
+					reply = scripted
+				}
+				break
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": llm.Message{Role: "assistant", Content: reply},
+			"done":    true,
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	provider, err := llm.New(llm.Config{Provider: llm.ProviderOllama, Endpoint: server.URL, Model: "fake"})
+	if err != nil {
+		t.Fatalf("llm.New: %v", err)
+	}
+	return provider
+}
+
+// runBuiltinSuite runs the built-in suite against the fake server and
+// returns the outcome of each case.
+func runBuiltinSuite(t *testing.T, replies map[string]string) []evalOutcome {
+	t.Helper()
+	// Keep the user's prompt overrides out of the run
+	configFlag = filepath.Join(t.TempDir(), "config.toml")
+	t.Cleanup(func() { configFlag = "" })
+
+	suite, err := loadEvalSuite("")
+	if err != nil {
+		t.Fatalf("loadEvalSuite: %v", err)
+	}
+	ctx := context.Background()
+	sandbox, description, err := openEvalFixture(ctx, suite)
+	if err != nil {
+		t.Fatalf("openEvalFixture: %v", err)
+	}
+	defer sandbox.Close()
+
+	provider := fakeOllama(t, suite, replies)
+	var outcomes []evalOutcome
+	for i := range suite.Cases {
+		outcomes = append(outcomes, runEvalCase(ctx, provider, sandbox, description, &suite.Cases[i], 0, nil))
+	}
+	return outcomes
+}
+
+func TestBuiltinEvalSuite(t *testing.T) {
+	outcomes := runBuiltinSuite(t, nil)
+	if len(outcomes) != 12 {
+		t.Errorf("built-in suite has %d cases, want 12", len(outcomes))
+	}
+	correct := 0
+	for _, o := range outcomes {
+		if o.Correct {
+			correct++
+			continue
+		}
+		t.Errorf("%q: incorrect (SQL %q, err %v)", o.Case.Question, o.SQL, o.Err)
+	}
+	if correct != len(outcomes) {
+		t.Errorf("%d/%d correct", correct, len(outcomes))
+	}
+}
+
+func TestEvalMarksWrongAnswers(t *testing.T) {
+	suite, err := loadEvalSuite("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := suite.Cases[0].Question, suite.Cases[1].Question
+	outcomes := runBuiltinSuite(t, map[string]string{
+		first:  "SELECT 'wrong answer'",
+		second: "DELETE FROM basal_records",
+	})
+
+	for _, o := range outcomes {
+		switch o.Case.Question {
+		case first:
+			if o.Correct || o.Err != nil {
+				t.Errorf("wrong result: correct %v, err %v; want incorrect without error", o.Correct, o.Err)
+			}
+		case second:
+			if o.Correct || o.Err == nil {
+				t.Errorf("rejected query: correct %v, err %v; want an error", o.Correct, o.Err)
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"basal/config"
+	"basal/db"
+	"basal/llm"
+)
+
+// llmConfig returns the settings for the model configured in cfg. An
+// endpoint on another machine is refused unless privacy.allow_remote is set,
+// and every request sent to one is recorded in the audit log.
+func llmConfig(cfg *config.Config) (llm.Config, error) {
+	pc := cfg.LLM.ProviderConfig()
+	if llm.IsLocal(pc.Endpoint) {
+		return pc, nil
+	}
+	if !cfg.Privacy.AllowRemote {
+		return pc, fmt.Errorf("llm.endpoint %s is not on this machine, so your questions and basal data would be sent to it.\nIf that is what you want, allow it with 'basal config set privacy.allow_remote true'", pc.Endpoint)
+	}
+
+	path, err := cfg.Privacy.AuditLogPath()
+	if err != nil {
+		return pc, err
+	}
+	pc.Audit = auditLog(path)
+	return pc, nil
+}
+
+// auditLog appends to the file at its path, which is opened for each write
+// so that nothing is held open between requests.
+type auditLog string
+
+func (a auditLog) Write(p []byte) (int, error) {
+	path := string(a)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.Write(p)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// privacyPolicy is what the LLM may see of query results.
+type privacyPolicy struct {
+	Results string // config.ResultsRows or config.ResultsAggregates
+	MaxRows int
+}
+
+func newPrivacyPolicy(cfg *config.Config) privacyPolicy {
+	return privacyPolicy{Results: cfg.Privacy.Results, MaxRows: cfg.Privacy.MaxRows}
+}
+
+// share returns the text sent to the LLM in place of result, showing at most
+// maxRows rows, and the values it contains.
+func (p privacyPolicy) share(result *db.QueryResult, maxRows int) (string, *db.QueryResult) {
+	if p.Results == config.ResultsAggregates {
+		summary := summarizeResult(result)
+		var buf strings.Builder
+		fmt.Fprintf(&buf, "Summary of the %d result rows (the rows themselves are private):\n", len(result.Rows))
+		renderTable(&buf, summary.Columns, summary.Rows)
+		return buf.String(), summary
+	}
+
+	if p.MaxRows > 0 && p.MaxRows < maxRows {
+		maxRows = p.MaxRows
+	}
+	shown := &db.QueryResult{Columns: result.Columns, Rows: result.Rows}
+	if len(shown.Rows) > maxRows {
+		shown.Rows = shown.Rows[:maxRows]
+	}
+	return resultText(result, maxRows), shown
+}
+
+// exampleRows returns how many of n example rows the LLM may see: none when
+// only aggregates are shared, and at most MaxRows otherwise.
+func (p privacyPolicy) exampleRows(n int) int {
+	if p.Results == config.ResultsAggregates {
+		return 0
+	}
+	if p.MaxRows > 0 && p.MaxRows < n {
+		return p.MaxRows
+	}
+	return n
+}
+
+// summarizeResult describes each column of result without its rows: how
+// many values it has and, for numbers, their range, mean and sum. Dates are
+// only given to the month.
+func summarizeResult(result *db.QueryResult) *db.QueryResult {
+	summary := &db.QueryResult{Columns: []string{"column", "values", "min", "max", "mean", "sum"}}
+	for j, column := range result.Columns {
+		var values []string
+		for _, row := range result.Rows {
+			if v := strings.TrimSpace(row[j]); v != "" {
+				values = append(values, v)
+			}
+		}
+		stats := []string{column, strconv.Itoa(len(values)), "", "", "", ""}
+
+		if numbers, ok := parseNumbers(values); ok {
+			low, high, sum := numbers[0], numbers[0], 0.0
+			for _, n := range numbers {
+				low = math.Min(low, n)
+				high = math.Max(high, n)
+				sum += n
+			}
+			stats[2], stats[3] = formatStat(low), formatStat(high)
+			stats[4], stats[5] = formatStat(sum/float64(len(numbers))), formatStat(sum)
+		} else if months, ok := parseMonths(values); ok {
+			stats[2], stats[3] = slices.Min(months), slices.Max(months)
+		}
+		summary.Rows = append(summary.Rows, stats)
+	}
+	return summary
+}
+
+// parseNumbers parses values if they are all numbers and there is at least one.
+func parseNumbers(values []string) ([]float64, bool) {
+	numbers := make([]float64, len(values))
+	for i, v := range values {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, false
+		}
+		numbers[i] = n
+	}
+	return numbers, len(numbers) > 0
+}
+
+// parseMonths returns the YYYY-MM month of values if they are all dates and
+// there is at least one.
+func parseMonths(values []string) ([]string, bool) {
+	months := make([]string, len(values))
+	for i, v := range values {
+		m := isoDatePrefix.FindStringSubmatch(v)
+		if m == nil {
+			return nil, false
+		}
+		months[i] = m[1] + "-" + m[2]
+	}
+	return months, len(months) > 0
+}
+
+// formatStat rounds a statistic to four decimal places.
+func formatStat(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1e4)/1e4, 'f', -1, 64)
+}
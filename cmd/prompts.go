@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"basal/db"
+
+	"github.com/BurntSushi/toml"
+)
+
+// defaultPrompts holds the built-in prompt templates and few-shot examples.
+//
+//go:embed prompts
+var defaultPrompts embed.FS
+
+// Prompt files. A file of the same name in the prompts directory replaces
+// the built-in template; user examples are added to the built-in ones.
+const (
+	promptSQL       = "sql.tmpl"
+	promptRepair    = "repair.tmpl"
+	promptInterpret = "interpret.tmpl"
+	promptExplain   = "explain.tmpl"
+	promptExamples  = "examples.toml"
+)
+
+// promptFiles lists every prompt file, in the order 'config prompts' writes them.
+var promptFiles = []string{promptSQL, promptRepair, promptInterpret, promptExplain, promptExamples}
+
+// userExamples starts the user's examples file written by 'config prompts';
+// the built-in examples are used either way.
+const userExamples = `# Few-shot examples added to the built-in ones, e.g.
+#
+# [[example]]
+# question = "how many times did my basal change"
+# sql = "SELECT COUNT(DISTINCT date) - 1 FROM basal_records"
+`
+
+// insulinUnits is how prompts refer to amounts of insulin.
+const insulinUnits = "units"
+
+// dataExampleRows is how many example rows describeData shows the model.
+const dataExampleRows = 4
+
+// promptExample is a few-shot example for the SQL prompt.
+type promptExample struct {
+	Question string `toml:"question"`
+	SQL      string `toml:"sql"`
+}
+
+// promptData holds the variables available to prompt templates.
+type promptData struct {
+	Schema   string
+	Rules    string // what the schema means, see db.SchemaRules
+	Data     string // value ranges and example rows, see describeData
+	Today    string // YYYY-MM-DD
+	Units    string
+	Question string
+	Examples []promptExample
+	Error    string // why the last query failed, for the repair prompt
+	Results  string // the result table, for the interpretation prompt
+	SQL      string // the query to explain, for the explanation prompt
+}
+
+// promptDir returns the directory of prompt overrides, next to the config file.
+func promptDir() (string, error) {
+	path, err := getConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), "prompts"), nil
+}
+
+// readPromptFile returns the user's version of the named prompt file if
+// there is one, and otherwise the built-in one.
+func readPromptFile(name string) (content, path string, err error) {
+	dir, err := promptDir()
+	if err != nil {
+		return "", "", err
+	}
+	path = filepath.Join(dir, name)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return string(data), path, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", "", fmt.Errorf("error reading prompt: %v", err)
+	}
+
+	data, err = defaultPrompts.ReadFile("prompts/" + name)
+	if err != nil {
+		return "", "", err
+	}
+	return string(data), "built-in " + name, nil
+}
+
+// loadPromptExamples returns the built-in few-shot examples followed by the user's.
+func loadPromptExamples() ([]promptExample, error) {
+	builtin, err := defaultPrompts.ReadFile("prompts/" + promptExamples)
+	if err != nil {
+		return nil, err
+	}
+	examples, err := parseExamples(string(builtin), "built-in "+promptExamples)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := promptDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, promptExamples)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return examples, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading examples: %v", err)
+	}
+	extra, err := parseExamples(string(content), path)
+	if err != nil {
+		return nil, err
+	}
+	return append(examples, extra...), nil
+}
+
+// parseExamples reads [[example]] tables from an examples file.
+func parseExamples(content, path string) ([]promptExample, error) {
+	var file struct {
+		Examples []promptExample `toml:"example"`
+	}
+	meta, err := toml.Decode(content, &file)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("error parsing %s: unknown key %s", path, undecoded[0])
+	}
+	return file.Examples, nil
+}
+
+// newPromptData returns the template variables for question.
+func newPromptData(question string) (promptData, error) {
+	examples, err := loadPromptExamples()
+	if err != nil {
+		return promptData{}, err
+	}
+	return promptData{
+		Schema:   db.GetSchema(),
+		Rules:    db.SchemaRules(),
+		Today:    time.Now().Format(db.DateFormat),
+		Units:    insulinUnits,
+		Question: question,
+		Examples: examples,
+	}, nil
+}
+
+// describeData tells the model what the sandboxed data looks like: how many
+// records there are, the range of their dates and values, and up to
+// exampleRows rows of the effective_schedule view.
+func describeData(ctx context.Context, sandbox *db.Sandbox, exampleRows int) (string, error) {
+	p, err := sandbox.Profile(ctx, exampleRows)
+	if err != nil {
+		return "", fmt.Errorf("error describing the data: %v", err)
+	}
+	if p.Records == 0 {
+		return "The database has no basal records yet.", nil
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "The database has %d basal records, dated %s to %s.\n", p.Records, p.FirstDate, p.LastDate)
+	if p.MinIntervals == p.MaxIntervals {
+		fmt.Fprintf(&buf, "Each record has %d intervals.\n", p.MinIntervals)
+	} else {
+		fmt.Fprintf(&buf, "Each record has %d to %d intervals.\n", p.MinIntervals, p.MaxIntervals)
+	}
+	fmt.Fprintf(&buf, "total_units ranges from %s to %s and units_per_hour from %s to %s.\n",
+		formatStat(p.MinTotalUnits), formatStat(p.MaxTotalUnits), formatStat(p.MinRate), formatStat(p.MaxRate))
+	if p.Examples != nil && len(p.Examples.Rows) > 0 {
+		// Written out plainly, since renderTable would change the column names
+		buf.WriteString("Example rows of effective_schedule, latest first:\n")
+		buf.WriteString(strings.Join(p.Examples.Columns, " | ") + "\n")
+		for _, row := range p.Examples.Rows {
+			buf.WriteString(strings.Join(row, " | ") + "\n")
+		}
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// renderPrompt fills in the named prompt template with data.
+func renderPrompt(name string, data promptData) (string, error) {
+	content, path, err := readPromptFile(name)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return "", fmt.Errorf("error in prompt template %s: %v", path, err)
+	}
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("error in prompt template %s: %v", path, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// writeDefaultPrompts copies the built-in prompt files into dir for editing,
+// keeping any that already exist there. It returns the files it wrote.
+func writeDefaultPrompts(dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating prompts directory: %v", err)
+	}
+
+	var written []string
+	for _, name := range promptFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return written, err
+		}
+
+		content := []byte(userExamples)
+		if name != promptExamples {
+			var err error
+			if content, err = defaultPrompts.ReadFile("prompts/" + name); err != nil {
+				return written, err
+			}
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return written, fmt.Errorf("error writing %s: %v", path, err)
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinPromptsRender(t *testing.T) {
+	// Keep the user's prompt overrides out of the test
+	configFlag = filepath.Join(t.TempDir(), "config.toml")
+	t.Cleanup(func() { configFlag = "" })
+
+	data, err := newPromptData("what was my total on 2024-01-05")
+	if err != nil {
+		t.Fatalf("newPromptData: %v", err)
+	}
+	data.Data = "The database has 2 basal records."
+	data.Error = "no such column: rate"
+	data.Results = "total_units\n22.8"
+	data.SQL = "SELECT total_units FROM daily_basal WHERE date = '2024-01-05'"
+
+	for _, name := range promptFiles {
+		if name == promptExamples {
+			continue
+		}
+		prompt, err := renderPrompt(name, data)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if strings.Contains(prompt, "{{") {
+			t.Errorf("%s: unrendered template text in %q", name, prompt)
+		}
+	}
+
+	explain, err := renderPrompt(promptExplain, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{data.Question, data.SQL, "CREATE TABLE IF NOT EXISTS basal_records"} {
+		if !strings.Contains(explain, want) {
+			t.Errorf("explain prompt is missing %q", want)
+		}
+	}
+}
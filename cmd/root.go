@@ -5,8 +5,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"basal/config"
+	"basal/db"
+
+	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -17,12 +23,21 @@ var rootCmd = &cobra.Command{
 It stores data in SQLite and provides various commands for updating and querying your basal rates.`,
 }
 
+// Global flags that override the config file
+var (
+	configFlag string
+	dbFlag     string
+)
+
 func init() {
 	// Disable the built-in help command since we have our own
 	rootCmd.SetHelpCommand(&cobra.Command{
 		Use:    "no-help",
 		Hidden: true,
 	})
+
+	rootCmd.PersistentFlags().StringVar(&configFlag, "config", "", "Config file to use (default is config.toml in the user config directory)")
+	rootCmd.PersistentFlags().StringVar(&dbFlag, "db", "", "Database path to use instead of the configured one")
 }
 
 func Execute() {
@@ -32,44 +47,196 @@ func Execute() {
 	}
 }
 
-func getConfigDir() (string, error) {
-	configDir, err := os.UserConfigDir()
+// getConfigPath returns the config file in use: --config, then BASAL_CONFIG,
+// then the default location.
+func getConfigPath() (string, error) {
+	if configFlag != "" {
+		return cleanPath(configFlag)
+	}
+	return config.DefaultPath()
+}
+
+// loadFileConfig reads the config file without environment or flag
+// overrides, for commands that change it. Settings from older versions of
+// basal are migrated into the default config file on first use.
+func loadFileConfig() (*config.Config, string, error) {
+	path, err := getConfigPath()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if defaultPath, err := config.DefaultPath(); err == nil && path == defaultPath {
+		dir, err := config.DefaultDir()
+		if err != nil {
+			return nil, "", err
+		}
+		if migrated, err := config.MigrateLegacy(dir, path); err != nil {
+			return nil, "", fmt.Errorf("migrating old configuration: %w", err)
+		} else if migrated {
+			fmt.Fprintf(os.Stderr, "Migrated old configuration files to %s\n", path)
+		}
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return cfg, path, nil
+}
+
+// loadConfig returns the effective configuration: the config file, overridden
+// by BASAL_* environment variables, overridden by global flags.
+func loadConfig() (*config.Config, error) {
+	cfg, _, err := loadFileConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if dbFlag != "" {
+		if err := cfg.Override("database.path", dbFlag, "flag --db"); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Database.Path != "" {
+		if cfg.Database.Path, err = cleanPath(cfg.Database.Path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// updateFileConfig applies change to the config file and saves it.
+func updateFileConfig(change func(cfg *config.Config) error) error {
+	cfg, path, err := loadFileConfig()
 	if err != nil {
-		return "", fmt.Errorf("getting user config directory: %w", err)
+		return err
 	}
-	return filepath.Join(configDir, "basal"), nil
+	if err := change(cfg); err != nil {
+		return err
+	}
+	return cfg.Save(path)
 }
 
+// getDBPath returns the configured database path. On first run it offers the
+// default location when stdin is a terminal, and fails otherwise.
 func getDBPath() (string, error) {
-	configDir, err := getConfigDir()
+	cfg, err := loadConfig()
 	if err != nil {
 		return "", err
 	}
 
-	configFile := filepath.Join(configDir, "config.txt")
+	// Use the configured path if there is one
+	if cfg.Database.Path != "" {
+		return cfg.Database.Path, nil
+	}
 
-	// Try to read existing path
-	if content, err := os.ReadFile(configFile); err == nil {
-		return string(content), nil
+	if err := requireTerminal(fmt.Sprintf("no database is configured; run 'basal init --yes' to use the default location, or set --db or %s", config.EnvDB)); err != nil {
+		return "", err
 	}
 
-	// Get path from user
-	fmt.Print("Enter the full path where you want to store the database: ")
-	var dbPath string
-	fmt.Scanln(&dbPath)
+	defaultPath, err := config.DefaultDBPath(cfg.Database.Backend)
+	if err != nil {
+		return "", err
+	}
+
+	pathPrompt := promptui.Prompt{
+		Label:   "Database path",
+		Default: defaultPath,
+		Validate: func(input string) error {
+			if strings.TrimSpace(input) == "" {
+				return fmt.Errorf("path cannot be empty")
+			}
+			return nil
+		},
+	}
+	dbPath, err := pathPrompt.Run()
+	if err != nil {
+		return "", fmt.Errorf("path prompt failed: %v", err)
+	}
+	if dbPath, err = cleanPath(dbPath); err != nil {
+		return "", err
+	}
 
 	// Ensure directories exist
 	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
 		return "", fmt.Errorf("creating database directory: %w", err)
 	}
 
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return "", fmt.Errorf("creating config directory: %w", err)
+	if err := saveDBPath(dbPath); err != nil {
+		return "", err
 	}
 
-	if err := os.WriteFile(configFile, []byte(dbPath), 0644); err != nil {
-		return "", fmt.Errorf("saving database path: %w", err)
+	return dbPath, nil
+}
+
+// stdinIsTerminal reports whether stdin is an interactive terminal.
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// requireTerminal returns an error built from hint when stdin is not a
+// terminal, so that commands fail fast instead of waiting on a prompt.
+func requireTerminal(hint string) error {
+	if stdinIsTerminal() {
+		return nil
 	}
+	return fmt.Errorf("stdin is not a terminal: %s", hint)
+}
 
-	return dbPath, nil
+// saveDBPath records the database location in the config file.
+func saveDBPath(dbPath string) error {
+	err := updateFileConfig(func(cfg *config.Config) error {
+		cfg.Database.Path = dbPath
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("saving database path: %w", err)
+	}
+	return nil
+}
+
+// getStorageLocation returns the configured storage backend and database path.
+func getStorageLocation() (backend, dbPath string, err error) {
+	dbPath, err = getDBPath()
+	if err != nil {
+		return "", "", fmt.Errorf("error getting database path: %v", err)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", "", fmt.Errorf("error getting storage configuration: %v", err)
+	}
+	return cfg.Database.Backend, dbPath, nil
+}
+
+// openStore opens the configured storage backend at the configured database
+// path, unlocking it first if it is encrypted.
+func openStore() (db.Store, error) {
+	dbPath, err := getDBPath()
+	if err != nil {
+		return nil, fmt.Errorf("error getting database path: %v", err)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error getting storage configuration: %v", err)
+	}
+
+	_, statErr := os.Stat(dbPath)
+	store, err := db.OpenStore(db.StoreConfig{
+		Backend: cfg.Database.Backend,
+		Path:    dbPath,
+		Secret:  storeSecret(cfg.Database.KeyFile, os.IsNotExist(statErr)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error initializing database: %v", err)
+	}
+	return store, nil
 }
@@ -2,8 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
 	"time"
 
 	"basal/db"
@@ -28,43 +26,6 @@ func init() {
 	rootCmd.AddCommand(showCmd)
 }
 
-// timeToMinutes converts HH:MM format to minutes since midnight
-func timeToMinutes(timeStr string) int {
-	parts := strings.Split(timeStr, ":")
-	hours, _ := strconv.Atoi(parts[0])
-	minutes, _ := strconv.Atoi(parts[1])
-	return hours*60 + minutes
-}
-
-// minutesToTime converts minutes since midnight to HH:MM format
-func minutesToTime(minutes int) string {
-	hours := minutes / 60
-	mins := minutes % 60
-	return fmt.Sprintf("%02d:%02d", hours, mins)
-}
-
-// generateGraphData creates data points for the line chart
-func generateGraphData(intervals []db.BasalInterval) []float64 {
-	// Create a slice for all 24 hours (1440 minutes)
-	data := make([]float64, 1440)
-
-	// Fill in the basal rates for each interval
-	for _, interval := range intervals {
-		start := timeToMinutes(interval.StartTime)
-		end := timeToMinutes(interval.EndTime)
-		if end == 0 {
-			end = 1440 // Handle midnight wrap-around
-		}
-
-		// Fill in the rate for each minute in the interval
-		for i := start; i < end; i++ {
-			data[i] = interval.UnitsPerHour
-		}
-	}
-
-	return data
-}
-
 func runShow(cmd *cobra.Command, args []string) error {
 	var date time.Time
 	var err error
@@ -78,17 +39,13 @@ func runShow(cmd *cobra.Command, args []string) error {
 		date = time.Now()
 	}
 
-	dbPath, err := getDBPath()
-	if err != nil {
-		return fmt.Errorf("error getting database path: %v", err)
-	}
-	database, err := db.InitDB(dbPath)
+	store, err := openStore()
 	if err != nil {
-		return fmt.Errorf("error initializing database: %v", err)
+		return err
 	}
-	defer database.Close()
+	defer store.Close()
 
-	record, intervals, err := db.GetBasalRecordByDate(database, date)
+	record, intervals, err := store.GetBasalRecordByDate(date)
 	if err != nil {
 		return fmt.Errorf("error retrieving basal record: %v", err)
 	}
@@ -121,7 +78,7 @@ func runShow(cmd *cobra.Command, args []string) error {
 	fmt.Printf("\nDaily basal: %.2f units\n", record.TotalUnits)
 
 	// Generate and display the graph
-	graphData := generateGraphData(intervals)
+	graphData := intervals.MinuteGrid()
 
 	// Find min and max values for better y-axis scaling
 	minVal, maxVal := 0.0, 0.0
@@ -0,0 +1,321 @@
+// Package config loads and saves basal's settings. All settings live in one
+// TOML file; environment variables and command-line flags can override them.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"basal/db"
+	"basal/llm"
+
+	"github.com/BurntSushi/toml"
+)
+
+// FileName is the name of the config file inside the config directory.
+const FileName = "config.toml"
+
+// Environment variables that override the config file.
+const (
+	EnvConfig      = "BASAL_CONFIG"
+	EnvDB          = "BASAL_DB"
+	EnvDBBackend   = "BASAL_DB_BACKEND"
+	EnvDBKeyFile   = "BASAL_DB_KEY_FILE"
+	EnvLLMProvider = "BASAL_LLM_PROVIDER"
+	EnvLLMEndpoint = "BASAL_LLM_ENDPOINT"
+	EnvLLMModel    = "BASAL_LLM_MODEL"
+	EnvLLMAPIKey   = "BASAL_LLM_API_KEY"
+	EnvLLMTimeout  = "BASAL_LLM_TIMEOUT"
+	EnvLLMHeaders  = "BASAL_LLM_HEADERS"
+
+	EnvPrivacyAllowRemote = "BASAL_PRIVACY_ALLOW_REMOTE"
+	EnvPrivacyResults     = "BASAL_PRIVACY_RESULTS"
+	EnvPrivacyMaxRows     = "BASAL_PRIVACY_MAX_ROWS"
+	EnvPrivacyAuditLog    = "BASAL_PRIVACY_AUDIT_LOG"
+)
+
+// Default values for settings that are not configured.
+const (
+	DefaultBackend     = db.BackendSQLite
+	DefaultLLMProvider = llm.ProviderOllama
+	DefaultLLMEndpoint = "http://localhost:11434"
+	DefaultLLMModel    = "llama3.2:latest"
+	DefaultLLMTimeout  = "2m"
+
+	DefaultPrivacyMaxRows = 50
+)
+
+// What query results the LLM is shown, set by privacy.results.
+const (
+	ResultsRows       = "rows"       // the rows themselves, up to privacy.max_rows
+	ResultsAggregates = "aggregates" // per-column counts, ranges, means and sums
+)
+
+// ResultModes lists the accepted values of privacy.results.
+var ResultModes = []string{ResultsRows, ResultsAggregates}
+
+// Config is the schema of the config file.
+type Config struct {
+	Database Database `toml:"database"`
+	LLM      LLM      `toml:"llm"`
+	Privacy  Privacy  `toml:"privacy"`
+
+	sources map[string]string // where each non-default value came from, by key
+}
+
+// Database holds the storage settings.
+type Database struct {
+	Path    string `toml:"path"`
+	Backend string `toml:"backend"`
+	KeyFile string `toml:"key_file,omitempty"` // Key file for the encrypted backend; a passphrase is used if empty
+}
+
+// LLM holds the settings for natural language queries.
+type LLM struct {
+	Provider string            `toml:"provider"` // ollama or openai
+	Endpoint string            `toml:"endpoint"`
+	Model    string            `toml:"model"`
+	APIKey   string            `toml:"api_key,omitempty"`
+	Timeout  string            `toml:"timeout"`           // How long to wait for a reply to start, e.g. "90s"; "0" waits forever
+	Headers  map[string]string `toml:"headers,omitempty"` // Extra HTTP headers sent with every request
+}
+
+// Privacy limits what is sent to the LLM.
+type Privacy struct {
+	AllowRemote bool   `toml:"allow_remote"`        // allow endpoints on other machines
+	Results     string `toml:"results"`             // rows or aggregates
+	MaxRows     int    `toml:"max_rows"`            // most result rows sent
+	AuditLog    string `toml:"audit_log,omitempty"` // log of requests to remote endpoints; in the data directory if empty
+}
+
+// AuditLogPath returns where requests to remote endpoints are logged.
+func (p *Privacy) AuditLogPath() (string, error) {
+	if p.AuditLog != "" {
+		return p.AuditLog, nil
+	}
+	dir, err := DefaultDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "llm-audit.log"), nil
+}
+
+// ProviderConfig returns the settings needed to create an llm.Provider.
+func (l *LLM) ProviderConfig() llm.Config {
+	// Validate has checked the timeout parses
+	timeout, _ := time.ParseDuration(l.Timeout)
+	return llm.Config{
+		Provider: l.Provider,
+		Endpoint: l.Endpoint,
+		Model:    l.Model,
+		APIKey:   l.APIKey,
+		Headers:  l.Headers,
+		Timeout:  timeout,
+	}
+}
+
+// Default returns a config with every setting at its default value.
+func Default() *Config {
+	return &Config{
+		Database: Database{Backend: DefaultBackend},
+		LLM: LLM{
+			Provider: DefaultLLMProvider,
+			Endpoint: DefaultLLMEndpoint,
+			Model:    DefaultLLMModel,
+			Timeout:  DefaultLLMTimeout,
+		},
+		Privacy: Privacy{
+			AllowRemote: false,
+			Results:     ResultsRows,
+			MaxRows:     DefaultPrivacyMaxRows,
+		},
+	}
+}
+
+// DefaultDir returns the directory holding basal's config file.
+func DefaultDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("getting user config directory: %w", err)
+	}
+	return filepath.Join(configDir, "basal"), nil
+}
+
+// DefaultDataDir returns the directory where basal keeps its data by default:
+// $XDG_DATA_HOME/basal or ~/.local/share/basal on Unix, the Application
+// Support directory on macOS, and %LocalAppData%\basal on Windows.
+func DefaultDataDir() (string, error) {
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return filepath.Join(dir, "basal"), nil
+		}
+		return DefaultDir()
+	case "darwin", "ios":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("getting home directory: %w", err)
+		}
+		return filepath.Join(home, "Library", "Application Support", "basal"), nil
+	}
+
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "basal"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("getting home directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "share", "basal"), nil
+}
+
+// DefaultDBPath returns the default database location for backend.
+func DefaultDBPath(backend string) (string, error) {
+	dir, err := DefaultDataDir()
+	if err != nil {
+		return "", err
+	}
+
+	name := "basal.db"
+	switch backend {
+	case db.BackendJSON:
+		name = "basal.json"
+	case db.BackendEncrypted:
+		name = "basal.enc"
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// DefaultPath returns the path of the config file, honouring BASAL_CONFIG.
+func DefaultPath() (string, error) {
+	if path := os.Getenv(EnvConfig); path != "" {
+		return path, nil
+	}
+	dir, err := DefaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, FileName), nil
+}
+
+// Load reads the config file at path. Settings missing from the file keep
+// their defaults, and a missing file yields the defaults. Unknown keys are
+// rejected so that typos do not go unnoticed.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	meta, err := toml.Decode(string(content), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return nil, fmt.Errorf("%s: unknown settings: %s", path, strings.Join(keys, ", "))
+	}
+	for _, key := range meta.Keys() {
+		if len(key) == 2 {
+			cfg.setSource(key.String(), SourceFile)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Save writes the config to path, creating its directory if needed.
+func (c *Config) Save(path string) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# basal configuration. See 'basal help' for the available settings.\n\n")
+	if err := toml.NewEncoder(&buf).Encode(c); err != nil {
+		return fmt.Errorf("encoding config: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating config directory: %w", err)
+	}
+	// Keep the file private once it holds an API key or headers.
+	perm := os.FileMode(0644)
+	if c.LLM.APIKey != "" || len(c.LLM.Headers) > 0 {
+		perm = 0600
+	}
+	if err := os.WriteFile(path, buf.Bytes(), perm); err != nil {
+		return fmt.Errorf("writing config file: %w", err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		return fmt.Errorf("setting config file permissions: %w", err)
+	}
+	return nil
+}
+
+// Validate checks that every setting has an acceptable value.
+func (c *Config) Validate() error {
+	if !slices.Contains(db.Backends, c.Database.Backend) {
+		return fmt.Errorf("database.backend: unknown backend %q (want one of %v)", c.Database.Backend, db.Backends)
+	}
+	if c.Database.KeyFile != "" && c.Database.Backend != db.BackendEncrypted {
+		return fmt.Errorf("database.key_file is only used by the %s backend", db.BackendEncrypted)
+	}
+
+	if !slices.Contains(llm.Providers, c.LLM.Provider) {
+		return fmt.Errorf("llm.provider: unknown provider %q (want one of %v)", c.LLM.Provider, llm.Providers)
+	}
+	u, err := url.Parse(c.LLM.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("llm.endpoint: %q is not an http(s) URL", c.LLM.Endpoint)
+	}
+	if strings.TrimSpace(c.LLM.Model) == "" {
+		return fmt.Errorf("llm.model cannot be empty")
+	}
+	if timeout, err := time.ParseDuration(c.LLM.Timeout); err != nil || timeout < 0 {
+		return fmt.Errorf("llm.timeout: %q is not a duration such as 90s or 2m", c.LLM.Timeout)
+	}
+	for name := range c.LLM.Headers {
+		if name == "" || strings.ContainsAny(name, " \t:\r\n") {
+			return fmt.Errorf("llm.headers: invalid header name %q", name)
+		}
+	}
+
+	if !slices.Contains(ResultModes, c.Privacy.Results) {
+		return fmt.Errorf("privacy.results: unknown mode %q (want one of %v)", c.Privacy.Results, ResultModes)
+	}
+	if c.Privacy.MaxRows < 1 {
+		return fmt.Errorf("privacy.max_rows: %d is not a positive number", c.Privacy.MaxRows)
+	}
+	return nil
+}
+
+// ApplyEnv overrides settings with any BASAL_* environment variables that are set.
+func (c *Config) ApplyEnv() error {
+	for _, s := range Settings {
+		if value, ok := os.LookupEnv(s.Env); ok && value != "" {
+			if err := c.Override(s.Key, value, "env "+s.Env); err != nil {
+				return fmt.Errorf("%s: %w", s.Env, err)
+			}
+		}
+	}
+	return nil
+}
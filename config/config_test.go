@@ -0,0 +1,102 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrivacySettings(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `
+[privacy]
+  allow_remote = true
+  results = "aggregates"
+  max_rows = 20
+`))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !cfg.Privacy.AllowRemote || cfg.Privacy.Results != ResultsAggregates || cfg.Privacy.MaxRows != 20 {
+		t.Errorf("privacy = %+v", cfg.Privacy)
+	}
+	if source := cfg.Source("privacy.max_rows"); source != SourceFile {
+		t.Errorf("max_rows source = %q, want %q", source, SourceFile)
+	}
+}
+
+func TestLoadRejectsBadPrivacySettings(t *testing.T) {
+	for _, content := range []string{
+		"[privacy]\nallow_remote = \"yes\"",
+		"[privacy]\nmax_rows = 0",
+		"[privacy]\nresults = \"everything\"",
+	} {
+		if _, err := Load(writeConfig(t, content)); err == nil {
+			t.Errorf("Load accepted %q", content)
+		}
+	}
+}
+
+func TestSaveWritesTypedValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	cfg := Default()
+	for key, value := range map[string]string{"privacy.allow_remote": "true", "privacy.max_rows": "20"} {
+		if err := cfg.Set(key, value); err != nil {
+			t.Fatalf("Set %s: %v", key, err)
+		}
+	}
+	if err := cfg.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"allow_remote = true", "max_rows = 20"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("saved config lacks %q:\n%s", want, content)
+		}
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got, _ := loaded.Get("privacy.allow_remote"); got != "true" {
+		t.Errorf("allow_remote = %q, want true", got)
+	}
+	if got, _ := loaded.Get("privacy.max_rows"); got != "20" {
+		t.Errorf("max_rows = %q, want 20", got)
+	}
+}
+
+func TestSetChecksTypedValues(t *testing.T) {
+	cfg := Default()
+	if err := cfg.Set("privacy.allow_remote", "maybe"); err == nil {
+		t.Error("Set accepted allow_remote = maybe")
+	}
+	if err := cfg.Set("privacy.max_rows", "lots"); err == nil {
+		t.Error("Set accepted max_rows = lots")
+	}
+	if cfg.Privacy.AllowRemote || cfg.Privacy.MaxRows != DefaultPrivacyMaxRows {
+		t.Errorf("failed Set changed the config: %+v", cfg.Privacy)
+	}
+
+	t.Setenv(EnvPrivacyMaxRows, "5")
+	if err := cfg.ApplyEnv(); err != nil {
+		t.Fatalf("ApplyEnv: %v", err)
+	}
+	if cfg.Privacy.MaxRows != 5 {
+		t.Errorf("max_rows = %d, want 5 from the environment", cfg.Privacy.MaxRows)
+	}
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Where a setting's effective value came from.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+)
+
+// Setting describes one key of the config file.
+type Setting struct {
+	Key    string // dotted name, e.g. "llm.model"
+	Env    string // environment variable that overrides it
+	Usage  string
+	Secret bool // hidden by 'basal config show'
+	get    func(c *Config) string
+	set    func(c *Config, value string) error
+}
+
+// stringSetting returns a setting stored in the string field points to.
+func stringSetting(key, env, usage string, secret bool, field func(c *Config) *string) Setting {
+	return Setting{
+		Key: key, Env: env, Usage: usage, Secret: secret,
+		get: func(c *Config) string { return *field(c) },
+		set: func(c *Config, value string) error {
+			*field(c) = value
+			return nil
+		},
+	}
+}
+
+// boolSetting returns a setting stored in the bool field points to.
+func boolSetting(key, env, usage string, field func(c *Config) *bool) Setting {
+	return Setting{
+		Key: key, Env: env, Usage: usage,
+		get: func(c *Config) string { return strconv.FormatBool(*field(c)) },
+		set: func(c *Config, value string) error {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not true or false", key, value)
+			}
+			*field(c) = b
+			return nil
+		},
+	}
+}
+
+// intSetting returns a setting stored in the int field points to.
+func intSetting(key, env, usage string, field func(c *Config) *int) Setting {
+	return Setting{
+		Key: key, Env: env, Usage: usage,
+		get: func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not a number", key, value)
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+// Settings lists every key of the config file, in file order.
+var Settings = []Setting{
+	stringSetting("database.path", EnvDB, "Database location", false, func(c *Config) *string { return &c.Database.Path }),
+	stringSetting("database.backend", EnvDBBackend, "sqlite, json, encrypted or memory", false, func(c *Config) *string { return &c.Database.Backend }),
+	stringSetting("database.key_file", EnvDBKeyFile, "Key file for the encrypted backend (optional)", false, func(c *Config) *string { return &c.Database.KeyFile }),
+	stringSetting("llm.provider", EnvLLMProvider, "ollama or openai (any OpenAI-compatible server)", false, func(c *Config) *string { return &c.LLM.Provider }),
+	stringSetting("llm.endpoint", EnvLLMEndpoint, "LLM API endpoint", false, func(c *Config) *string { return &c.LLM.Endpoint }),
+	stringSetting("llm.model", EnvLLMModel, "LLM model name", false, func(c *Config) *string { return &c.LLM.Model }),
+	stringSetting("llm.api_key", EnvLLMAPIKey, "API key sent as a bearer token (optional)", true, func(c *Config) *string { return &c.LLM.APIKey }),
+	stringSetting("llm.timeout", EnvLLMTimeout, "How long to wait for the model to start replying, e.g. 90s", false, func(c *Config) *string { return &c.LLM.Timeout }),
+	{
+		Key: "llm.headers", Env: EnvLLMHeaders,
+		Usage: "Extra HTTP headers as Name=value,Name=value (values are not shown)",
+		get:   func(c *Config) string { return formatHeaders(c.LLM.Headers) },
+		set: func(c *Config, value string) error {
+			headers, err := parseHeaders(value)
+			if err != nil {
+				return err
+			}
+			c.LLM.Headers = headers
+			return nil
+		},
+	},
+	boolSetting("privacy.allow_remote", EnvPrivacyAllowRemote, "true to allow an LLM endpoint on another machine", func(c *Config) *bool { return &c.Privacy.AllowRemote }),
+	stringSetting("privacy.results", EnvPrivacyResults, "What the LLM sees of query results: rows or aggregates", false, func(c *Config) *string { return &c.Privacy.Results }),
+	intSetting("privacy.max_rows", EnvPrivacyMaxRows, "Most result rows sent to the LLM", func(c *Config) *int { return &c.Privacy.MaxRows }),
+	stringSetting("privacy.audit_log", EnvPrivacyAuditLog, "File logging every request sent to a remote LLM (optional)", false, func(c *Config) *string { return &c.Privacy.AuditLog }),
+}
+
+// formatHeaders lists the header names in order, with their values hidden
+// since they often hold credentials.
+func formatHeaders(headers map[string]string) string {
+	names := slices.Sorted(maps.Keys(headers))
+	for i, name := range names {
+		names[i] = name + "=(hidden)"
+	}
+	return strings.Join(names, ",")
+}
+
+// parseHeaders reads headers written as Name=value,Name=value. An empty
+// value means no headers.
+func parseHeaders(value string) (map[string]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		name, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("llm.headers: %q is not Name=value", strings.TrimSpace(pair))
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(v)
+	}
+	return headers, nil
+}
+
+// LookupSetting returns the setting named key.
+func LookupSetting(key string) (Setting, error) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	for _, s := range Settings {
+		if s.Key == key {
+			return s, nil
+		}
+	}
+
+	keys := make([]string, len(Settings))
+	for i, s := range Settings {
+		keys[i] = s.Key
+	}
+	return Setting{}, fmt.Errorf("unknown setting %q (want one of %s)", key, strings.Join(keys, ", "))
+}
+
+// Get returns the value of the setting named key.
+func (c *Config) Get(key string) (string, error) {
+	s, err := LookupSetting(key)
+	if err != nil {
+		return "", err
+	}
+	return s.get(c), nil
+}
+
+// Set changes the setting named key without validating the result, except
+// that the value must have the setting's form.
+func (c *Config) Set(key, value string) error {
+	return c.Override(key, value, "")
+}
+
+// Override changes the setting named key and records source as where the
+// value came from. An empty source leaves the recorded source unchanged.
+func (c *Config) Override(key, value, source string) error {
+	s, err := LookupSetting(key)
+	if err != nil {
+		return err
+	}
+	if err := s.set(c, value); err != nil {
+		return err
+	}
+	if source != "" {
+		c.setSource(s.Key, source)
+	}
+	return nil
+}
+
+// Source reports where the effective value of key came from: SourceDefault,
+// SourceFile, or a description of the environment variable or flag.
+func (c *Config) Source(key string) string {
+	if source, ok := c.sources[key]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+func (c *Config) setSource(key, source string) {
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+	c.sources[key] = source
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHeadersSetting(t *testing.T) {
+	cfg := Default()
+	if err := cfg.Set("llm.headers", "X-Team=diabetes, X-Proxy-Token = abc=def"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	want := map[string]string{"X-Team": "diabetes", "X-Proxy-Token": "abc=def"}
+	if !reflect.DeepEqual(cfg.LLM.Headers, want) {
+		t.Errorf("headers = %v, want %v", cfg.LLM.Headers, want)
+	}
+
+	got, err := cfg.Get("llm.headers")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got != "X-Proxy-Token=(hidden),X-Team=(hidden)" {
+		t.Errorf("Get = %q, want the names with the values hidden", got)
+	}
+	if strings.Contains(got, "abc") || strings.Contains(got, "diabetes") {
+		t.Errorf("Get revealed a header value: %q", got)
+	}
+
+	if err := cfg.Set("llm.headers", "no-equals-sign"); err == nil {
+		t.Error("Set accepted a header without a value")
+	}
+	if err := cfg.Set("llm.headers", ""); err != nil || cfg.LLM.Headers != nil {
+		t.Errorf("clearing headers: %v, %v", cfg.LLM.Headers, err)
+	}
+}
+
+func TestHeadersFromEnv(t *testing.T) {
+	t.Setenv(EnvLLMHeaders, "X-Team=diabetes")
+	cfg := Default()
+	if err := cfg.ApplyEnv(); err != nil {
+		t.Fatalf("ApplyEnv: %v", err)
+	}
+	if cfg.LLM.Headers["X-Team"] != "diabetes" {
+		t.Errorf("headers = %v", cfg.LLM.Headers)
+	}
+	if source := cfg.Source("llm.headers"); source != "env "+EnvLLMHeaders {
+		t.Errorf("source = %q", source)
+	}
+
+	t.Setenv(EnvLLMHeaders, "garbage")
+	if err := Default().ApplyEnv(); err == nil {
+		t.Error("ApplyEnv accepted malformed headers")
+	}
+}
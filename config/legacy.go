@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Settings files written by earlier versions of basal, before config.toml.
+const (
+	legacyDBFile  = "config.txt" // the database path, nothing else
+	legacyLLMFile = "llm_config" // endpoint=... and model=... lines
+)
+
+// legacySuffix is appended to legacy files once they have been migrated.
+const legacySuffix = ".migrated"
+
+// MigrateLegacy converts the old per-setting files in dir into the config
+// file at path and renames them out of the way. It does nothing if path
+// already exists or there are no old files. It reports whether it migrated.
+//
+// Older versions wrote llm_config to $HOME/.config/basal but read it from the
+// user config directory, which differ on macOS and when XDG_CONFIG_HOME is
+// set. Both locations are checked and the most recently written file wins.
+func MigrateLegacy(dir, path string) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	}
+
+	cfg := Default()
+	var migrated []string
+
+	dbFile := filepath.Join(dir, legacyDBFile)
+	if content, err := os.ReadFile(dbFile); err == nil {
+		cfg.Database.Path = strings.TrimSpace(string(content))
+		migrated = append(migrated, dbFile)
+	}
+
+	llmCandidates := []string{filepath.Join(dir, legacyLLMFile)}
+	if home, err := os.UserHomeDir(); err == nil {
+		if alt := filepath.Join(home, ".config", "basal", legacyLLMFile); alt != llmCandidates[0] {
+			llmCandidates = append(llmCandidates, alt)
+		}
+	}
+	if llmFile := newestFile(llmCandidates); llmFile != "" {
+		values, err := readKeyValueFile(llmFile)
+		if err != nil {
+			return false, fmt.Errorf("reading %s: %w", llmFile, err)
+		}
+		if v := values["endpoint"]; v != "" {
+			cfg.LLM.Endpoint = v
+		}
+		if v := values["model"]; v != "" {
+			cfg.LLM.Model = v
+		}
+		for _, candidate := range llmCandidates {
+			if _, err := os.Stat(candidate); err == nil {
+				migrated = append(migrated, candidate)
+			}
+		}
+	}
+
+	if len(migrated) == 0 {
+		return false, nil
+	}
+
+	if err := cfg.Save(path); err != nil {
+		return false, fmt.Errorf("migrating %s: %w", strings.Join(migrated, ", "), err)
+	}
+
+	for _, file := range migrated {
+		if err := os.Rename(file, file+legacySuffix); err != nil {
+			return true, fmt.Errorf("renaming %s: %w", file, err)
+		}
+	}
+	return true, nil
+}
+
+// readKeyValueFile parses a file of key=value lines.
+func readKeyValueFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(content), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return values, nil
+}
+
+// newestFile returns the most recently modified of paths that exist, or "".
+func newestFile(paths []string) string {
+	var newest string
+	var newestInfo os.FileInfo
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if newestInfo == nil || info.ModTime().After(newestInfo.ModTime()) {
+			newest, newestInfo = path, info
+		}
+	}
+	return newest
+}
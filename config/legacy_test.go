@@ -0,0 +1,106 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeLegacyFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkMigrated fails unless every path was renamed with the legacy suffix.
+func checkMigrated(t *testing.T, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s is still there: %v", path, err)
+		}
+		if _, err := os.Stat(path + legacySuffix); err != nil {
+			t.Errorf("%s was not renamed: %v", path, err)
+		}
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	dbFile := filepath.Join(dir, legacyDBFile)
+	llmFile := filepath.Join(dir, legacyLLMFile)
+	writeLegacyFile(t, dbFile, "/data/basal.db\n")
+	writeLegacyFile(t, llmFile, "endpoint = http://gpu-box:11434\nmodel=llama3.1:8b\nignored line\n")
+
+	migrated, err := MigrateLegacy(dir, path)
+	if err != nil || !migrated {
+		t.Fatalf("MigrateLegacy = %v, %v; want a migration", migrated, err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Database.Path != "/data/basal.db" || cfg.Database.Backend != Default().Database.Backend {
+		t.Errorf("database = %+v", cfg.Database)
+	}
+	if cfg.LLM.Endpoint != "http://gpu-box:11434" || cfg.LLM.Model != "llama3.1:8b" {
+		t.Errorf("llm = %+v", cfg.LLM)
+	}
+	checkMigrated(t, dbFile, llmFile)
+
+	// Once config.toml exists nothing more is migrated
+	writeLegacyFile(t, dbFile, "/other/basal.db\n")
+	if migrated, err := MigrateLegacy(dir, path); err != nil || migrated {
+		t.Errorf("second MigrateLegacy = %v, %v; want nothing done", migrated, err)
+	}
+	if _, err := os.Stat(dbFile); err != nil {
+		t.Errorf("a new %s was touched: %v", legacyDBFile, err)
+	}
+}
+
+func TestMigrateLegacyNewestLLMFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	llmFile := filepath.Join(dir, legacyLLMFile)
+	homeFile := filepath.Join(home, ".config", "basal", legacyLLMFile)
+	writeLegacyFile(t, llmFile, "model=old\n")
+	writeLegacyFile(t, homeFile, "model=new\n")
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(llmFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if migrated, err := MigrateLegacy(dir, path); err != nil || !migrated {
+		t.Fatalf("MigrateLegacy = %v, %v; want a migration", migrated, err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.LLM.Model != "new" || cfg.Database.Path != Default().Database.Path {
+		t.Errorf("llm = %+v, database = %+v", cfg.LLM, cfg.Database)
+	}
+	checkMigrated(t, llmFile, homeFile)
+}
+
+func TestMigrateLegacyWithoutFiles(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	if migrated, err := MigrateLegacy(dir, path); err != nil || migrated {
+		t.Errorf("MigrateLegacy = %v, %v; want nothing done", migrated, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s was written: %v", path, err)
+	}
+}
@@ -0,0 +1,286 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxSnapshots is how many automatic snapshots are kept per database.
+const MaxSnapshots = 10
+
+// snapshotTimeFormat names snapshot files so that they sort chronologically.
+const snapshotTimeFormat = "20060102-150405"
+
+// Backup writes a consistent copy of the database at src to dst and verifies
+// it. dst must not already exist.
+func Backup(backend, src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+
+	switch backend {
+	case BackendSQLite, "":
+		return backupSQLite(src, dst)
+	case BackendJSON:
+		return backupJSON(src, dst)
+	case BackendEncrypted:
+		return backupEncrypted(src, dst)
+	default:
+		return fmt.Errorf("the %s backend does not support backups", backend)
+	}
+}
+
+// Restore verifies the backup at src and replaces the database at dst with it.
+// A snapshot of the current database is taken first.
+func Restore(backend, src, dst string) error {
+	if err := Verify(backend, src); err != nil {
+		return fmt.Errorf("verifying %s: %w", src, err)
+	}
+
+	if _, err := Snapshot(backend, dst); err != nil {
+		return fmt.Errorf("snapshotting current database: %w", err)
+	}
+
+	// Write the restored copy next to dst so the final rename is atomic
+	tmp := fmt.Sprintf("%s.restore-%d", dst, time.Now().UnixNano())
+	if err := Backup(backend, src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// A leftover rollback journal belongs to the old file and must not be
+	// replayed against the restored one
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if err := os.Remove(dst + suffix); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp)
+			return fmt.Errorf("removing %s: %w", dst+suffix, err)
+		}
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replacing %s: %w", dst, err)
+	}
+	return nil
+}
+
+// Verify checks that the file at path is an intact basal database.
+func Verify(backend, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	switch backend {
+	case BackendSQLite, "":
+		return CheckIntegrity(path)
+	case BackendJSON:
+		_, err := OpenJSONStore(path)
+		return err
+	case BackendEncrypted:
+		_, err := readEnvelope(path)
+		return err
+	default:
+		return fmt.Errorf("the %s backend does not support backups", backend)
+	}
+}
+
+// CheckIntegrity runs PRAGMA integrity_check on the SQLite database at path and
+// confirms it contains the basal tables.
+func CheckIntegrity(path string) error {
+	database, err := sql.Open(driverName, path)
+	if err != nil {
+		return fmt.Errorf("opening database: %w", err)
+	}
+	defer database.Close()
+
+	rows, err := database.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("checking integrity: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return fmt.Errorf("checking integrity: %w", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("checking integrity: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+
+	var tables int
+	err = database.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name IN ('basal_records', 'basal_intervals')`).Scan(&tables)
+	if err != nil {
+		return fmt.Errorf("inspecting tables: %w", err)
+	}
+	if tables != 2 {
+		return fmt.Errorf("not a basal database: missing basal_records or basal_intervals")
+	}
+
+	return nil
+}
+
+// Snapshot backs up the database at path into its snapshot directory and
+// removes the oldest snapshots beyond MaxSnapshots. It returns the path of the
+// new snapshot, or "" if there was no database to snapshot.
+func Snapshot(backend, path string) (string, error) {
+	if backend == BackendMemory {
+		return "", nil
+	}
+	if info, err := os.Stat(path); os.IsNotExist(err) || (err == nil && info.Size() == 0) {
+		return "", nil
+	}
+
+	dir := SnapshotDir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("creating snapshot directory: %w", err)
+	}
+
+	ext := filepath.Ext(path)
+	name := strings.TrimSuffix(filepath.Base(path), ext) + "-" + time.Now().Format(snapshotTimeFormat)
+	snapshot := filepath.Join(dir, name+ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(snapshot); os.IsNotExist(err) {
+			break
+		}
+		snapshot = filepath.Join(dir, fmt.Sprintf("%s-%d%s", name, i, ext))
+	}
+
+	if err := Backup(backend, path, snapshot); err != nil {
+		os.Remove(snapshot)
+		return "", err
+	}
+
+	if err := pruneSnapshots(dir, MaxSnapshots); err != nil {
+		return snapshot, fmt.Errorf("removing old snapshots: %w", err)
+	}
+	return snapshot, nil
+}
+
+// SnapshotDir returns the directory holding automatic snapshots of the database at path.
+func SnapshotDir(path string) string {
+	return path + ".snapshots"
+}
+
+// ListSnapshots returns the snapshots of the database at path, newest first.
+func ListSnapshots(path string) ([]string, error) {
+	entries, err := os.ReadDir(SnapshotDir(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			snapshots = append(snapshots, filepath.Join(SnapshotDir(path), entry.Name()))
+		}
+	}
+	sortByModTimeDesc(snapshots)
+	return snapshots, nil
+}
+
+// pruneSnapshots keeps only the newest keep files in dir.
+func pruneSnapshots(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sortByModTimeDesc(files)
+
+	for i := keep; i < len(files); i++ {
+		if err := os.Remove(files[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortByModTimeDesc(paths []string) {
+	modTimes := make(map[string]time.Time, len(paths))
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil {
+			modTimes[p] = info.ModTime()
+		}
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		if !modTimes[paths[i]].Equal(modTimes[paths[j]]) {
+			return modTimes[paths[i]].After(modTimes[paths[j]])
+		}
+		return paths[i] > paths[j]
+	})
+}
+
+// backupSQLite copies a live SQLite database with VACUUM INTO, which produces
+// a consistent, compacted copy without reading the file into memory.
+func backupSQLite(src, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+
+	database, err := sql.Open(driverName, src)
+	if err != nil {
+		return fmt.Errorf("opening database: %w", err)
+	}
+	defer database.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("creating backup directory: %w", err)
+	}
+
+	if _, err := database.Exec("VACUUM INTO ?", dst); err != nil {
+		return fmt.Errorf("writing backup: %w", err)
+	}
+
+	if err := CheckIntegrity(dst); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("verifying backup: %w", err)
+	}
+	return nil
+}
+
+// backupJSON copies a JSON store after checking that it parses.
+func backupJSON(src, dst string) error {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	if err := NewMemoryStore().loadJSON(content); err != nil {
+		return fmt.Errorf("parsing %s: %w", src, err)
+	}
+
+	if err := writeFileAtomic(dst, content, 0600); err != nil {
+		return err
+	}
+
+	if _, err := OpenJSONStore(dst); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("verifying backup: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordDates opens the SQLite database at path and returns its record dates,
+// newest first.
+func recordDates(t *testing.T, path string) []string {
+	t.Helper()
+	database, err := InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB(%s): %v", path, err)
+	}
+	defer database.Close()
+
+	records, err := ListBasalRecords(database)
+	if err != nil {
+		t.Fatalf("ListBasalRecords: %v", err)
+	}
+	var dates []string
+	for _, r := range records {
+		dates = append(dates, r.Date.Format(DateFormat))
+	}
+	return dates
+}
+
+func TestRestoreOverExistingDatabase(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "basal.db")
+	backup := filepath.Join(dir, "backup.db")
+
+	database, err := InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	createTestRecord(t, database, "2024-01-01", 0.8)
+	if err := Backup(BackendSQLite, path, backup); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	createTestRecord(t, database, "2024-06-01", 1.0)
+	database.Close()
+
+	// A stale journal must not be replayed against the restored file
+	if err := os.WriteFile(path+"-journal", []byte("stale"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Backup(BackendSQLite, backup, path); err == nil {
+		t.Error("Backup overwrote an existing database")
+	}
+	if err := Restore(BackendSQLite, backup, path); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if got, want := recordDates(t, path), []string{"2024-01-01"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("restored dates = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(path + "-journal"); !os.IsNotExist(err) {
+		t.Errorf("journal left behind: %v", err)
+	}
+
+	// The replaced database is kept as a snapshot
+	snapshots, err := ListSnapshots(path)
+	if err != nil {
+		t.Fatalf("ListSnapshots: %v", err)
+	}
+	if len(snapshots) != 1 {
+		t.Fatalf("snapshots = %v, want one", snapshots)
+	}
+	if got, want := recordDates(t, snapshots[0]), []string{"2024-06-01", "2024-01-01"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot dates = %v, want %v", got, want)
+	}
+
+	leftovers, _ := filepath.Glob(path + ".restore-*")
+	if len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestRestoreRejectsCorruptBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "basal.db")
+
+	database, err := InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	createTestRecord(t, database, "2024-01-01", 0.8)
+	database.Close()
+
+	// An SQLite file without the basal tables
+	other := filepath.Join(dir, "other.db")
+	otherDB, err := sql.Open(driverName, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherDB.Exec("CREATE TABLE notes (body TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	otherDB.Close()
+
+	garbage := filepath.Join(dir, "garbage.db")
+	if err := os.WriteFile(garbage, []byte("this is not a database"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A real backup cut short
+	truncated := filepath.Join(dir, "truncated.db")
+	if err := Backup(BackendSQLite, path, truncated); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	info, err := os.Stat(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(truncated, info.Size()/2); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, backup := range []string{garbage, other, truncated, filepath.Join(dir, "missing.db")} {
+		if err := Restore(BackendSQLite, backup, path); err == nil {
+			t.Errorf("Restore(%s) succeeded", filepath.Base(backup))
+		}
+	}
+
+	if got, want := recordDates(t, path), []string{"2024-01-01"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dates after failed restores = %v, want %v", got, want)
+	}
+	if snapshots, _ := ListSnapshots(path); len(snapshots) != 0 {
+		t.Errorf("failed restores took snapshots: %v", snapshots)
+	}
+}
+
+func TestPruneSnapshotsKeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// Names deliberately disagree with the modification times, and two files
+	// share a time to exercise the tie-break on name
+	files := map[string]time.Duration{
+		"a.db": 3 * time.Hour,
+		"b.db": 1 * time.Hour,
+		"c.db": 4 * time.Hour,
+		"d.db": 2 * time.Hour,
+		"e.db": 2 * time.Hour,
+	}
+	for name, age := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+		modTime := base.Add(-age)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "not-a-snapshot"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pruneSnapshots(dir, 3); err != nil {
+		t.Fatalf("pruneSnapshots: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	if want := []string{"b.db", "d.db", "e.db", "not-a-snapshot"}; !reflect.DeepEqual(left, want) {
+		t.Errorf("left = %v, want %v", left, want)
+	}
+}
+
+func TestSnapshotKeepsMaxSnapshots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "basal.db")
+	database, err := InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	createTestRecord(t, database, "2024-01-01", 0.8)
+	database.Close()
+
+	// Snapshots taken within the same second get distinct names; spread
+	// their times so the oldest are unambiguous
+	var times []time.Time
+	for i := 0; i < MaxSnapshots+2; i++ {
+		snapshot, err := Snapshot(BackendSQLite, path)
+		if err != nil {
+			t.Fatalf("Snapshot %d: %v", i, err)
+		}
+		modTime := time.Now().Add(time.Duration(i-MaxSnapshots-2) * time.Minute).Truncate(time.Second)
+		if err := os.Chtimes(snapshot, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		times = append(times, modTime)
+	}
+
+	snapshots, err := ListSnapshots(path)
+	if err != nil {
+		t.Fatalf("ListSnapshots: %v", err)
+	}
+	if len(snapshots) != MaxSnapshots {
+		t.Fatalf("%d snapshots kept, want %d", len(snapshots), MaxSnapshots)
+	}
+	// Newest first; the two oldest were pruned
+	for i, snapshot := range snapshots {
+		info, err := os.Stat(snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := times[len(times)-1-i]; !info.ModTime().Equal(want) {
+			t.Errorf("snapshot %d was taken at %v, want %v", i, info.ModTime(), want)
+		}
+	}
+}
+
+func TestSnapshotWithoutDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "basal.db")
+	for _, backend := range []string{BackendSQLite, BackendMemory} {
+		snapshot, err := Snapshot(backend, path)
+		if err != nil || snapshot != "" {
+			t.Errorf("%s: Snapshot = %q, %v; want nothing", backend, snapshot, err)
+		}
+	}
+	if _, err := os.Stat(SnapshotDir(path)); !os.IsNotExist(err) {
+		t.Errorf("snapshot directory created: %v", err)
+	}
+}
@@ -6,13 +6,19 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"os"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"basal/schedule"
 )
 
 const DateFormat = "2006-01-02"
 
+// timestampFormat is how SQLite's CURRENT_TIMESTAMP stores created_at. Timestamps
+// are read back as text and parsed here, since SQLite drivers differ in
+// whether and how they convert DATETIME columns to time.Time.
+const timestampFormat = "2006-01-02 15:04:05"
+
 // BasalRecord represents a daily basal insulin rate record.
 type BasalRecord struct {
 	ID         int64
@@ -21,42 +27,68 @@ type BasalRecord struct {
 	CreatedAt  time.Time
 }
 
-// BasalInterval represents a time interval within a day with a specific basal rate.
-type BasalInterval struct {
-	ID            int64
-	BasalRecordID int64
-	StartTime     string // Format: HH:MM
-	EndTime       string // Format: HH:MM
-	UnitsPerHour  float64
-}
-
 var ErrNoRecords = fmt.Errorf("no basal records found")
 
 func InitDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	info, statErr := os.Stat(dbPath)
+	existed := statErr == nil && info.Size() > 0
+
+	db, err := sql.Open(driverName, dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("opening database: %w", err)
 	}
 
+	// Keep a copy of existing data before anything changes it
+	if existed {
+		version, err := schemaVersion(db)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		if version < len(migrations) {
+			if _, err := Snapshot(BackendSQLite, dbPath); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("snapshotting before migration: %w", err)
+			}
+		}
+	}
+
 	// Create tables if they don't exist
-	if _, err = db.Exec(GetSchema()); err != nil {
+	if _, err = db.Exec(tableSchema); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("creating tables: %w", err)
 	}
 
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating database: %w", err)
+	}
+
+	// Views read the migrated tables, so they come last. They hold no data
+	// and are recreated so that databases pick up changes to them.
+	if err := createViews(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating views: %w", err)
+	}
+
 	return db, nil
 }
 
 // CreateBasalRecord adds a new basal record with its intervals to the database.
 // It uses a transaction to ensure all operations succeed or fail together.
-func CreateBasalRecord(db *sql.DB, date time.Time, intervals []BasalInterval) error {
+func CreateBasalRecord(db *sql.DB, date time.Time, sched schedule.Schedule) (int64, error) {
+	if err := sched.Validate(); err != nil {
+		return 0, fmt.Errorf("invalid schedule: %w", err)
+	}
+
 	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("beginning transaction: %w", err)
+		return 0, fmt.Errorf("beginning transaction: %w", err)
 	}
 	defer tx.Rollback()
 
 	// Calculate total units for the day
-	totalUnits := CalculateDailyBasal(intervals)
+	totalUnits := sched.TotalUnits()
 
 	result, err := tx.Exec(
 		"INSERT INTO basal_records (date, total_units) VALUES (?, ?)",
@@ -64,124 +96,158 @@ func CreateBasalRecord(db *sql.DB, date time.Time, intervals []BasalInterval) er
 		totalUnits,
 	)
 	if err != nil {
-		return fmt.Errorf("inserting basal record: %w", err)
+		return 0, fmt.Errorf("inserting basal record: %w", err)
 	}
 
 	recordID, err := result.LastInsertId()
 	if err != nil {
-		return fmt.Errorf("getting last insert ID: %w", err)
+		return 0, fmt.Errorf("getting last insert ID: %w", err)
+	}
+
+	if err := insertIntervals(tx, recordID, sched); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing transaction: %w", err)
 	}
 
+	return recordID, nil
+}
+
+// UpdateBasalRecord replaces the date and intervals of an existing record.
+func UpdateBasalRecord(db *sql.DB, id int64, date time.Time, sched schedule.Schedule) error {
+	if err := sched.Validate(); err != nil {
+		return fmt.Errorf("invalid schedule: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE basal_records SET date = ?, total_units = ? WHERE id = ?",
+		date.Format(DateFormat),
+		sched.TotalUnits(),
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("updating basal record: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("record with ID %d not found", id)
+	}
+
+	if _, err := tx.Exec("DELETE FROM basal_intervals WHERE basal_record_id = ?", id); err != nil {
+		return fmt.Errorf("deleting old intervals: %w", err)
+	}
+
+	if err := insertIntervals(tx, id, sched); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// insertIntervals stores each segment of sched as an interval of recordID.
+func insertIntervals(tx *sql.Tx, recordID int64, sched schedule.Schedule) error {
 	stmt, err := tx.Prepare(`
 		INSERT INTO basal_intervals (
-			basal_record_id, start_time, end_time, units_per_hour
+			basal_record_id, start_seconds, end_seconds, units_per_hour
 		) VALUES (?, ?, ?, ?)`)
 	if err != nil {
 		return fmt.Errorf("preparing interval statement: %w", err)
 	}
 	defer stmt.Close()
 
-	for i, interval := range intervals {
+	for i, segment := range sched {
 		_, err = stmt.Exec(
 			recordID,
-			interval.StartTime,
-			interval.EndTime,
-			interval.UnitsPerHour,
+			segment.StartTime,
+			segment.EndTime,
+			segment.UnitsPerHour,
 		)
 		if err != nil {
 			return fmt.Errorf("inserting interval %d: %w", i+1, err)
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("committing transaction: %w", err)
+	return nil
+}
+
+// GetBasalRecordByDate returns the record in effect on date: an exact match if
+// one exists, otherwise the closest previous record, otherwise the earliest.
+func GetBasalRecordByDate(db *sql.DB, date time.Time) (*BasalRecord, schedule.Schedule, error) {
+	// Get the exact match or closest previous record's ID first
+	idQuery := `
+	SELECT id
+	FROM basal_records
+	WHERE date(date) <= date(?)
+	ORDER BY date DESC, id DESC
+	LIMIT 1`
+
+	var recordID int64
+	err := db.QueryRow(idQuery, date.Format(DateFormat)).Scan(&recordID)
+	if err == sql.ErrNoRows {
+		// If no previous record, get the earliest record's ID
+		err = db.QueryRow(`
+			SELECT id
+			FROM basal_records
+			ORDER BY date ASC, id ASC
+			LIMIT 1`).Scan(&recordID)
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrNoRecords
+		}
+	}
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil
+	return GetBasalRecord(db, recordID)
 }
 
-func GetBasalRecordByDate(db *sql.DB, date time.Time) (*BasalRecord, []BasalInterval, error) {
-	// First try to get exact match
+// GetBasalRecord returns a record and its intervals by ID.
+func GetBasalRecord(db *sql.DB, id int64) (*BasalRecord, schedule.Schedule, error) {
 	query := `
-	SELECT br.id, strftime('%Y-%m-%d', br.date) as date, br.total_units, br.created_at,
-		   bi.id, bi.start_time, bi.end_time, bi.units_per_hour
+	SELECT br.id, strftime('%Y-%m-%d', br.date) as date, br.total_units,
+		   strftime('%Y-%m-%d %H:%M:%S', br.created_at) as created_at,
+		   bi.start_seconds, bi.end_seconds, bi.units_per_hour
 	FROM basal_records br
-	LEFT JOIN basal_intervals bi ON br.id = bi.basal_record_id
-	WHERE date(br.date) = date(?)
-	ORDER BY bi.start_time`
+	JOIN basal_intervals bi ON br.id = bi.basal_record_id
+	WHERE br.id = ?
+	ORDER BY bi.start_seconds`
 
-	rows, err := db.Query(query, date.Format(DateFormat))
+	rows, err := db.Query(query, id)
 	if err != nil {
 		return nil, nil, err
 	}
 	defer rows.Close()
 
-	if !rows.Next() {
-		// If no exact match, get the closest previous record's ID first
-		idQuery := `
-		SELECT id, strftime('%Y-%m-%d', date) as date
-		FROM basal_records
-		WHERE date(date) <= date(?)
-		ORDER BY date DESC
-		LIMIT 1`
-
-		var recordID int64
-		var dateStr string
-		err = db.QueryRow(idQuery, date.Format(DateFormat)).Scan(&recordID, &dateStr)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				// If no previous record, get the earliest record's ID
-				err = db.QueryRow(`
-					SELECT id, strftime('%Y-%m-%d', date) as date
-					FROM basal_records
-					ORDER BY date ASC
-					LIMIT 1`).Scan(&recordID, &dateStr)
-				if err != nil {
-					if err == sql.ErrNoRows {
-						return nil, nil, fmt.Errorf("no basal records found")
-					}
-					return nil, nil, err
-				}
-			} else {
-				return nil, nil, err
-			}
-		}
-
-		// Now get all intervals for this record
-		query = `
-		SELECT br.id, strftime('%Y-%m-%d', br.date) as date, br.total_units, br.created_at,
-			   bi.id, bi.start_time, bi.end_time, bi.units_per_hour
-		FROM basal_records br
-		LEFT JOIN basal_intervals bi ON br.id = bi.basal_record_id
-		WHERE br.id = ?
-		ORDER BY bi.start_time`
-
-		rows, err = db.Query(query, recordID)
-		if err != nil {
-			return nil, nil, err
-		}
-		defer rows.Close()
-
-		if !rows.Next() {
-			return nil, nil, fmt.Errorf("no intervals found for record %d", recordID)
-		}
-	}
-
 	var record BasalRecord
-	var intervals []BasalInterval
+	var sched schedule.Schedule
 
-	for {
-		var interval BasalInterval
-		var dateStr string
+	for rows.Next() {
+		var segment schedule.Segment
+		var dateStr, createdStr string
 		err := rows.Scan(
 			&record.ID,
 			&dateStr,
 			&record.TotalUnits,
-			&record.CreatedAt,
-			&interval.ID,
-			&interval.StartTime,
-			&interval.EndTime,
-			&interval.UnitsPerHour,
+			&createdStr,
+			&segment.StartTime,
+			&segment.EndTime,
+			&segment.UnitsPerHour,
 		)
 		if err != nil {
 			return nil, nil, err
@@ -192,22 +258,37 @@ func GetBasalRecordByDate(db *sql.DB, date time.Time) (*BasalRecord, []BasalInte
 			return nil, nil, err
 		}
 
-		interval.BasalRecordID = record.ID
-		intervals = append(intervals, interval)
+		record.CreatedAt, err = time.Parse(timestampFormat, createdStr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sched = append(sched, segment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
 
-		if !rows.Next() {
-			break
+	if len(sched) == 0 {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM basal_records WHERE id = ?)", id).Scan(&exists); err != nil {
+			return nil, nil, err
 		}
+		if !exists {
+			return nil, nil, fmt.Errorf("record with ID %d not found", id)
+		}
+		return nil, nil, fmt.Errorf("no intervals found for record %d", id)
 	}
 
-	return &record, intervals, nil
+	return &record, sched, nil
 }
 
 func ListBasalRecords(db *sql.DB) ([]BasalRecord, error) {
 	rows, err := db.Query(`
-		SELECT id, strftime('%Y-%m-%d', date) as date, created_at
+		SELECT id, strftime('%Y-%m-%d', date) as date, total_units,
+		       strftime('%Y-%m-%d %H:%M:%S', created_at) as created_at
 		FROM basal_records
-		ORDER BY date DESC`)
+		ORDER BY date DESC, id DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -216,8 +297,8 @@ func ListBasalRecords(db *sql.DB) ([]BasalRecord, error) {
 	var records []BasalRecord
 	for rows.Next() {
 		var record BasalRecord
-		var dateStr string
-		err := rows.Scan(&record.ID, &dateStr, &record.CreatedAt)
+		var dateStr, createdStr string
+		err := rows.Scan(&record.ID, &dateStr, &record.TotalUnits, &createdStr)
 		if err != nil {
 			return nil, err
 		}
@@ -227,10 +308,15 @@ func ListBasalRecords(db *sql.DB) ([]BasalRecord, error) {
 			return nil, err
 		}
 
+		record.CreatedAt, err = time.Parse(timestampFormat, createdStr)
+		if err != nil {
+			return nil, err
+		}
+
 		records = append(records, record)
 	}
 
-	return records, nil
+	return records, rows.Err()
 }
 
 func DeleteBasalRecord(db *sql.DB, id int64) error {
@@ -263,35 +349,18 @@ func DeleteBasalRecord(db *sql.DB, id int64) error {
 	return tx.Commit()
 }
 
-func CalculateDailyBasal(intervals []BasalInterval) float64 {
-	var total float64
-	for _, interval := range intervals {
-		startParts := splitTime(interval.StartTime)
-		endParts := splitTime(interval.EndTime)
-
-		startMinutes := startParts[0]*60 + startParts[1]
-		endMinutes := endParts[0]*60 + endParts[1]
-
-		// Handle cases where the interval crosses midnight
-		if endMinutes <= startMinutes {
-			endMinutes += 24 * 60
-		}
-
-		hours := float64(endMinutes-startMinutes) / 60.0
-		total += hours * interval.UnitsPerHour
-	}
-	return total
+// GetSchema returns the SQLite schema for the basal database.
+func GetSchema() string {
+	return tableSchema + "\n" + viewSchema
 }
 
-func splitTime(timeStr string) [2]int {
-	var hour, min int
-	fmt.Sscanf(timeStr, "%d:%d", &hour, &min)
-	return [2]int{hour, min}
-}
+// schemaViews lists the views in viewSchema.
+var schemaViews = []string{"effective_schedule", "daily_basal"}
 
-// GetSchema returns the SQLite schema for the basal database.
-func GetSchema() string {
-	return `
+// tableSchema creates the tables of the basal database. Views are kept apart
+// in viewSchema, since they must only be created once migrations have
+// brought the tables they read up to date.
+const tableSchema = `
 	CREATE TABLE IF NOT EXISTS basal_records (
 		id INTEGER PRIMARY KEY AUTOINCREMENT, -- Unique identifier for each basal record
 		date DATE NOT NULL,                   -- Date for which this basal profile applies
@@ -302,11 +371,62 @@ func GetSchema() string {
 	CREATE TABLE IF NOT EXISTS basal_intervals (
 		id INTEGER PRIMARY KEY AUTOINCREMENT, -- Unique identifier for each interval
 		basal_record_id INTEGER,              -- Foreign key to the parent basal record
-		start_time TEXT NOT NULL,             -- Start time of interval in HH:MM format
-		end_time TEXT NOT NULL,               -- End time of interval in HH:MM format
+		start_seconds INTEGER NOT NULL,       -- Start of interval in seconds after midnight
+		end_seconds INTEGER NOT NULL,         -- End of interval in seconds after midnight (0 = end of day)
 		units_per_hour REAL NOT NULL,         -- Insulin units per hour during this interval
 		FOREIGN KEY (basal_record_id) REFERENCES basal_records(id),
-		CHECK (start_time >= '00:00' AND start_time <= '23:59'), -- Validate time format
-		CHECK (end_time >= '00:00' AND end_time <= '23:59')      -- Validate time format
+		CHECK (start_seconds >= 0 AND start_seconds < 86400), -- Validate time range
+		CHECK (end_seconds >= 0 AND end_seconds < 86400)      -- Validate time range
+	);`
+
+// viewSchema creates the views over the tables in tableSchema.
+const viewSchema = `
+	-- Each interval with the dates its record is in effect: from its date
+	-- until the day before the next record. Of several records on the same
+	-- date, the newest is the one in effect.
+	CREATE VIEW IF NOT EXISTS effective_schedule AS
+	SELECT
+		r.id AS basal_record_id,              -- The record the interval belongs to
+		date(r.date) AS date_from,            -- First day the schedule is in effect
+		(SELECT date(MIN(date(n.date)), '-1 day')
+		 FROM basal_records n
+		 WHERE date(n.date) > date(r.date)) AS date_to, -- Last day it is in effect; NULL while still current
+		i.start_seconds,                      -- Start of interval in seconds after midnight
+		CASE WHEN i.end_seconds = 0 THEN 86400 ELSE i.end_seconds END AS end_seconds, -- End of interval; 86400 = midnight at the end of the day
+		printf('%02d:%02d', i.start_seconds / 3600, i.start_seconds % 3600 / 60) AS start_time, -- Start as HH:MM
+		printf('%02d:%02d', i.end_seconds / 3600, i.end_seconds % 3600 / 60) AS end_time,       -- End as HH:MM; 00:00 = midnight at the end of the day
+		i.units_per_hour,                     -- Insulin units per hour during this interval
+		r.total_units                         -- Total daily units of the schedule
+	FROM basal_records r
+	JOIN basal_intervals i ON i.basal_record_id = r.id
+	WHERE NOT EXISTS (
+		SELECT 1 FROM basal_records s
+		WHERE date(s.date) = date(r.date) AND s.id > r.id
+	);
+
+	-- Every day from January 1 of the first record's year until today (or
+	-- the last record, if later) with the record in effect on it, chosen as
+	-- GetBasalRecordByDate chooses it: the latest record on or before the
+	-- day, or the earliest record for days before it.
+	CREATE VIEW IF NOT EXISTS daily_basal AS
+	WITH RECURSIVE days(day) AS (
+		SELECT date(MIN(date(date)), 'start of year') FROM basal_records
+		UNION ALL
+		SELECT date(day, '+1 day') FROM days
+		WHERE day < (SELECT MAX(MAX(date(date)), date('now', 'localtime')) FROM basal_records)
+	)
+	SELECT
+		d.day AS date,                        -- Calendar day
+		r.id AS basal_record_id,              -- The record in effect that day
+		date(r.date) AS record_date,          -- Date of that record, i.e. when the schedule started
+		r.total_units                         -- Total insulin units for the day
+	FROM days d
+	JOIN basal_records r ON r.id = COALESCE(
+		(SELECT id FROM basal_records
+		 WHERE date(date) <= d.day
+		 ORDER BY date DESC, id DESC
+		 LIMIT 1),
+		(SELECT id FROM basal_records
+		 ORDER BY date ASC, id ASC
+		 LIMIT 1)
 	);`
-}
@@ -0,0 +1,313 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"basal/schedule"
+)
+
+// These tests use whichever driver the build selects; run them with and
+// without -tags purego to cover both.
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	database, err := InitDB(filepath.Join(t.TempDir(), "basal.db"))
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func day(s string) time.Time {
+	d, err := time.Parse(DateFormat, s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// testSchedule runs rate from midnight to 06:00 and rate+0.2 for the rest of
+// the day.
+func testSchedule(rate float64) schedule.Schedule {
+	return schedule.Schedule{
+		{StartTime: schedule.Midnight, EndTime: 6 * 3600, UnitsPerHour: rate},
+		{StartTime: 6 * 3600, EndTime: schedule.Midnight, UnitsPerHour: rate + 0.2},
+	}
+}
+
+func createTestRecord(t *testing.T, database *sql.DB, date string, rate float64) int64 {
+	t.Helper()
+	id, err := CreateBasalRecord(database, day(date), testSchedule(rate))
+	if err != nil {
+		t.Fatalf("CreateBasalRecord: %v", err)
+	}
+	return id
+}
+
+func TestCreateAndGetBasalRecord(t *testing.T) {
+	database := openTestDB(t)
+	sched := testSchedule(0.8)
+
+	before := time.Now().UTC().Add(-time.Minute)
+	id, err := CreateBasalRecord(database, day("2024-03-01"), sched)
+	if err != nil {
+		t.Fatalf("CreateBasalRecord: %v", err)
+	}
+
+	record, got, err := GetBasalRecord(database, id)
+	if err != nil {
+		t.Fatalf("GetBasalRecord: %v", err)
+	}
+	if !record.Date.Equal(day("2024-03-01")) {
+		t.Errorf("Date = %v, want 2024-03-01", record.Date)
+	}
+	if record.TotalUnits != sched.TotalUnits() {
+		t.Errorf("TotalUnits = %v, want %v", record.TotalUnits, sched.TotalUnits())
+	}
+	if !reflect.DeepEqual(got, sched) {
+		t.Errorf("schedule = %v, want %v", got, sched)
+	}
+	if record.CreatedAt.Before(before) || record.CreatedAt.After(time.Now().UTC().Add(time.Minute)) {
+		t.Errorf("CreatedAt = %v, want about now", record.CreatedAt)
+	}
+
+	if _, _, err := GetBasalRecord(database, id+1); err == nil {
+		t.Error("GetBasalRecord of a missing ID succeeded")
+	}
+}
+
+func TestCreateBasalRecordRejectsInvalidSchedule(t *testing.T) {
+	database := openTestDB(t)
+	gap := schedule.Schedule{{StartTime: 3600, EndTime: schedule.Midnight, UnitsPerHour: 1}}
+	if _, err := CreateBasalRecord(database, day("2024-03-01"), gap); err == nil {
+		t.Fatal("CreateBasalRecord accepted a schedule not starting at midnight")
+	}
+	records, err := ListBasalRecords(database)
+	if err != nil {
+		t.Fatalf("ListBasalRecords: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("%d records stored, want 0", len(records))
+	}
+}
+
+func TestUpdateAndDeleteBasalRecord(t *testing.T) {
+	database := openTestDB(t)
+	id, err := CreateBasalRecord(database, day("2024-03-01"), testSchedule(0.8))
+	if err != nil {
+		t.Fatalf("CreateBasalRecord: %v", err)
+	}
+
+	updated := testSchedule(1.1)
+	if err := UpdateBasalRecord(database, id, day("2024-04-01"), updated); err != nil {
+		t.Fatalf("UpdateBasalRecord: %v", err)
+	}
+	record, got, err := GetBasalRecord(database, id)
+	if err != nil {
+		t.Fatalf("GetBasalRecord: %v", err)
+	}
+	if !record.Date.Equal(day("2024-04-01")) || !reflect.DeepEqual(got, updated) {
+		t.Errorf("after update got %v %v, want 2024-04-01 %v", record.Date, got, updated)
+	}
+
+	if err := DeleteBasalRecord(database, id); err != nil {
+		t.Fatalf("DeleteBasalRecord: %v", err)
+	}
+	if _, _, err := GetBasalRecord(database, id); err == nil {
+		t.Error("GetBasalRecord after delete succeeded")
+	}
+	var intervals int
+	if err := database.QueryRow("SELECT COUNT(*) FROM basal_intervals").Scan(&intervals); err != nil {
+		t.Fatal(err)
+	}
+	if intervals != 0 {
+		t.Errorf("%d intervals left after delete, want 0", intervals)
+	}
+}
+
+func TestGetBasalRecordByDate(t *testing.T) {
+	database := openTestDB(t)
+	if _, _, err := GetBasalRecordByDate(database, day("2024-01-01")); !errors.Is(err, ErrNoRecords) {
+		t.Fatalf("empty database: err = %v, want ErrNoRecords", err)
+	}
+
+	first := createTestRecord(t, database, "2024-01-01", 0.8)
+	createTestRecord(t, database, "2024-06-01", 0.9)
+	newest := createTestRecord(t, database, "2024-06-01", 1.0)
+
+	tests := []struct {
+		date string
+		want int64
+	}{
+		{"2024-01-01", first},
+		{"2024-03-15", first},
+		{"2024-06-01", newest}, // the newest of two records on the same date
+		{"2025-01-01", newest},
+	}
+	for _, tt := range tests {
+		record, _, err := GetBasalRecordByDate(database, day(tt.date))
+		if err != nil {
+			t.Errorf("%s: %v", tt.date, err)
+			continue
+		}
+		if record.ID != tt.want {
+			t.Errorf("%s: got record %d, want %d", tt.date, record.ID, tt.want)
+		}
+	}
+
+	// Before the first record the earliest one is shown
+	record, _, err := GetBasalRecordByDate(database, day("2023-12-31"))
+	if err != nil || record.ID != first {
+		t.Errorf("before the first record: got %v, %v; want record %d", record, err, first)
+	}
+}
+
+func TestDailyBasalMatchesGetBasalRecordByDate(t *testing.T) {
+	database := openTestDB(t)
+	createTestRecord(t, database, "2024-03-10", 0.8)
+	createTestRecord(t, database, "2024-03-10", 0.9)
+	createTestRecord(t, database, "2024-05-01", 1.0)
+
+	var first string
+	if err := database.QueryRow("SELECT MIN(date) FROM daily_basal").Scan(&first); err != nil {
+		t.Fatal(err)
+	}
+	if first != "2024-01-01" {
+		t.Errorf("daily_basal starts on %s, want 2024-01-01", first)
+	}
+
+	for _, date := range []string{"2024-01-01", "2024-03-09", "2024-03-10", "2024-04-30", "2024-05-01", "2024-06-15"} {
+		record, _, err := GetBasalRecordByDate(database, day(date))
+		if err != nil {
+			t.Fatalf("%s: %v", date, err)
+		}
+		var viewID int64
+		if err := database.QueryRow("SELECT basal_record_id FROM daily_basal WHERE date = ?", date).Scan(&viewID); err != nil {
+			t.Fatalf("%s: daily_basal: %v", date, err)
+		}
+		if viewID != record.ID {
+			t.Errorf("%s: daily_basal has record %d, GetBasalRecordByDate %d", date, viewID, record.ID)
+		}
+	}
+}
+
+func TestListBasalRecordsNewestFirst(t *testing.T) {
+	database := openTestDB(t)
+	for _, d := range []string{"2024-03-01", "2024-01-01", "2024-06-01"} {
+		createTestRecord(t, database, d, 1)
+	}
+	records, err := ListBasalRecords(database)
+	if err != nil {
+		t.Fatalf("ListBasalRecords: %v", err)
+	}
+	var dates []string
+	for _, r := range records {
+		dates = append(dates, r.Date.Format(DateFormat))
+		if r.CreatedAt.IsZero() {
+			t.Errorf("record %d has no CreatedAt", r.ID)
+		}
+	}
+	if want := []string{"2024-06-01", "2024-03-01", "2024-01-01"}; !reflect.DeepEqual(dates, want) {
+		t.Errorf("dates = %v, want %v", dates, want)
+	}
+}
+
+// legacySchema is the schema before interval times were stored as seconds.
+const legacySchema = `
+	CREATE TABLE basal_records (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date DATE NOT NULL,
+		total_units REAL NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE basal_intervals (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		basal_record_id INTEGER,
+		start_time TEXT NOT NULL,
+		end_time TEXT NOT NULL,
+		units_per_hour REAL NOT NULL
+	);
+	INSERT INTO basal_records (date, total_units) VALUES ('2024-01-01', 22.8);
+	INSERT INTO basal_intervals (basal_record_id, start_time, end_time, units_per_hour)
+	VALUES (1, '00:00', '06:00', 0.8), (1, '06:00', '00:00', 1.0);`
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := sql.Open(driverName, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec(legacySchema); err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	database, err := InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer database.Close()
+
+	version, err := schemaVersion(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("schema version = %d, want %d", version, len(migrations))
+	}
+
+	_, sched, err := GetBasalRecord(database, 1)
+	if err != nil {
+		t.Fatalf("GetBasalRecord: %v", err)
+	}
+	if want := testSchedule(0.8); !reflect.DeepEqual(sched, want) {
+		t.Errorf("migrated schedule = %v, want %v", sched, want)
+	}
+
+	var days int
+	if err := database.QueryRow("SELECT COUNT(*) FROM daily_basal WHERE date <= '2024-01-31'").Scan(&days); err != nil {
+		t.Fatalf("querying daily_basal after migration: %v", err)
+	}
+	if days != 31 {
+		t.Errorf("daily_basal has %d days of January, want 31", days)
+	}
+
+	// The snapshot is taken before anything, even the views, is added
+	snapshots, err := ListSnapshots(path)
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("snapshots = %v, %v; want one", snapshots, err)
+	}
+	snapshot, err := sql.Open(driverName, snapshots[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snapshot.Close()
+	var objects int
+	if err := snapshot.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'basal_%' OR type = 'view'").Scan(&objects); err != nil {
+		t.Fatal(err)
+	}
+	if objects != 2 {
+		t.Errorf("snapshot has %d basal tables and views, want the 2 legacy tables", objects)
+	}
+}
+
+func TestInitDBIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "basal.db")
+	for i := 0; i < 2; i++ {
+		database, err := InitDB(path)
+		if err != nil {
+			t.Fatalf("InitDB #%d: %v", i+1, err)
+		}
+		database.Close()
+	}
+	if _, err := os.Stat(SnapshotDir(path)); !os.IsNotExist(err) {
+		t.Errorf("up-to-date database was snapshotted: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SchemaRules explains what the data in GetSchema means, beyond what the
+// column comments say: how records apply to the days after them and how
+// interval times wrap at midnight.
+func SchemaRules() string {
+	return `- Each basal_records row is a basal schedule that starts on its date and stays
+  in effect until the day before the next record's date. Days without a record
+  of their own use the latest earlier record.
+- For a date before the first record, basal uses the first record.
+- If several records have the same date, the one with the highest id applies.
+- The intervals of a record cover the whole day without gaps or overlaps. The
+  first starts at 0 (00:00); the last ends at 0, which means midnight at the
+  END of the day (24:00), not the start.
+- The insulin an interval delivers is units_per_hour multiplied by its length
+  in hours, (end_seconds - start_seconds) / 3600.0, counting end_seconds 0 as
+  86400. total_units is the sum over all intervals of the record.
+- The effective_schedule view already applies these rules: it has one row per
+  interval of each schedule in effect, with date_from and date_to (NULL while
+  the schedule is still current) and end_seconds 86400 for midnight. For the
+  schedule in effect on a date D, use
+  WHERE date_from <= D AND (date_to IS NULL OR date_to >= D).
+- The daily_basal view has one row for every day from January 1 of the first
+  record's year until today, with the record in effect and its total_units;
+  days before the first record use the first record. Use it for questions
+  about a particular day or about totals over a range of days, since most days
+  have no record of their own.`
+}
+
+// DataProfile summarizes the records a query can see, so that whoever writes
+// the query knows the range of the data.
+type DataProfile struct {
+	Records       int
+	FirstDate     string // YYYY-MM-DD; empty without records
+	LastDate      string
+	MinIntervals  int // per record
+	MaxIntervals  int
+	MinTotalUnits float64
+	MaxTotalUnits float64
+	MinRate       float64 // units per hour
+	MaxRate       float64
+	Examples      *QueryResult // the latest rows of effective_schedule; nil if none were asked for
+}
+
+// Profile returns the DataProfile of the sandboxed data, with up to
+// exampleRows rows of effective_schedule as examples.
+func (s *Sandbox) Profile(ctx context.Context, exampleRows int) (*DataProfile, error) {
+	p := &DataProfile{}
+
+	var first, last sql.NullString
+	var minTotal, maxTotal sql.NullFloat64
+	err := s.conn.QueryRowContext(ctx, `
+		SELECT COUNT(*), MIN(date(date)), MAX(date(date)), MIN(total_units), MAX(total_units)
+		FROM basal_records`).Scan(&p.Records, &first, &last, &minTotal, &maxTotal)
+	if err != nil {
+		return nil, fmt.Errorf("profiling records: %w", err)
+	}
+	if p.Records == 0 {
+		return p, nil
+	}
+	p.FirstDate, p.LastDate = first.String, last.String
+	p.MinTotalUnits, p.MaxTotalUnits = minTotal.Float64, maxTotal.Float64
+
+	var minRate, maxRate sql.NullFloat64
+	var minIntervals, maxIntervals sql.NullInt64
+	err = s.conn.QueryRowContext(ctx, `
+		SELECT MIN(rate_min), MAX(rate_max), MIN(n), MAX(n)
+		FROM (
+			SELECT MIN(units_per_hour) AS rate_min, MAX(units_per_hour) AS rate_max, COUNT(*) AS n
+			FROM basal_intervals
+			GROUP BY basal_record_id
+		)`).Scan(&minRate, &maxRate, &minIntervals, &maxIntervals)
+	if err != nil {
+		return nil, fmt.Errorf("profiling intervals: %w", err)
+	}
+	p.MinRate, p.MaxRate = minRate.Float64, maxRate.Float64
+	p.MinIntervals, p.MaxIntervals = int(minIntervals.Int64), int(maxIntervals.Int64)
+
+	if exampleRows > 0 {
+		p.Examples, err = s.Query(ctx, fmt.Sprintf(
+			"SELECT * FROM effective_schedule ORDER BY date_from DESC, start_seconds LIMIT %d", exampleRows))
+		if err != nil {
+			return nil, fmt.Errorf("reading example rows: %w", err)
+		}
+	}
+	return p, nil
+}
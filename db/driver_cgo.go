@@ -0,0 +1,91 @@
+//go:build cgo && !purego
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName is the database/sql driver used for SQLite. Builds with cgo use
+// mattn/go-sqlite3; see driver_purego.go for the alternative.
+const driverName = "sqlite3"
+
+// sqliteRecursive is SQLITE_RECURSIVE, which go-sqlite3 does not export.
+const sqliteRecursive = 33
+
+// hasAuthorizer reports whether sandboxConn restricts the tables a query may
+// read, so that the sandbox can be opened on the database itself.
+const hasAuthorizer = true
+
+// sandboxConn disables ATTACH on conn and installs an authorizer that only
+// allows reading SandboxTables.
+func sandboxConn(conn *sql.Conn) error {
+	hidden, err := hiddenTables(conn)
+	if err != nil {
+		return err
+	}
+	return conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		c.SetLimit(sqlite3.SQLITE_LIMIT_ATTACHED, 0)
+		c.RegisterAuthorizer(func(action int, arg1, arg2, dbName string) int {
+			return sandboxAuthorizer(hidden, action, arg1, arg2, dbName)
+		})
+		return nil
+	})
+}
+
+// hiddenTables returns the tables and views in conn's database that are not
+// in SandboxTables.
+func hiddenTables(conn *sql.Conn) ([]string, error) {
+	rows, err := conn.QueryContext(context.Background(), "SELECT name FROM sqlite_master WHERE type IN ('table', 'view')")
+	if err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+	defer rows.Close()
+
+	var hidden []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("listing tables: %w", err)
+		}
+		if !slices.Contains(SandboxTables, name) {
+			hidden = append(hidden, strings.ToLower(name))
+		}
+	}
+	return hidden, rows.Err()
+}
+
+// sandboxAuthorizer is called by SQLite while preparing each statement. The
+// arguments depend on the action; see https://www.sqlite.org/c3ref/c_alter_table.html.
+// hidden lists the tables of the database queries may not read.
+func sandboxAuthorizer(hidden []string, action int, arg1, arg2, dbName string) int {
+	switch action {
+	case sqlite3.SQLITE_SELECT, sqliteRecursive:
+		return sqlite3.SQLITE_OK
+	case sqlite3.SQLITE_READ:
+		if dbName == "main" && slices.Contains(SandboxTables, arg1) {
+			return sqlite3.SQLITE_OK
+		}
+		// count(*) reports a read of a whole table or CTE by its name
+		// alone, so it is allowed for CTEs but not for hidden tables
+		name := strings.ToLower(arg1)
+		if dbName == "" && arg2 == "" && !strings.HasPrefix(name, "sqlite_") && !slices.Contains(hidden, name) {
+			return sqlite3.SQLITE_OK
+		}
+	case sqlite3.SQLITE_FUNCTION:
+		if !slices.Contains(deniedFunctions, strings.ToLower(arg2)) {
+			return sqlite3.SQLITE_OK
+		}
+	}
+	return sqlite3.SQLITE_DENY
+}
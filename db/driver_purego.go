@@ -0,0 +1,27 @@
+//go:build !cgo || purego
+
+package db
+
+import (
+	"database/sql"
+
+	"modernc.org/sqlite"
+)
+
+// driverName is the database/sql driver used for SQLite. Builds without cgo,
+// or with the purego tag, use the pure-Go modernc.org/sqlite driver.
+const driverName = "sqlite"
+
+// sqliteLimitAttached is SQLITE_LIMIT_ATTACHED.
+const sqliteLimitAttached = 7
+
+// hasAuthorizer reports whether sandboxConn restricts the tables a query may
+// read. modernc.org/sqlite has no authorizer API, so these builds always run
+// the sandbox on an in-memory copy holding only SandboxTables.
+const hasAuthorizer = false
+
+// sandboxConn disables ATTACH on conn.
+func sandboxConn(conn *sql.Conn) error {
+	_, err := sqlite.Limit(conn, sqliteLimitAttached, 0)
+	return err
+}
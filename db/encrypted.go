@@ -0,0 +1,259 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"basal/schedule"
+)
+
+const (
+	encryptedFormat  = "basal-encrypted"
+	encryptedVersion = 1
+	kdfPBKDF2SHA256  = "pbkdf2-sha256"
+
+	// pbkdf2Iterations follows current OWASP guidance for PBKDF2-HMAC-SHA256.
+	pbkdf2Iterations = 600000
+	saltSize         = 16
+	keySize          = 32 // AES-256
+)
+
+// ErrWrongSecret is returned when an encrypted store cannot be decrypted.
+var ErrWrongSecret = errors.New("wrong passphrase or key file, or the file is corrupted")
+
+// EncryptedStore is a Store kept in a file encrypted with AES-256-GCM. The
+// plaintext is the same document a JSONStore writes; it is only ever held in
+// memory. The key is derived from a passphrase or key file with PBKDF2.
+type EncryptedStore struct {
+	*MemoryStore
+	path       string
+	key        []byte
+	salt       []byte
+	iterations int
+}
+
+// encryptedEnvelope is the on-disk format of an encrypted store. Everything
+// except the ciphertext is authenticated as additional data.
+type encryptedEnvelope struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func (e *encryptedEnvelope) additionalData() []byte {
+	return fmt.Appendf(nil, "%s:%d:%s:%d:%x", e.Format, e.Version, e.KDF, e.Iterations, e.Salt)
+}
+
+// OpenEncryptedStore decrypts the store at path, starting empty if the file
+// does not exist. secret is called once to obtain the passphrase or key.
+func OpenEncryptedStore(path string, secret func() ([]byte, error)) (*EncryptedStore, error) {
+	if secret == nil {
+		return nil, fmt.Errorf("a passphrase or key file is required for the encrypted backend")
+	}
+
+	s := &EncryptedStore{MemoryStore: NewMemoryStore(), path: path, iterations: pbkdf2Iterations}
+
+	envelope, err := readEnvelope(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	pass, err := secret()
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+
+	if envelope == nil {
+		s.salt = make([]byte, saltSize)
+		if _, err := rand.Read(s.salt); err != nil {
+			return nil, fmt.Errorf("generating salt: %w", err)
+		}
+		if s.key, err = deriveKey(pass, s.salt, s.iterations); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	s.salt, s.iterations = envelope.Salt, envelope.Iterations
+	if s.key, err = deriveKey(pass, s.salt, s.iterations); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := s.open(envelope)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadJSON(plaintext); err != nil {
+		return nil, fmt.Errorf("parsing decrypted %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// readEnvelope reads and checks the envelope of an encrypted store without
+// decrypting it.
+func readEnvelope(path string) (*encryptedEnvelope, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope encryptedEnvelope
+	if err := json.Unmarshal(content, &envelope); err != nil || envelope.Format != encryptedFormat {
+		return nil, fmt.Errorf("%s is not an encrypted basal database", path)
+	}
+	if envelope.Version > encryptedVersion {
+		return nil, fmt.Errorf("%s was written by a newer version of basal (format %d)", path, envelope.Version)
+	}
+	if envelope.KDF != kdfPBKDF2SHA256 {
+		return nil, fmt.Errorf("%s uses unsupported key derivation %q", path, envelope.KDF)
+	}
+	return &envelope, nil
+}
+
+func deriveKey(secret, salt []byte, iterations int) ([]byte, error) {
+	key, err := pbkdf2.Key(sha256.New, string(secret), salt, iterations, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+	return key, nil
+}
+
+func (s *EncryptedStore) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *EncryptedStore) open(envelope *encryptedEnvelope) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, envelope.Nonce, envelope.Ciphertext, envelope.additionalData())
+	if err != nil {
+		return nil, ErrWrongSecret
+	}
+	return plaintext, nil
+}
+
+func (s *EncryptedStore) CreateBasalRecord(date time.Time, sched schedule.Schedule) (int64, error) {
+	var id int64
+	err := s.update(func(next *MemoryStore) (err error) {
+		id, err = next.CreateBasalRecord(date, sched)
+		return err
+	}, s.save)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *EncryptedStore) UpdateBasalRecord(id int64, date time.Time, sched schedule.Schedule) error {
+	return s.update(func(next *MemoryStore) error {
+		return next.UpdateBasalRecord(id, date, sched)
+	}, s.save)
+}
+
+func (s *EncryptedStore) DeleteBasalRecord(id int64) error {
+	return s.update(func(next *MemoryStore) error {
+		return next.DeleteBasalRecord(id)
+	}, s.save)
+}
+
+func (s *EncryptedStore) AddAskEntry(entry AskEntry) (int64, error) {
+	var id int64
+	err := s.update(func(next *MemoryStore) (err error) {
+		id, err = next.AddAskEntry(entry)
+		return err
+	}, s.save)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *EncryptedStore) NameAskEntry(id int64, name string) error {
+	return s.update(func(next *MemoryStore) error {
+		return next.NameAskEntry(id, name)
+	}, s.save)
+}
+
+func (s *EncryptedStore) DeleteAskEntry(id int64) error {
+	return s.update(func(next *MemoryStore) error {
+		return next.DeleteAskEntry(id)
+	}, s.save)
+}
+
+// save encrypts the contents of m with a fresh nonce and atomically replaces
+// the file.
+func (s *EncryptedStore) save(m *MemoryStore) error {
+	plaintext, err := m.marshalJSON()
+	if err != nil {
+		return err
+	}
+
+	aead, err := s.aead()
+	if err != nil {
+		return err
+	}
+
+	envelope := encryptedEnvelope{
+		Format:     encryptedFormat,
+		Version:    encryptedVersion,
+		KDF:        kdfPBKDF2SHA256,
+		Iterations: s.iterations,
+		Salt:       s.salt,
+		Nonce:      make([]byte, aead.NonceSize()),
+	}
+	if _, err := rand.Read(envelope.Nonce); err != nil {
+		return fmt.Errorf("generating nonce: %w", err)
+	}
+	envelope.Ciphertext = aead.Seal(nil, envelope.Nonce, plaintext, envelope.additionalData())
+
+	content, err := json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding envelope: %w", err)
+	}
+	return writeFileAtomic(s.path, content, 0600)
+}
+
+// backupEncrypted copies an encrypted store. Without the key only the envelope
+// can be checked, so the copy is compared byte for byte with the original.
+func backupEncrypted(src, dst string) error {
+	if _, err := readEnvelope(src); err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(dst, content, 0600); err != nil {
+		return err
+	}
+
+	written, err := os.ReadFile(dst)
+	if err != nil || string(written) != string(content) {
+		os.Remove(dst)
+		return fmt.Errorf("verifying backup: copy does not match %s", src)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func passphrase(s string) func() ([]byte, error) {
+	return func() ([]byte, error) { return []byte(s), nil }
+}
+
+// writeEncryptedStore creates an encrypted store at path holding one record.
+func writeEncryptedStore(t *testing.T, path string, secret func() ([]byte, error)) {
+	t.Helper()
+	store, err := OpenEncryptedStore(path, secret)
+	if err != nil {
+		t.Fatalf("OpenEncryptedStore: %v", err)
+	}
+	if _, err := store.CreateBasalRecord(day("2024-01-01"), testSchedule(0.8)); err != nil {
+		t.Fatalf("CreateBasalRecord: %v", err)
+	}
+	store.Close()
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "basal.enc")
+	writeEncryptedStore(t, path, passphrase("correct horse"))
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plain := range []string{"2024-01-01", "total_units", "units_per_hour"} {
+		if bytes.Contains(content, []byte(plain)) {
+			t.Errorf("the file holds %q in the clear", plain)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("file mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+
+	store, err := OpenEncryptedStore(path, passphrase("correct horse"))
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer store.Close()
+	record, sched, err := store.GetBasalRecordByDate(day("2024-02-01"))
+	if err != nil {
+		t.Fatalf("GetBasalRecordByDate: %v", err)
+	}
+	if !record.Date.Equal(day("2024-01-01")) || !reflect.DeepEqual(sched, testSchedule(0.8)) {
+		t.Errorf("got %v %v after reopening", record.Date, sched)
+	}
+}
+
+func TestEncryptedStoreWrongPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "basal.enc")
+	writeEncryptedStore(t, path, passphrase("correct horse"))
+
+	if _, err := OpenEncryptedStore(path, passphrase("battery staple")); !errors.Is(err, ErrWrongSecret) {
+		t.Errorf("err = %v, want ErrWrongSecret", err)
+	}
+	if _, err := OpenEncryptedStore(path, passphrase("")); err == nil {
+		t.Error("an empty passphrase was accepted")
+	}
+	if _, err := OpenEncryptedStore(path, nil); err == nil {
+		t.Error("opened without a secret")
+	}
+}
+
+func TestEncryptedStoreDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(e *encryptedEnvelope)
+	}{
+		{"ciphertext", func(e *encryptedEnvelope) { e.Ciphertext[0] ^= 1 }},
+		{"nonce", func(e *encryptedEnvelope) { e.Nonce[0] ^= 1 }},
+		{"truncated", func(e *encryptedEnvelope) { e.Ciphertext = e.Ciphertext[:len(e.Ciphertext)-1] }},
+		// Authenticated as additional data, so a downgrade is caught
+		{"version", func(e *encryptedEnvelope) { e.Version = 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "basal.enc")
+			writeEncryptedStore(t, path, passphrase("correct horse"))
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var envelope encryptedEnvelope
+			if err := json.Unmarshal(content, &envelope); err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(&envelope)
+			if content, err = json.Marshal(envelope); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, content, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := OpenEncryptedStore(path, passphrase("correct horse")); !errors.Is(err, ErrWrongSecret) {
+				t.Errorf("err = %v, want ErrWrongSecret", err)
+			}
+		})
+	}
+}
+
+func TestEncryptedStoreKeyFileAndPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{0x5a}, 32)
+	keyed := filepath.Join(dir, "keyed.enc")
+	writeEncryptedStore(t, keyed, func() ([]byte, error) { return key, nil })
+
+	if _, err := OpenEncryptedStore(keyed, func() ([]byte, error) { return key, nil }); err != nil {
+		t.Errorf("opening with the key: %v", err)
+	}
+	if _, err := OpenEncryptedStore(keyed, passphrase("correct horse")); !errors.Is(err, ErrWrongSecret) {
+		t.Errorf("opening a key file store with a passphrase: err = %v, want ErrWrongSecret", err)
+	}
+
+	// The same secret with a fresh salt gives a different file
+	other := filepath.Join(dir, "other.enc")
+	writeEncryptedStore(t, other, func() ([]byte, error) { return key, nil })
+	a, _ := readEnvelope(keyed)
+	b, _ := readEnvelope(other)
+	if bytes.Equal(a.Salt, b.Salt) || bytes.Equal(a.Ciphertext, b.Ciphertext) {
+		t.Error("two stores share a salt or ciphertext")
+	}
+}
+
+func TestEncryptedStoreRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "basal.json")
+	if err := os.WriteFile(path, []byte(`{"records": []}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenEncryptedStore(path, passphrase("correct horse")); err == nil || errors.Is(err, ErrWrongSecret) {
+		t.Errorf("err = %v, want a not-encrypted error", err)
+	}
+}
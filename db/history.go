@@ -0,0 +1,256 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AskEntry is a question answered by 'basal ask', kept so that it can be
+// looked up or run again later.
+type AskEntry struct {
+	ID        int64
+	Question  string
+	SQL       string // empty if the answer came from tool calls
+	Columns   []string
+	Rows      [][]string
+	Model     string
+	Latency   time.Duration
+	Answer    string
+	Name      string // set when saved as a named report
+	CacheKey  string // identifies the question and prompt the SQL answers
+	CreatedAt time.Time
+}
+
+// ErrNoAskEntry is returned when an ask history entry does not exist.
+var ErrNoAskEntry = fmt.Errorf("no such ask history entry")
+
+// AddAskEntry stores entry in the ask_history table and returns its ID. The ID
+// and creation time of entry are kept if set, which is how CopyStore copies
+// history between stores. A named entry takes the name from any older one.
+func AddAskEntry(db *sql.DB, entry AskEntry) (int64, error) {
+	columns, err := json.Marshal(entry.Columns)
+	if err != nil {
+		return 0, fmt.Errorf("encoding columns: %w", err)
+	}
+	rows, err := json.Marshal(entry.Rows)
+	if err != nil {
+		return 0, fmt.Errorf("encoding rows: %w", err)
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if entry.Name != "" {
+		if _, err := tx.Exec("UPDATE ask_history SET name = NULL WHERE name = ?", entry.Name); err != nil {
+			return 0, fmt.Errorf("clearing old name: %w", err)
+		}
+	}
+	result, err := tx.Exec(`
+		INSERT INTO ask_history (
+			id, question, sql_query, result_columns, result_rows,
+			model, latency_ms, answer, name, cache_key, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullableID(entry.ID),
+		entry.Question,
+		entry.SQL,
+		string(columns),
+		string(rows),
+		entry.Model,
+		entry.Latency.Milliseconds(),
+		entry.Answer,
+		nullableName(entry.Name),
+		entry.CacheKey,
+		entry.CreatedAt.UTC().Format(timestampFormat),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("inserting ask history entry: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// ListAskEntries returns the ask history, newest first.
+func ListAskEntries(db *sql.DB) ([]AskEntry, error) {
+	rows, err := db.Query(askEntryQuery + " ORDER BY id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("reading ask history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AskEntry
+	for rows.Next() {
+		entry, err := scanAskEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, rows.Err()
+}
+
+// GetAskEntry returns an ask history entry by ID.
+func GetAskEntry(db *sql.DB, id int64) (*AskEntry, error) {
+	entry, err := scanAskEntry(db.QueryRow(askEntryQuery+" WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrNoAskEntry, id)
+	}
+	return entry, err
+}
+
+// GetAskEntryByName returns the ask history entry saved as name.
+func GetAskEntryByName(db *sql.DB, name string) (*AskEntry, error) {
+	entry, err := scanAskEntry(db.QueryRow(askEntryQuery+" WHERE name = ?", name))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %q", ErrNoAskEntry, name)
+	}
+	return entry, err
+}
+
+// GetAskEntryByCacheKey returns the newest ask history entry with a query
+// stored under key.
+func GetAskEntryByCacheKey(db *sql.DB, key string) (*AskEntry, error) {
+	entry, err := scanAskEntry(db.QueryRow(askEntryQuery+`
+		WHERE cache_key = ? AND sql_query != ''
+		ORDER BY id DESC
+		LIMIT 1`, key))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoAskEntry
+	}
+	return entry, err
+}
+
+// NameAskEntry saves the entry id as a report called name. Names are unique:
+// an older entry with the same name loses it.
+func NameAskEntry(db *sql.DB, id int64, name string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE ask_history SET name = NULL WHERE name = ?", name); err != nil {
+		return fmt.Errorf("clearing old name: %w", err)
+	}
+	result, err := tx.Exec("UPDATE ask_history SET name = ? WHERE id = ?", name, id)
+	if err != nil {
+		return fmt.Errorf("naming ask history entry: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: %d", ErrNoAskEntry, id)
+	}
+
+	return tx.Commit()
+}
+
+// DeleteAskEntry removes an ask history entry.
+func DeleteAskEntry(db *sql.DB, id int64) error {
+	result, err := db.Exec("DELETE FROM ask_history WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("deleting ask history entry: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: %d", ErrNoAskEntry, id)
+	}
+	return nil
+}
+
+const askEntryQuery = `
+	SELECT id, question, sql_query, result_columns, result_rows, model,
+	       latency_ms, answer, COALESCE(name, ''), cache_key,
+	       strftime('%Y-%m-%d %H:%M:%S', created_at)
+	FROM ask_history`
+
+// scanAskEntry reads a row selected by askEntryQuery.
+func scanAskEntry(row interface{ Scan(...any) error }) (*AskEntry, error) {
+	var entry AskEntry
+	var columns, rows, created string
+	var latency int64
+	err := row.Scan(
+		&entry.ID,
+		&entry.Question,
+		&entry.SQL,
+		&columns,
+		&rows,
+		&entry.Model,
+		&latency,
+		&entry.Answer,
+		&entry.Name,
+		&entry.CacheKey,
+		&created,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(columns), &entry.Columns); err != nil {
+		return nil, fmt.Errorf("ask history entry %d columns: %w", entry.ID, err)
+	}
+	if err := json.Unmarshal([]byte(rows), &entry.Rows); err != nil {
+		return nil, fmt.Errorf("ask history entry %d rows: %w", entry.ID, err)
+	}
+	entry.Latency = time.Duration(latency) * time.Millisecond
+	entry.CreatedAt, err = time.Parse(timestampFormat, created)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// nullableID lets SQLite choose the ID of a new row when id is zero.
+func nullableID(id int64) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// nullableName stores an unnamed entry with a NULL name, which the UNIQUE
+// constraint allows any number of.
+func nullableName(name string) any {
+	if name == "" {
+		return nil
+	}
+	return name
+}
+
+// migrateAskHistory adds the table that keeps questions answered by 'basal ask'.
+func migrateAskHistory(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS ask_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		question TEXT NOT NULL,
+		sql_query TEXT NOT NULL,       -- empty if answered with tool calls
+		result_columns TEXT NOT NULL,  -- JSON array
+		result_rows TEXT NOT NULL,     -- JSON array of arrays
+		model TEXT NOT NULL,
+		latency_ms INTEGER NOT NULL,
+		answer TEXT NOT NULL,
+		name TEXT UNIQUE,              -- set for saved reports
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return err
+}
+
+// migrateAskCacheKey lets 'basal ask' reuse the query of an earlier answer.
+func migrateAskCacheKey(tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE ask_history ADD COLUMN cache_key TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("CREATE INDEX IF NOT EXISTS ask_history_cache_key ON ask_history (cache_key)")
+	return err
+}
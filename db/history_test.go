@@ -0,0 +1,113 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// openAtVersion creates a database at path with the schema after the first
+// version migrations and returns it open.
+func openAtVersion(t *testing.T, path string, version int) *sql.DB {
+	t.Helper()
+	database, err := sql.Open(driverName, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Exec(legacySchema); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations[:version] {
+		tx, err := database.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.apply(tx); err != nil {
+			t.Fatalf("%s: %v", m.description, err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := database.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func TestMigrateAskCacheKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "basal.db")
+	old := openAtVersion(t, path, 2)
+	_, err := old.Exec(`
+		INSERT INTO ask_history (question, sql_query, result_columns, result_rows, model, latency_ms, answer, name)
+		VALUES ('highest total', 'SELECT 1', '["n"]', '[["1"]]', 'llama3', 1200, 'one', 'peak')`)
+	if err != nil {
+		t.Fatalf("inserting into the version 2 ask_history: %v", err)
+	}
+	old.Close()
+
+	database, err := InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer database.Close()
+
+	// The existing entry is kept, with an empty key
+	entry, err := GetAskEntryByName(database, "peak")
+	if err != nil {
+		t.Fatalf("GetAskEntryByName: %v", err)
+	}
+	if entry.Question != "highest total" || entry.SQL != "SELECT 1" || entry.CacheKey != "" {
+		t.Errorf("migrated entry = %+v", entry)
+	}
+
+	id, err := AddAskEntry(database, AskEntry{Question: "q", SQL: "SELECT 2", Model: "m", CacheKey: "key"})
+	if err != nil {
+		t.Fatalf("AddAskEntry: %v", err)
+	}
+	if entry, err := GetAskEntryByCacheKey(database, "key"); err != nil || entry.ID != id {
+		t.Errorf("GetAskEntryByCacheKey = %+v, %v; want entry %d", entry, err, id)
+	}
+
+	var index string
+	err = database.QueryRow("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'ask_history' AND name = 'ask_history_cache_key'").Scan(&index)
+	if err != nil {
+		t.Errorf("cache key index: %v", err)
+	}
+}
+
+func TestAddAskEntryTakesName(t *testing.T) {
+	database := openTestDB(t)
+	first, err := AddAskEntry(database, AskEntry{Question: "first", SQL: "SELECT 1", Model: "m", Name: "report"})
+	if err != nil {
+		t.Fatalf("AddAskEntry: %v", err)
+	}
+	second, err := AddAskEntry(database, AskEntry{Question: "second", SQL: "SELECT 2", Model: "m", Name: "report"})
+	if err != nil {
+		t.Fatalf("AddAskEntry with a taken name: %v", err)
+	}
+
+	if entry, err := GetAskEntryByName(database, "report"); err != nil || entry.ID != second {
+		t.Errorf("GetAskEntryByName = %+v, %v; want entry %d", entry, err, second)
+	}
+	if entry, err := GetAskEntry(database, first); err != nil || entry.Name != "" {
+		t.Errorf("first entry = %+v, %v; want it without a name", entry, err)
+	}
+
+	// A failed insert leaves the old name in place
+	if _, err := AddAskEntry(database, AskEntry{ID: second, Question: "dup", Model: "m", Name: "report"}); err == nil {
+		t.Fatal("AddAskEntry reused an ID")
+	}
+	if entry, err := GetAskEntryByName(database, "report"); err != nil || entry.ID != second {
+		t.Errorf("after a failed insert GetAskEntryByName = %+v, %v; want entry %d", entry, err, second)
+	}
+
+	if err := DeleteAskEntry(database, second); err != nil {
+		t.Fatalf("DeleteAskEntry: %v", err)
+	}
+	if err := DeleteAskEntry(database, second); !errors.Is(err, ErrNoAskEntry) {
+		t.Errorf("deleting twice: %v, want ErrNoAskEntry", err)
+	}
+}
@@ -0,0 +1,293 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"basal/schedule"
+)
+
+// jsonFileVersion is the format version written to JSON store files.
+const jsonFileVersion = 1
+
+// JSONStore is a Store kept in a plain JSON file. The whole file is loaded on
+// open and rewritten atomically after every change, which is fine for the
+// handful of records a person accumulates and needs no cgo.
+type JSONStore struct {
+	*MemoryStore
+	path string
+}
+
+type jsonFile struct {
+	Version    int            `json:"version"`
+	NextID     int64          `json:"next_id"`
+	Records    []jsonRecord   `json:"records"`
+	NextAskID  int64          `json:"next_ask_id,omitempty"`
+	AskHistory []jsonAskEntry `json:"ask_history,omitempty"`
+}
+
+type jsonRecord struct {
+	ID         int64          `json:"id"`
+	Date       string         `json:"date"`
+	TotalUnits float64        `json:"total_units"`
+	CreatedAt  time.Time      `json:"created_at"`
+	Intervals  []jsonInterval `json:"intervals"`
+}
+
+type jsonInterval struct {
+	StartSeconds int     `json:"start_seconds"`
+	EndSeconds   int     `json:"end_seconds"` // 0 means the end of the day
+	UnitsPerHour float64 `json:"units_per_hour"`
+}
+
+type jsonAskEntry struct {
+	ID        int64      `json:"id"`
+	Question  string     `json:"question"`
+	SQL       string     `json:"sql,omitempty"`
+	Columns   []string   `json:"columns,omitempty"`
+	Rows      [][]string `json:"rows,omitempty"`
+	Model     string     `json:"model"`
+	LatencyMS int64      `json:"latency_ms"`
+	Answer    string     `json:"answer"`
+	Name      string     `json:"name,omitempty"`
+	CacheKey  string     `json:"cache_key,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// OpenJSONStore loads the JSON store at path, starting empty if the file does not exist.
+func OpenJSONStore(path string) (*JSONStore, error) {
+	s := &JSONStore{MemoryStore: NewMemoryStore(), path: path}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	if err := s.loadJSON(content); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// loadJSON replaces the contents of the store with records encoded by marshalJSON.
+func (s *MemoryStore) loadJSON(content []byte) error {
+	var file jsonFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return err
+	}
+	if file.Version > jsonFileVersion {
+		return fmt.Errorf("written by a newer version of basal (format %d)", file.Version)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = make(map[int64]*memoryRecord, len(file.Records))
+	s.nextID = 1
+	for _, jr := range file.Records {
+		date, err := time.Parse(DateFormat, jr.Date)
+		if err != nil {
+			return fmt.Errorf("record %d: %w", jr.ID, err)
+		}
+
+		sched := make(schedule.Schedule, len(jr.Intervals))
+		for i, ji := range jr.Intervals {
+			sched[i] = schedule.Segment{
+				StartTime:    schedule.TimeOfDay(ji.StartSeconds),
+				EndTime:      schedule.TimeOfDay(ji.EndSeconds),
+				UnitsPerHour: ji.UnitsPerHour,
+			}
+		}
+		if err := sched.Validate(); err != nil {
+			return fmt.Errorf("record %d: %w", jr.ID, err)
+		}
+
+		s.records[jr.ID] = &memoryRecord{
+			record: BasalRecord{
+				ID:         jr.ID,
+				Date:       date,
+				TotalUnits: jr.TotalUnits,
+				CreatedAt:  jr.CreatedAt,
+			},
+			schedule: sched,
+		}
+		if jr.ID >= s.nextID {
+			s.nextID = jr.ID + 1
+		}
+	}
+	if file.NextID > s.nextID {
+		s.nextID = file.NextID
+	}
+
+	s.history = nil
+	s.nextAskID = 1
+	for _, je := range file.AskHistory {
+		s.addAskEntry(AskEntry{
+			ID:        je.ID,
+			Question:  je.Question,
+			SQL:       je.SQL,
+			Columns:   je.Columns,
+			Rows:      je.Rows,
+			Model:     je.Model,
+			Latency:   time.Duration(je.LatencyMS) * time.Millisecond,
+			Answer:    je.Answer,
+			Name:      je.Name,
+			CacheKey:  je.CacheKey,
+			CreatedAt: je.CreatedAt,
+		})
+	}
+	if file.NextAskID > s.nextAskID {
+		s.nextAskID = file.NextAskID
+	}
+
+	return nil
+}
+
+// marshalJSON encodes every record in the store, oldest first, and the ask history.
+func (s *MemoryStore) marshalJSON() ([]byte, error) {
+	s.mu.RLock()
+	file := jsonFile{Version: jsonFileVersion, NextID: s.nextID}
+	records := s.sortedRecords()
+	for i := len(records) - 1; i >= 0; i-- {
+		r := s.records[records[i].ID]
+		jr := jsonRecord{
+			ID:         r.record.ID,
+			Date:       r.record.Date.Format(DateFormat),
+			TotalUnits: r.record.TotalUnits,
+			CreatedAt:  r.record.CreatedAt,
+		}
+		for _, seg := range r.schedule {
+			jr.Intervals = append(jr.Intervals, jsonInterval{
+				StartSeconds: seg.StartTime.Seconds(),
+				EndSeconds:   seg.EndTime.Seconds(),
+				UnitsPerHour: seg.UnitsPerHour,
+			})
+		}
+		file.Records = append(file.Records, jr)
+	}
+	// Kept once the history has been used, so IDs of deleted entries are not reused
+	if s.nextAskID > 1 {
+		file.NextAskID = s.nextAskID
+	}
+	for _, entry := range s.history {
+		file.AskHistory = append(file.AskHistory, jsonAskEntry{
+			ID:        entry.ID,
+			Question:  entry.Question,
+			SQL:       entry.SQL,
+			Columns:   entry.Columns,
+			Rows:      entry.Rows,
+			Model:     entry.Model,
+			LatencyMS: entry.Latency.Milliseconds(),
+			Answer:    entry.Answer,
+			Name:      entry.Name,
+			CacheKey:  entry.CacheKey,
+			CreatedAt: entry.CreatedAt,
+		})
+	}
+	s.mu.RUnlock()
+
+	content, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encoding records: %w", err)
+	}
+	return content, nil
+}
+
+func (s *JSONStore) CreateBasalRecord(date time.Time, sched schedule.Schedule) (int64, error) {
+	var id int64
+	err := s.update(func(next *MemoryStore) (err error) {
+		id, err = next.CreateBasalRecord(date, sched)
+		return err
+	}, s.save)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *JSONStore) UpdateBasalRecord(id int64, date time.Time, sched schedule.Schedule) error {
+	return s.update(func(next *MemoryStore) error {
+		return next.UpdateBasalRecord(id, date, sched)
+	}, s.save)
+}
+
+func (s *JSONStore) DeleteBasalRecord(id int64) error {
+	return s.update(func(next *MemoryStore) error {
+		return next.DeleteBasalRecord(id)
+	}, s.save)
+}
+
+func (s *JSONStore) AddAskEntry(entry AskEntry) (int64, error) {
+	var id int64
+	err := s.update(func(next *MemoryStore) (err error) {
+		id, err = next.AddAskEntry(entry)
+		return err
+	}, s.save)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *JSONStore) NameAskEntry(id int64, name string) error {
+	return s.update(func(next *MemoryStore) error {
+		return next.NameAskEntry(id, name)
+	}, s.save)
+}
+
+func (s *JSONStore) DeleteAskEntry(id int64) error {
+	return s.update(func(next *MemoryStore) error {
+		return next.DeleteAskEntry(id)
+	}, s.save)
+}
+
+// save writes the contents of m to a temporary file and renames it over the
+// original, so a crash never leaves a half-written file behind.
+func (s *JSONStore) save(m *MemoryStore) error {
+	content, err := m.marshalJSON()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, content, 0600)
+}
+
+// writeFileAtomic writes content to a temporary file next to path and renames
+// it into place once it has been flushed to disk.
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncing temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temporary file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return fmt.Errorf("setting permissions: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"basal/schedule"
+)
+
+// MemoryStore is a Store that keeps records in memory. It is mainly useful
+// for tests and as the basis of file-backed stores.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[int64]*memoryRecord
+	nextID  int64
+
+	history   []AskEntry // oldest first
+	nextAskID int64
+
+	writeMu sync.Mutex // serializes update
+}
+
+type memoryRecord struct {
+	record   BasalRecord
+	schedule schedule.Schedule
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records:   make(map[int64]*memoryRecord),
+		nextID:    1,
+		nextAskID: 1,
+	}
+}
+
+func (s *MemoryStore) CreateBasalRecord(date time.Time, sched schedule.Schedule) (int64, error) {
+	if err := sched.Validate(); err != nil {
+		return 0, fmt.Errorf("invalid schedule: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	s.records[id] = &memoryRecord{
+		record: BasalRecord{
+			ID:         id,
+			Date:       truncateDate(date),
+			TotalUnits: sched.TotalUnits(),
+			CreatedAt:  time.Now().UTC().Truncate(time.Second),
+		},
+		schedule: cloneSchedule(sched),
+	}
+	return id, nil
+}
+
+func (s *MemoryStore) GetBasalRecord(id int64) (*BasalRecord, schedule.Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.records[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("record with ID %d not found", id)
+	}
+	record := r.record
+	return &record, cloneSchedule(r.schedule), nil
+}
+
+func (s *MemoryStore) GetBasalRecordByDate(date time.Time) (*BasalRecord, schedule.Schedule, error) {
+	s.mu.RLock()
+	var found *memoryRecord
+	if len(s.records) > 0 {
+		found = s.records[effectiveRecordID(s.sortedRecords(), truncateDate(date))]
+	}
+	s.mu.RUnlock()
+
+	if found == nil {
+		return nil, nil, ErrNoRecords
+	}
+	return s.GetBasalRecord(found.record.ID)
+}
+
+func (s *MemoryStore) ListBasalRecords() ([]BasalRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sortedRecords(), nil
+}
+
+func (s *MemoryStore) UpdateBasalRecord(id int64, date time.Time, sched schedule.Schedule) error {
+	if err := sched.Validate(); err != nil {
+		return fmt.Errorf("invalid schedule: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[id]
+	if !ok {
+		return fmt.Errorf("record with ID %d not found", id)
+	}
+	r.record.Date = truncateDate(date)
+	r.record.TotalUnits = sched.TotalUnits()
+	r.schedule = cloneSchedule(sched)
+	return nil
+}
+
+func (s *MemoryStore) DeleteBasalRecord(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[id]; !ok {
+		return fmt.Errorf("record with ID %d not found", id)
+	}
+	delete(s.records, id)
+	return nil
+}
+
+func (s *MemoryStore) AddAskEntry(entry AskEntry) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addAskEntry(entry), nil
+}
+
+// addAskEntry appends entry to the history, keeping its ID and creation time
+// if set. The caller must hold s.mu.
+func (s *MemoryStore) addAskEntry(entry AskEntry) int64 {
+	if entry.ID == 0 {
+		entry.ID = s.nextAskID
+	}
+	if entry.ID >= s.nextAskID {
+		s.nextAskID = entry.ID + 1
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	}
+	if entry.Name != "" {
+		s.clearAskName(entry.Name)
+	}
+	s.history = append(s.history, cloneAskEntry(entry))
+	return entry.ID
+}
+
+func (s *MemoryStore) ListAskEntries() ([]AskEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]AskEntry, len(s.history))
+	for i, entry := range s.history {
+		entries[len(entries)-1-i] = cloneAskEntry(entry)
+	}
+	return entries, nil
+}
+
+func (s *MemoryStore) GetAskEntry(id int64) (*AskEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, entry := range s.history {
+		if entry.ID == id {
+			entry = cloneAskEntry(entry)
+			return &entry, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d", ErrNoAskEntry, id)
+}
+
+func (s *MemoryStore) GetAskEntryByName(name string) (*AskEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, entry := range s.history {
+		if entry.Name == name {
+			entry = cloneAskEntry(entry)
+			return &entry, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNoAskEntry, name)
+}
+
+func (s *MemoryStore) GetAskEntryByCacheKey(key string) (*AskEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if entry := s.history[i]; entry.CacheKey == key && entry.SQL != "" {
+			entry = cloneAskEntry(entry)
+			return &entry, nil
+		}
+	}
+	return nil, ErrNoAskEntry
+}
+
+func (s *MemoryStore) NameAskEntry(id int64, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.history {
+		if s.history[i].ID == id {
+			s.clearAskName(name)
+			s.history[i].Name = name
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d", ErrNoAskEntry, id)
+}
+
+func (s *MemoryStore) DeleteAskEntry(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.history {
+		if s.history[i].ID == id {
+			s.history = slices.Delete(s.history, i, i+1)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d", ErrNoAskEntry, id)
+}
+
+// clearAskName removes name from the entry that has it. The caller must hold s.mu.
+func (s *MemoryStore) clearAskName(name string) {
+	for i := range s.history {
+		if s.history[i].Name == name {
+			s.history[i].Name = ""
+		}
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// update applies change to a copy of the store and passes the copy to
+// persist. The copy replaces the contents of the store only if both succeed,
+// so file-backed stores never hold changes their file is missing.
+func (s *MemoryStore) update(change, persist func(next *MemoryStore) error) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	next := s.clone()
+	if err := change(next); err != nil {
+		return err
+	}
+	if err := persist(next); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.records, s.nextID = next.records, next.nextID
+	s.history, s.nextAskID = next.history, next.nextAskID
+	s.mu.Unlock()
+	return nil
+}
+
+// clone returns a copy of the store. Schedules and result rows are shared,
+// as they are replaced rather than modified.
+func (s *MemoryStore) clone() *MemoryStore {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c := &MemoryStore{
+		records:   make(map[int64]*memoryRecord, len(s.records)),
+		nextID:    s.nextID,
+		history:   append([]AskEntry(nil), s.history...),
+		nextAskID: s.nextAskID,
+	}
+	for id, r := range s.records {
+		copied := *r
+		c.records[id] = &copied
+	}
+	return c
+}
+
+// sortedRecords returns copies of all records, newest date first. The caller
+// must hold s.mu.
+func (s *MemoryStore) sortedRecords() []BasalRecord {
+	records := make([]BasalRecord, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r.record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].Date.Equal(records[j].Date) {
+			return records[i].Date.After(records[j].Date)
+		}
+		return records[i].ID > records[j].ID
+	})
+	return records
+}
+
+// effectiveRecordID picks the record in effect on date from records sorted
+// newest first, matching GetBasalRecordByDate: the latest record on or before
+// date, otherwise the earliest record.
+func effectiveRecordID(records []BasalRecord, date time.Time) int64 {
+	for _, r := range records {
+		if !r.Date.After(date) {
+			return r.ID
+		}
+	}
+	earliest := records[len(records)-1]
+	for _, r := range records {
+		if r.Date.Equal(earliest.Date) && r.ID < earliest.ID {
+			earliest = r
+		}
+	}
+	return earliest.ID
+}
+
+// truncateDate drops the time of day, keeping the calendar date.
+func truncateDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func cloneSchedule(sched schedule.Schedule) schedule.Schedule {
+	return append(schedule.Schedule(nil), sched...)
+}
+
+func cloneAskEntry(entry AskEntry) AskEntry {
+	entry.Columns = append([]string(nil), entry.Columns...)
+	rows := make([][]string, len(entry.Rows))
+	for i, row := range entry.Rows {
+		rows[i] = append([]string(nil), row...)
+	}
+	entry.Rows = rows
+	return entry
+}
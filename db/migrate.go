@@ -0,0 +1,215 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"basal/schedule"
+)
+
+// migration upgrades the database schema by one version.
+type migration struct {
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+// migrations are applied in order; the schema version stored in
+// PRAGMA user_version is the number of migrations already applied.
+var migrations = []migration{
+	{"store interval times as integer seconds after midnight", migrateIntervalSeconds},
+	{"add the ask history table", migrateAskHistory},
+	{"add the ask cache key", migrateAskCacheKey},
+}
+
+// migrate brings the database up to the latest schema version. Views are
+// dropped first, since SQLite refuses to rename a table a view depends on,
+// and InitDB creates them again afterwards.
+func migrate(db *sql.DB) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version < len(migrations) {
+		if err := dropViews(db); err != nil {
+			return err
+		}
+	}
+
+	for i := version; i < len(migrations); i++ {
+		m := migrations[i]
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("beginning transaction: %w", err)
+		}
+
+		if err := m.apply(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %w", m.description, err)
+		}
+
+		// PRAGMA statements do not accept bound parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("updating schema version: %w", err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("committing migration: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// createViews replaces the views of viewSchema in db with their current
+// definitions.
+func createViews(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, view := range schemaViews {
+		if _, err := tx.Exec("DROP VIEW IF EXISTS " + view); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("dropping view %s: %w", view, err)
+		}
+	}
+	if _, err := tx.Exec(viewSchema); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// dropViews removes the views of viewSchema from db.
+func dropViews(db *sql.DB) error {
+	for _, view := range schemaViews {
+		if _, err := db.Exec("DROP VIEW IF EXISTS " + view); err != nil {
+			return fmt.Errorf("dropping view %s: %w", view, err)
+		}
+	}
+	return nil
+}
+
+// schemaVersion returns the number of migrations already applied to db.
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+// hasColumn reports whether table has a column with the given name.
+func hasColumn(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// migrateIntervalSeconds rewrites basal_intervals from TEXT "HH:MM" columns to
+// integer offsets from midnight. Rows that do not parse abort the migration.
+func migrateIntervalSeconds(tx *sql.Tx) error {
+	legacy, err := hasColumn(tx, "basal_intervals", "start_time")
+	if err != nil {
+		return fmt.Errorf("inspecting basal_intervals: %w", err)
+	}
+	if !legacy {
+		return nil
+	}
+
+	type legacyInterval struct {
+		id, recordID     sql.NullInt64
+		start, end       string
+		unitsPerHour     float64
+		startTOD, endTOD schedule.TimeOfDay
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, basal_record_id, start_time, end_time, units_per_hour
+		FROM basal_intervals
+		ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("reading intervals: %w", err)
+	}
+
+	var intervals []legacyInterval
+	for rows.Next() {
+		var li legacyInterval
+		if err := rows.Scan(&li.id, &li.recordID, &li.start, &li.end, &li.unitsPerHour); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning interval: %w", err)
+		}
+		if li.startTOD, err = schedule.ParseTimeOfDay(li.start); err != nil {
+			rows.Close()
+			return fmt.Errorf("interval %d start time: %w", li.id.Int64, err)
+		}
+		if li.endTOD, err = schedule.ParseTimeOfDay(li.end); err != nil {
+			rows.Close()
+			return fmt.Errorf("interval %d end time: %w", li.id.Int64, err)
+		}
+		intervals = append(intervals, li)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading intervals: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+	CREATE TABLE basal_intervals_new (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		basal_record_id INTEGER,
+		start_seconds INTEGER NOT NULL,
+		end_seconds INTEGER NOT NULL,
+		units_per_hour REAL NOT NULL,
+		FOREIGN KEY (basal_record_id) REFERENCES basal_records(id),
+		CHECK (start_seconds >= 0 AND start_seconds < 86400),
+		CHECK (end_seconds >= 0 AND end_seconds < 86400)
+	)`); err != nil {
+		return fmt.Errorf("creating new intervals table: %w", err)
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO basal_intervals_new (
+			id, basal_record_id, start_seconds, end_seconds, units_per_hour
+		) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("preparing interval statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, li := range intervals {
+		if _, err := stmt.Exec(li.id, li.recordID, li.startTOD, li.endTOD, li.unitsPerHour); err != nil {
+			return fmt.Errorf("copying interval %d: %w", li.id.Int64, err)
+		}
+	}
+
+	if _, err := tx.Exec("DROP TABLE basal_intervals"); err != nil {
+		return fmt.Errorf("dropping old intervals table: %w", err)
+	}
+	if _, err := tx.Exec("ALTER TABLE basal_intervals_new RENAME TO basal_intervals"); err != nil {
+		return fmt.Errorf("renaming intervals table: %w", err)
+	}
+
+	return nil
+}
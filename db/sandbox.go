@@ -0,0 +1,317 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Defaults for queries run in a Sandbox.
+const (
+	DefaultMaxRows      = 1000
+	DefaultQueryTimeout = 5 * time.Second
+)
+
+// SandboxTables lists the only tables and views sandboxed queries may read.
+var SandboxTables = []string{"basal_records", "basal_intervals", "effective_schedule", "daily_basal"}
+
+// deniedFunctions are SQL functions sandboxed queries may not call, even
+// where the build of SQLite in use provides them.
+var deniedFunctions = []string{"load_extension", "readfile", "writefile", "edit", "fts3_tokenizer"}
+
+// Sandbox runs untrusted, read-only SQL such as queries written by an LLM.
+// It uses its own connection, opened read-only with PRAGMA query_only and
+// with ATTACH disabled. On cgo builds an authorizer only permits reading
+// SandboxTables; other builds query an in-memory copy that holds nothing
+// else. Queries are limited to a single SELECT statement, MaxRows rows and
+// Timeout.
+type Sandbox struct {
+	MaxRows int
+	Timeout time.Duration
+
+	db    *sql.DB
+	conn  *sql.Conn
+	owner *sql.DB // keeps an in-memory copy alive; nil for SQLite stores
+}
+
+// QueryResult holds the rows returned by a sandboxed query, as text.
+type QueryResult struct {
+	Columns   []string
+	Rows      [][]string
+	Truncated bool // more than MaxRows rows matched
+}
+
+// OpenSandbox returns a Sandbox over the contents of store. Where the driver
+// has an authorizer, SQLite stores are opened again read-only; other stores,
+// and every store on builds without one, are copied into a private in-memory
+// database first. The caller must close the sandbox.
+func OpenSandbox(store Store) (*Sandbox, error) {
+	s := &Sandbox{MaxRows: DefaultMaxRows, Timeout: DefaultQueryTimeout}
+
+	var dsn string
+	if sqlite, ok := store.(*SQLiteStore); ok && hasAuthorizer {
+		dsn = readOnlyURI(sqlite.path)
+	} else {
+		name := make([]byte, 8)
+		if _, err := rand.Read(name); err != nil {
+			return nil, fmt.Errorf("naming in-memory database: %w", err)
+		}
+		dsn = "file:basal-sandbox-" + hex.EncodeToString(name) + "?mode=memory&cache=shared"
+
+		owner, err := sql.Open(driverName, dsn)
+		if err != nil {
+			return nil, fmt.Errorf("opening in-memory database: %w", err)
+		}
+		// The shared in-memory database lives as long as this connection
+		owner.SetMaxOpenConns(1)
+		if err := copyIntoSQL(store, owner); err != nil {
+			owner.Close()
+			return nil, err
+		}
+		s.owner = owner
+	}
+
+	if err := s.open(dsn); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// readOnlyURI returns a SQLite URI opening path read-only.
+func readOnlyURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u := url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro"}
+	return u.String()
+}
+
+func (s *Sandbox) open(dsn string) error {
+	database, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return fmt.Errorf("opening sandbox: %w", err)
+	}
+	s.db = database
+
+	ctx := context.Background()
+	if s.conn, err = database.Conn(ctx); err != nil {
+		return fmt.Errorf("opening sandbox: %w", err)
+	}
+	if _, err := s.conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+		return fmt.Errorf("making sandbox read-only: %w", err)
+	}
+	if err := sandboxConn(s.conn); err != nil {
+		return fmt.Errorf("restricting sandbox: %w", err)
+	}
+	return nil
+}
+
+// Query runs a single read-only statement and returns up to MaxRows rows.
+func (s *Sandbox) Query(ctx context.Context, query string) (*QueryResult, error) {
+	if err := CheckReadOnlyQuery(query); err != nil {
+		return nil, err
+	}
+
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	rows, err := s.conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, s.queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("getting columns: %w", err)
+	}
+	result := &QueryResult{Columns: columns}
+
+	values := make([]any, len(columns))
+	valuePtrs := make([]any, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if s.MaxRows > 0 && len(result.Rows) == s.MaxRows {
+			result.Truncated = true
+			break
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+
+		row := make([]string, len(columns))
+		for i, val := range values {
+			switch v := val.(type) {
+			case nil:
+				row[i] = "NULL"
+			case []byte:
+				row[i] = string(v)
+			default:
+				row[i] = fmt.Sprintf("%v", v)
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, s.queryError(ctx, err)
+	}
+
+	return result, nil
+}
+
+// QueryPlan returns SQLite's plan for query, one step per line, indented to
+// show how the steps nest.
+func (s *Sandbox) QueryPlan(ctx context.Context, query string) ([]string, error) {
+	if err := CheckReadOnlyQuery(query); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.conn.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query)
+	if err != nil {
+		return nil, fmt.Errorf("explaining query: %w", err)
+	}
+	defer rows.Close()
+
+	var plan []string
+	depth := map[int64]int{}
+	for rows.Next() {
+		var id, parent, unused int64
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			return nil, fmt.Errorf("scanning query plan: %w", err)
+		}
+		depth[id] = depth[parent] + 1
+		plan = append(plan, strings.Repeat("  ", depth[id]-1)+detail)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("explaining query: %w", err)
+	}
+	return plan, nil
+}
+
+func (s *Sandbox) queryError(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("query took longer than %v", s.Timeout)
+	}
+	return fmt.Errorf("executing query: %w", err)
+}
+
+// Close releases the sandbox connection and any in-memory copy.
+func (s *Sandbox) Close() error {
+	var err error
+	if s.conn != nil {
+		err = s.conn.Close()
+	}
+	if s.db != nil {
+		if closeErr := s.db.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if s.owner != nil {
+		if closeErr := s.owner.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// CheckReadOnlyQuery checks that query is a single SELECT (or WITH ... SELECT,
+// or VALUES) statement. String literals, quoted identifiers and comments are
+// skipped, so column names such as "updated" are not mistaken for keywords.
+func CheckReadOnlyQuery(query string) error {
+	var keyword string
+	statements := 0
+	inStatement := false
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			continue
+		case c == ';':
+			inStatement = false
+			continue
+		case strings.HasPrefix(query[i:], "--"):
+			if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(query)
+			}
+			continue
+		case strings.HasPrefix(query[i:], "/*"):
+			if j := strings.Index(query[i+2:], "*/"); j >= 0 {
+				i += j + 3
+			} else {
+				i = len(query)
+			}
+			continue
+		}
+
+		if !inStatement {
+			statements++
+			if statements > 1 {
+				return fmt.Errorf("invalid query: only one statement is allowed")
+			}
+			inStatement = true
+
+			j := i
+			for j < len(query) && isWordByte(query[j]) {
+				j++
+			}
+			keyword = strings.ToUpper(query[i:j])
+		}
+
+		if end, ok := closingQuote(c); ok {
+			j := strings.IndexByte(query[i+1:], end)
+			if j < 0 {
+				return fmt.Errorf("invalid query: unterminated quote")
+			}
+			i += j + 1
+		}
+	}
+
+	switch keyword {
+	case "SELECT", "WITH", "VALUES":
+		return nil
+	case "":
+		if statements == 0 {
+			return fmt.Errorf("invalid query: empty query")
+		}
+		fallthrough
+	default:
+		return fmt.Errorf("invalid query: only SELECT queries are allowed")
+	}
+}
+
+// closingQuote returns the character that ends a quoted string or identifier
+// opened by c.
+func closingQuote(c byte) (byte, bool) {
+	switch c {
+	case '\'', '"', '`':
+		return c, true
+	case '[':
+		return ']', true
+	}
+	return 0, false
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestSandbox returns a sandbox over a SQLite database holding a record
+// and an ask history entry, which queries must not be able to see.
+func openTestSandbox(t *testing.T) *Sandbox {
+	t.Helper()
+	store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "basal.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if _, err := store.CreateBasalRecord(day("2024-01-01"), testSchedule(0.8)); err != nil {
+		t.Fatalf("CreateBasalRecord: %v", err)
+	}
+	if _, err := store.AddAskEntry(AskEntry{Question: "secret question", SQL: "SELECT 1", Answer: "secret answer"}); err != nil {
+		t.Fatalf("AddAskEntry: %v", err)
+	}
+
+	sandbox, err := OpenSandbox(store)
+	if err != nil {
+		t.Fatalf("OpenSandbox: %v", err)
+	}
+	t.Cleanup(func() { sandbox.Close() })
+	return sandbox
+}
+
+func TestSandboxAllowsReadingBasalData(t *testing.T) {
+	sandbox := openTestSandbox(t)
+	queries := []string{
+		"SELECT date, total_units FROM basal_records",
+		"SELECT COUNT(*) FROM basal_records",
+		"SELECT units_per_hour FROM basal_intervals WHERE start_seconds = 0",
+		"SELECT COUNT(*) FROM effective_schedule",
+		"SELECT total_units FROM daily_basal WHERE date = '2024-01-05'",
+		"WITH changes AS (SELECT date FROM basal_records) SELECT COUNT(*) FROM changes",
+		"SELECT 1 AS updated", // a keyword inside a name is not a write
+		"VALUES (1)",
+	}
+	for _, q := range queries {
+		result, err := sandbox.Query(context.Background(), q)
+		if err != nil {
+			t.Errorf("%s: %v", q, err)
+			continue
+		}
+		if len(result.Rows) == 0 {
+			t.Errorf("%s: no rows", q)
+		}
+	}
+}
+
+func TestSandboxRejectsMaliciousQueries(t *testing.T) {
+	sandbox := openTestSandbox(t)
+	queries := []string{
+		"DELETE FROM basal_records",
+		"UPDATE basal_records SET total_units = 0",
+		"INSERT INTO basal_records (date, total_units) VALUES ('2024-02-01', 1)",
+		"DROP TABLE basal_records",
+		"SELECT 1; DELETE FROM basal_records",
+		"ATTACH DATABASE ':memory:' AS other",
+		"PRAGMA writable_schema = ON",
+		"WITH x AS (SELECT 1) DELETE FROM basal_records",
+		"SELECT load_extension('evil')",
+		"SELECT readfile('/etc/passwd')",
+		"SELECT * FROM ask_history",
+		"SELECT COUNT(*) FROM ask_history",
+		"SELECT question FROM main.ask_history",
+		"SELECT answer FROM ask_history, basal_records",
+	}
+	for _, q := range queries {
+		if _, err := sandbox.Query(context.Background(), q); err == nil {
+			t.Errorf("%s: allowed", q)
+		}
+	}
+
+	result, err := sandbox.Query(context.Background(), "SELECT COUNT(*) FROM basal_records")
+	if err != nil || result.Rows[0][0] != "1" {
+		t.Errorf("records after the attempts: %v, %v; want 1", result, err)
+	}
+}
+
+func TestSandboxHidesOtherTables(t *testing.T) {
+	sandbox := openTestSandbox(t)
+	// Depending on the driver these fail or see a copy without the
+	// history; either way nothing about it may come back
+	queries := []string{
+		"SELECT name, sql FROM sqlite_master",
+		"SELECT * FROM pragma_table_info('ask_history')",
+		"SELECT name FROM pragma_table_list",
+	}
+	for _, q := range queries {
+		result, err := sandbox.Query(context.Background(), q)
+		if err != nil {
+			continue
+		}
+		for _, row := range result.Rows {
+			for _, v := range row {
+				if strings.Contains(v, "ask_history") || strings.Contains(v, "question") {
+					t.Errorf("%s: revealed %q", q, v)
+				}
+			}
+		}
+	}
+}
+
+func TestSandboxLimits(t *testing.T) {
+	sandbox := openTestSandbox(t)
+
+	sandbox.MaxRows = 3
+	result, err := sandbox.Query(context.Background(), "SELECT date FROM daily_basal")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(result.Rows) != 3 || !result.Truncated {
+		t.Errorf("got %d rows, truncated %v; want 3, true", len(result.Rows), result.Truncated)
+	}
+
+	sandbox.Timeout = 100 * time.Millisecond
+	endless := "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT COUNT(*) FROM n"
+	start := time.Now()
+	if _, err := sandbox.Query(context.Background(), endless); err == nil {
+		t.Error("endless query succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("endless query ran for %v", elapsed)
+	}
+}
+
+func TestSandboxOverMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	if _, err := store.CreateBasalRecord(day("2024-01-01"), testSchedule(0.8)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddAskEntry(AskEntry{Question: "secret question"}); err != nil {
+		t.Fatal(err)
+	}
+	sandbox, err := OpenSandbox(store)
+	if err != nil {
+		t.Fatalf("OpenSandbox: %v", err)
+	}
+	defer sandbox.Close()
+
+	if _, err := sandbox.Query(context.Background(), "SELECT * FROM ask_history"); err == nil {
+		t.Error("ask_history readable from a memory store")
+	}
+	result, err := sandbox.Query(context.Background(), "SELECT COUNT(*) FROM effective_schedule")
+	if err != nil || result.Rows[0][0] != "2" {
+		t.Errorf("effective_schedule: %v, %v; want 2 intervals", result, err)
+	}
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"basal/schedule"
+)
+
+// SQLiteStore is a Store backed by a SQLite database file.
+type SQLiteStore struct {
+	db   *sql.DB
+	path string
+}
+
+// OpenSQLiteStore opens (creating and migrating if needed) the SQLite database at path.
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	database, err := InitDB(path)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteStore{db: database, path: path}, nil
+}
+
+// DB returns the underlying database handle.
+func (s *SQLiteStore) DB() *sql.DB {
+	return s.db
+}
+
+func (s *SQLiteStore) CreateBasalRecord(date time.Time, sched schedule.Schedule) (int64, error) {
+	return CreateBasalRecord(s.db, date, sched)
+}
+
+func (s *SQLiteStore) GetBasalRecord(id int64) (*BasalRecord, schedule.Schedule, error) {
+	return GetBasalRecord(s.db, id)
+}
+
+func (s *SQLiteStore) GetBasalRecordByDate(date time.Time) (*BasalRecord, schedule.Schedule, error) {
+	return GetBasalRecordByDate(s.db, date)
+}
+
+func (s *SQLiteStore) ListBasalRecords() ([]BasalRecord, error) {
+	return ListBasalRecords(s.db)
+}
+
+func (s *SQLiteStore) UpdateBasalRecord(id int64, date time.Time, sched schedule.Schedule) error {
+	return UpdateBasalRecord(s.db, id, date, sched)
+}
+
+func (s *SQLiteStore) DeleteBasalRecord(id int64) error {
+	return DeleteBasalRecord(s.db, id)
+}
+
+func (s *SQLiteStore) AddAskEntry(entry AskEntry) (int64, error) {
+	return AddAskEntry(s.db, entry)
+}
+
+func (s *SQLiteStore) ListAskEntries() ([]AskEntry, error) {
+	return ListAskEntries(s.db)
+}
+
+func (s *SQLiteStore) GetAskEntry(id int64) (*AskEntry, error) {
+	return GetAskEntry(s.db, id)
+}
+
+func (s *SQLiteStore) GetAskEntryByName(name string) (*AskEntry, error) {
+	return GetAskEntryByName(s.db, name)
+}
+
+func (s *SQLiteStore) GetAskEntryByCacheKey(key string) (*AskEntry, error) {
+	return GetAskEntryByCacheKey(s.db, key)
+}
+
+func (s *SQLiteStore) NameAskEntry(id int64, name string) error {
+	return NameAskEntry(s.db, id, name)
+}
+
+func (s *SQLiteStore) DeleteAskEntry(id int64) error {
+	return DeleteAskEntry(s.db, id)
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"basal/schedule"
+)
+
+// Storage backends accepted by OpenStore.
+const (
+	BackendSQLite    = "sqlite"
+	BackendJSON      = "json"
+	BackendEncrypted = "encrypted"
+	BackendMemory    = "memory"
+)
+
+// Backends lists the storage backends in the order they are offered to users.
+var Backends = []string{BackendSQLite, BackendJSON, BackendEncrypted, BackendMemory}
+
+// Store is a collection of basal records and their schedules.
+type Store interface {
+	// CreateBasalRecord validates and stores a new record, returning its ID.
+	CreateBasalRecord(date time.Time, sched schedule.Schedule) (int64, error)
+	// GetBasalRecord returns a record and its schedule by ID.
+	GetBasalRecord(id int64) (*BasalRecord, schedule.Schedule, error)
+	// GetBasalRecordByDate returns the record in effect on date: an exact
+	// match, otherwise the closest previous record, otherwise the earliest.
+	GetBasalRecordByDate(date time.Time) (*BasalRecord, schedule.Schedule, error)
+	// ListBasalRecords returns all records, newest date first.
+	ListBasalRecords() ([]BasalRecord, error)
+	// UpdateBasalRecord replaces the date and schedule of an existing record.
+	UpdateBasalRecord(id int64, date time.Time, sched schedule.Schedule) error
+	// DeleteBasalRecord removes a record and its schedule.
+	DeleteBasalRecord(id int64) error
+	// AddAskEntry records a question answered by 'basal ask', returning its
+	// ID. A named entry takes the name from any other entry.
+	AddAskEntry(entry AskEntry) (int64, error)
+	// ListAskEntries returns the ask history, newest first.
+	ListAskEntries() ([]AskEntry, error)
+	// GetAskEntry returns an ask history entry by ID.
+	GetAskEntry(id int64) (*AskEntry, error)
+	// GetAskEntryByName returns the ask history entry saved as name.
+	GetAskEntryByName(name string) (*AskEntry, error)
+	// GetAskEntryByCacheKey returns the newest ask history entry with a
+	// query stored under key, or ErrNoAskEntry.
+	GetAskEntryByCacheKey(key string) (*AskEntry, error)
+	// NameAskEntry saves an ask history entry as a report called name,
+	// taking the name from any other entry.
+	NameAskEntry(id int64, name string) error
+	// DeleteAskEntry removes an ask history entry.
+	DeleteAskEntry(id int64) error
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// StoreConfig describes which storage backend to open and where.
+type StoreConfig struct {
+	Backend string
+	Path    string
+	// Secret returns the passphrase or key for the encrypted backend. It is
+	// only called when a store actually needs unlocking.
+	Secret func() ([]byte, error)
+}
+
+// OpenStore opens the configured storage backend. The memory backend ignores
+// the path and starts empty.
+func OpenStore(cfg StoreConfig) (Store, error) {
+	switch cfg.Backend {
+	case BackendSQLite, "":
+		return OpenSQLiteStore(cfg.Path)
+	case BackendJSON:
+		return OpenJSONStore(cfg.Path)
+	case BackendEncrypted:
+		return OpenEncryptedStore(cfg.Path, cfg.Secret)
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (want one of %v)", cfg.Backend, Backends)
+	}
+}
+
+// CopyStore copies every record and the ask history of src into the empty
+// store dst, keeping IDs and creation times. It is used to convert between
+// backends.
+func CopyStore(dst, src Store) error {
+	existing, err := dst.ListBasalRecords()
+	if err != nil {
+		return fmt.Errorf("listing destination records: %w", err)
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("destination already holds %d records", len(existing))
+	}
+
+	switch d := dst.(type) {
+	case *SQLiteStore:
+		if err := copyIntoSQL(src, d.db); err != nil {
+			return err
+		}
+		return copyAskHistory(d, src)
+	case *JSONStore:
+		return d.update(func(next *MemoryStore) error { return next.copyFrom(src) }, d.save)
+	case *EncryptedStore:
+		return d.update(func(next *MemoryStore) error { return next.copyFrom(src) }, d.save)
+	case *MemoryStore:
+		return d.copyFrom(src)
+	default:
+		return fmt.Errorf("cannot copy into %T", dst)
+	}
+}
+
+// copyIntoSQL creates the schema in database and copies every record of store into it.
+func copyIntoSQL(store Store, database *sql.DB) error {
+	if _, err := database.Exec(GetSchema()); err != nil {
+		return fmt.Errorf("creating tables: %w", err)
+	}
+
+	records, err := store.ListBasalRecords()
+	if err != nil {
+		return fmt.Errorf("listing records: %w", err)
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, r := range records {
+		record, sched, err := store.GetBasalRecord(r.ID)
+		if err != nil {
+			return fmt.Errorf("reading record %d: %w", r.ID, err)
+		}
+		_, err = tx.Exec(
+			"INSERT INTO basal_records (id, date, total_units, created_at) VALUES (?, ?, ?, ?)",
+			record.ID,
+			record.Date.Format(DateFormat),
+			record.TotalUnits,
+			record.CreatedAt.UTC().Format(timestampFormat),
+		)
+		if err != nil {
+			return fmt.Errorf("copying record %d: %w", record.ID, err)
+		}
+		if err := insertIntervals(tx, record.ID, sched); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// copyAskHistory adds the ask history of src to dst, oldest first.
+func copyAskHistory(dst, src Store) error {
+	history, err := src.ListAskEntries()
+	if err != nil {
+		return fmt.Errorf("listing ask history: %w", err)
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if _, err := dst.AddAskEntry(history[i]); err != nil {
+			return fmt.Errorf("copying ask history entry %d: %w", history[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// copyFrom adds every record and the ask history of src to the store,
+// keeping IDs and creation times.
+func (s *MemoryStore) copyFrom(src Store) error {
+	records, err := src.ListBasalRecords()
+	if err != nil {
+		return fmt.Errorf("listing records: %w", err)
+	}
+
+	history, err := src.ListAskEntries()
+	if err != nil {
+		return fmt.Errorf("listing ask history: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(history) - 1; i >= 0; i-- {
+		s.addAskEntry(history[i])
+	}
+	for _, r := range records {
+		record, sched, err := src.GetBasalRecord(r.ID)
+		if err != nil {
+			return fmt.Errorf("reading record %d: %w", r.ID, err)
+		}
+		s.records[record.ID] = &memoryRecord{record: *record, schedule: cloneSchedule(sched)}
+		if record.ID >= s.nextID {
+			s.nextID = record.ID + 1
+		}
+	}
+	return nil
+}
@@ -0,0 +1,399 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// storeBackend opens a store of one backend in dir. path is the file the
+// store keeps in dir, or "" if it keeps nothing on disk.
+type storeBackend struct {
+	name string
+	path string
+	open func(t *testing.T, dir string) Store
+}
+
+var storeBackends = []storeBackend{
+	{
+		name: BackendSQLite,
+		path: "basal.db",
+		open: func(t *testing.T, dir string) Store {
+			store, err := OpenSQLiteStore(filepath.Join(dir, "basal.db"))
+			if err != nil {
+				t.Fatalf("OpenSQLiteStore: %v", err)
+			}
+			return store
+		},
+	},
+	{
+		name: BackendMemory,
+		open: func(t *testing.T, dir string) Store { return NewMemoryStore() },
+	},
+	{
+		name: BackendJSON,
+		path: "basal.json",
+		open: func(t *testing.T, dir string) Store {
+			store, err := OpenJSONStore(filepath.Join(dir, "basal.json"))
+			if err != nil {
+				t.Fatalf("OpenJSONStore: %v", err)
+			}
+			return store
+		},
+	},
+	{
+		name: BackendEncrypted,
+		path: "basal.enc",
+		open: func(t *testing.T, dir string) Store {
+			store, err := OpenEncryptedStore(filepath.Join(dir, "basal.enc"), passphrase("correct horse"))
+			if err != nil {
+				t.Fatalf("OpenEncryptedStore: %v", err)
+			}
+			return store
+		},
+	},
+}
+
+// forEachBackend runs test against a fresh store of every backend.
+func forEachBackend(t *testing.T, test func(t *testing.T, b storeBackend, dir string, store Store)) {
+	for _, b := range storeBackends {
+		t.Run(b.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store := b.open(t, dir)
+			t.Cleanup(func() { store.Close() })
+			test(t, b, dir, store)
+		})
+	}
+}
+
+func mustCreate(t *testing.T, store Store, date string, rate float64) int64 {
+	t.Helper()
+	id, err := store.CreateBasalRecord(day(date), testSchedule(rate))
+	if err != nil {
+		t.Fatalf("CreateBasalRecord(%s): %v", date, err)
+	}
+	return id
+}
+
+func listedIDs(t *testing.T, store Store) []int64 {
+	t.Helper()
+	records, err := store.ListBasalRecords()
+	if err != nil {
+		t.Fatalf("ListBasalRecords: %v", err)
+	}
+	var ids []int64
+	for _, r := range records {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestStoreRecords(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b storeBackend, dir string, store Store) {
+		if _, _, err := store.GetBasalRecordByDate(day("2024-01-01")); !errors.Is(err, ErrNoRecords) {
+			t.Errorf("GetBasalRecordByDate on an empty store: %v, want ErrNoRecords", err)
+		}
+		if _, err := store.CreateBasalRecord(day("2024-01-01"), nil); err == nil {
+			t.Error("CreateBasalRecord accepted an empty schedule")
+		}
+
+		jan := mustCreate(t, store, "2024-01-01", 0.8)
+		june := mustCreate(t, store, "2024-06-01", 0.9)
+		juneNewer := mustCreate(t, store, "2024-06-01", 1.0)
+		march := mustCreate(t, store, "2024-03-01", 0.7)
+
+		if got, want := listedIDs(t, store), []int64{juneNewer, june, march, jan}; !reflect.DeepEqual(got, want) {
+			t.Errorf("ListBasalRecords = %v, want %v", got, want)
+		}
+
+		record, sched, err := store.GetBasalRecord(march)
+		if err != nil {
+			t.Fatalf("GetBasalRecord: %v", err)
+		}
+		if !record.Date.Equal(day("2024-03-01")) || record.TotalUnits != testSchedule(0.7).TotalUnits() {
+			t.Errorf("record = %+v", record)
+		}
+		if !reflect.DeepEqual(sched, testSchedule(0.7)) {
+			t.Errorf("schedule = %v", sched)
+		}
+		if _, _, err := store.GetBasalRecord(999); err == nil {
+			t.Error("GetBasalRecord found a missing record")
+		}
+
+		effective := []struct {
+			date string
+			want int64
+		}{
+			{"2023-06-01", jan}, // before the first record
+			{"2024-01-01", jan},
+			{"2024-02-15", jan},
+			{"2024-05-31", march},
+			{"2024-06-01", juneNewer}, // the last of a date wins
+			{"2025-01-01", juneNewer},
+		}
+		for _, e := range effective {
+			record, _, err := store.GetBasalRecordByDate(day(e.date))
+			if err != nil {
+				t.Fatalf("GetBasalRecordByDate(%s): %v", e.date, err)
+			}
+			if record.ID != e.want {
+				t.Errorf("GetBasalRecordByDate(%s) = %d, want %d", e.date, record.ID, e.want)
+			}
+		}
+
+		if err := store.UpdateBasalRecord(march, day("2024-07-01"), testSchedule(1.2)); err != nil {
+			t.Fatalf("UpdateBasalRecord: %v", err)
+		}
+		if err := store.UpdateBasalRecord(march, day("2024-07-01"), nil); err == nil {
+			t.Error("UpdateBasalRecord accepted an empty schedule")
+		}
+		if err := store.UpdateBasalRecord(999, day("2024-07-01"), testSchedule(1)); err == nil {
+			t.Error("UpdateBasalRecord changed a missing record")
+		}
+		if err := store.DeleteBasalRecord(jan); err != nil {
+			t.Fatalf("DeleteBasalRecord: %v", err)
+		}
+		if err := store.DeleteBasalRecord(jan); err == nil {
+			t.Error("DeleteBasalRecord removed a record twice")
+		}
+
+		want := []int64{march, juneNewer, june}
+		if got := listedIDs(t, store); !reflect.DeepEqual(got, want) {
+			t.Errorf("after changes ListBasalRecords = %v, want %v", got, want)
+		}
+		if _, sched, _ := store.GetBasalRecord(march); !reflect.DeepEqual(sched, testSchedule(1.2)) {
+			t.Errorf("updated schedule = %v", sched)
+		}
+
+		// New IDs are never reused
+		if id := mustCreate(t, store, "2024-08-01", 1); id <= march {
+			t.Errorf("new ID %d reuses an old one", id)
+		}
+
+		if b.path == "" {
+			return
+		}
+		store.Close()
+		reopened := b.open(t, dir)
+		defer reopened.Close()
+		if got := listedIDs(t, reopened); len(got) != 4 || !reflect.DeepEqual(got[1:], want) {
+			t.Errorf("after reopening ListBasalRecords = %v, want a new record and %v", got, want)
+		}
+	})
+}
+
+func TestStoreAskHistory(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b storeBackend, dir string, store Store) {
+		add := func(entry AskEntry) int64 {
+			t.Helper()
+			id, err := store.AddAskEntry(entry)
+			if err != nil {
+				t.Fatalf("AddAskEntry: %v", err)
+			}
+			return id
+		}
+		first := add(AskEntry{
+			Question: "highest total",
+			SQL:      "SELECT MAX(total_units) FROM basal_records",
+			Columns:  []string{"max"},
+			Rows:     [][]string{{"24.5"}},
+			Model:    "llama3",
+			Answer:   "24.5 units",
+			CacheKey: "key",
+		})
+		tools := add(AskEntry{Question: "rate now", Model: "llama3", Answer: "0.8 U/h", CacheKey: "key"})
+		second := add(AskEntry{Question: "highest total?", SQL: "SELECT 1", Model: "llama3", CacheKey: "key"})
+
+		entries, err := store.ListAskEntries()
+		if err != nil {
+			t.Fatalf("ListAskEntries: %v", err)
+		}
+		var ids []int64
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+		}
+		if want := []int64{second, tools, first}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("ListAskEntries = %v, want %v", ids, want)
+		}
+
+		entry, err := store.GetAskEntry(first)
+		if err != nil {
+			t.Fatalf("GetAskEntry: %v", err)
+		}
+		if entry.SQL == "" || !reflect.DeepEqual(entry.Rows, [][]string{{"24.5"}}) || entry.Answer != "24.5 units" {
+			t.Errorf("GetAskEntry = %+v", entry)
+		}
+		if _, err := store.GetAskEntry(999); !errors.Is(err, ErrNoAskEntry) {
+			t.Errorf("GetAskEntry(999): %v, want ErrNoAskEntry", err)
+		}
+
+		// The newest entry with a query, skipping tool answers
+		if entry, err := store.GetAskEntryByCacheKey("key"); err != nil || entry.ID != second {
+			t.Errorf("GetAskEntryByCacheKey = %+v, %v; want %d", entry, err, second)
+		}
+		if _, err := store.GetAskEntryByCacheKey("other"); !errors.Is(err, ErrNoAskEntry) {
+			t.Errorf("GetAskEntryByCacheKey(other): %v, want ErrNoAskEntry", err)
+		}
+
+		// Names move to the entry saved last
+		if err := store.NameAskEntry(first, "peak"); err != nil {
+			t.Fatalf("NameAskEntry: %v", err)
+		}
+		if err := store.NameAskEntry(second, "peak"); err != nil {
+			t.Fatalf("NameAskEntry: %v", err)
+		}
+		if entry, err := store.GetAskEntryByName("peak"); err != nil || entry.ID != second {
+			t.Errorf("GetAskEntryByName = %+v, %v; want %d", entry, err, second)
+		}
+		if entry, _ := store.GetAskEntry(first); entry.Name != "" {
+			t.Errorf("first entry kept the name %q", entry.Name)
+		}
+		if err := store.NameAskEntry(999, "peak"); !errors.Is(err, ErrNoAskEntry) {
+			t.Errorf("NameAskEntry(999): %v, want ErrNoAskEntry", err)
+		}
+		if _, err := store.GetAskEntryByName("missing"); !errors.Is(err, ErrNoAskEntry) {
+			t.Errorf("GetAskEntryByName(missing): %v, want ErrNoAskEntry", err)
+		}
+
+		// A new entry saved under a taken name takes it too
+		third := add(AskEntry{Question: "weekly total", SQL: "SELECT 2", Model: "llama3", Name: "peak"})
+		if entry, err := store.GetAskEntryByName("peak"); err != nil || entry.ID != third {
+			t.Errorf("GetAskEntryByName after AddAskEntry = %+v, %v; want %d", entry, err, third)
+		}
+		if entry, _ := store.GetAskEntry(second); entry.Name != "" {
+			t.Errorf("second entry kept the name %q", entry.Name)
+		}
+
+		// Deleting an entry frees its name
+		if err := store.DeleteAskEntry(third); err != nil {
+			t.Fatalf("DeleteAskEntry: %v", err)
+		}
+		if _, err := store.GetAskEntry(third); !errors.Is(err, ErrNoAskEntry) {
+			t.Errorf("GetAskEntry after DeleteAskEntry: %v, want ErrNoAskEntry", err)
+		}
+		if _, err := store.GetAskEntryByName("peak"); !errors.Is(err, ErrNoAskEntry) {
+			t.Errorf("GetAskEntryByName after DeleteAskEntry: %v, want ErrNoAskEntry", err)
+		}
+		if err := store.DeleteAskEntry(third); !errors.Is(err, ErrNoAskEntry) {
+			t.Errorf("deleting twice: %v, want ErrNoAskEntry", err)
+		}
+		if err := store.NameAskEntry(first, "peak"); err != nil {
+			t.Fatalf("NameAskEntry: %v", err)
+		}
+
+		check := func(store Store) {
+			t.Helper()
+			entries, err := store.ListAskEntries()
+			if err != nil {
+				t.Fatalf("ListAskEntries: %v", err)
+			}
+			var ids []int64
+			for _, e := range entries {
+				ids = append(ids, e.ID)
+			}
+			if want := []int64{second, tools, first}; !reflect.DeepEqual(ids, want) {
+				t.Errorf("ListAskEntries = %v, want %v", ids, want)
+			}
+			if entry, err := store.GetAskEntryByName("peak"); err != nil || entry.ID != first {
+				t.Errorf("GetAskEntryByName = %+v, %v; want %d", entry, err, first)
+			}
+		}
+		check(store)
+
+		if b.path == "" {
+			return
+		}
+		store.Close()
+		reopened := b.open(t, dir)
+		defer reopened.Close()
+		check(reopened)
+		// IDs of deleted entries are not reused
+		if id, err := reopened.AddAskEntry(AskEntry{Question: "again", Model: "llama3"}); err != nil || id <= third {
+			t.Errorf("after reopening AddAskEntry = %d, %v; want an ID after %d", id, err, third)
+		}
+	})
+}
+
+func TestStoreDoesNotReuseDeletedAskIDs(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b storeBackend, dir string, store Store) {
+		id, err := store.AddAskEntry(AskEntry{Question: "q", Model: "m"})
+		if err != nil {
+			t.Fatalf("AddAskEntry: %v", err)
+		}
+		if err := store.DeleteAskEntry(id); err != nil {
+			t.Fatalf("DeleteAskEntry: %v", err)
+		}
+		if b.path != "" {
+			store.Close()
+			store = b.open(t, dir)
+			defer store.Close()
+		}
+		if next, err := store.AddAskEntry(AskEntry{Question: "q", Model: "m"}); err != nil || next <= id {
+			t.Errorf("AddAskEntry after emptying the history = %d, %v; want an ID after %d", next, err, id)
+		}
+	})
+}
+
+func TestFileStoreFailedWriteKeepsMemory(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b storeBackend, dir string, store Store) {
+		if b.name != BackendJSON && b.name != BackendEncrypted {
+			t.Skip("not kept in a file written by the store")
+		}
+		kept := mustCreate(t, store, "2024-01-01", 0.8)
+		entry, err := store.AddAskEntry(AskEntry{Question: "q", SQL: "SELECT 1", Model: "m"})
+		if err != nil {
+			t.Fatalf("AddAskEntry: %v", err)
+		}
+
+		// A directory in place of the file makes every write fail
+		path := filepath.Join(dir, b.path)
+		if err := os.Rename(path, path+".moved"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(path, "blocker"), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.CreateBasalRecord(day("2024-02-01"), testSchedule(1)); err == nil {
+			t.Error("CreateBasalRecord succeeded without writing")
+		}
+		if err := store.UpdateBasalRecord(kept, day("2024-03-01"), testSchedule(1)); err == nil {
+			t.Error("UpdateBasalRecord succeeded without writing")
+		}
+		if err := store.DeleteBasalRecord(kept); err == nil {
+			t.Error("DeleteBasalRecord succeeded without writing")
+		}
+		if _, err := store.AddAskEntry(AskEntry{Question: "lost", Model: "m"}); err == nil {
+			t.Error("AddAskEntry succeeded without writing")
+		}
+		if err := store.NameAskEntry(entry, "report"); err == nil {
+			t.Error("NameAskEntry succeeded without writing")
+		}
+
+		if got := listedIDs(t, store); !reflect.DeepEqual(got, []int64{kept}) {
+			t.Errorf("records = %v, want only %d", got, kept)
+		}
+		record, sched, err := store.GetBasalRecord(kept)
+		if err != nil || !record.Date.Equal(day("2024-01-01")) || !reflect.DeepEqual(sched, testSchedule(0.8)) {
+			t.Errorf("record changed: %+v %v %v", record, sched, err)
+		}
+		entries, _ := store.ListAskEntries()
+		if len(entries) != 1 || entries[0].Name != "" {
+			t.Errorf("ask history changed: %+v", entries)
+		}
+
+		// Once the file can be written again the failed changes stay lost and
+		// IDs carry on from the last saved state
+		if err := os.RemoveAll(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(path+".moved", path); err != nil {
+			t.Fatal(err)
+		}
+		if id := mustCreate(t, store, "2024-04-01", 1); id != kept+1 {
+			t.Errorf("new ID = %d, want %d", id, kept+1)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// auditRecord is one line of the audit log: a request exactly as it was
+// sent, apart from the headers, which may hold an API key.
+type auditRecord struct {
+	Time   time.Time       `json:"time"`
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// audit writes a record of a request to the audit log, if there is one. A
+// request that can't be recorded must not be sent.
+func (c *client) audit(method, url string, payload []byte) error {
+	if c.auditLog == nil {
+		return nil
+	}
+	line, err := json.Marshal(auditRecord{Time: time.Now(), Method: method, URL: url, Body: payload})
+	if err != nil {
+		return fmt.Errorf("encoding audit record: %w", err)
+	}
+	if _, err := c.auditLog.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+	return nil
+}
+
+// IsLocal reports whether endpoint is on this machine: localhost or a
+// loopback address. Anything else, including other machines on the local
+// network, means data sent to it leaves the machine.
+func IsLocal(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,139 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Ollama is a Provider using Ollama's native /api/chat endpoint.
+type Ollama struct {
+	client
+	model string
+}
+
+type ollamaRequest struct {
+	Model    string     `json:"model"`
+	Messages []Message  `json:"messages"`
+	Tools    []toolSpec `json:"tools,omitempty"`
+	Stream   bool       `json:"stream"`
+}
+
+type ollamaResponse struct {
+	Message Message `json:"message"`
+	Error   string  `json:"error"`
+}
+
+// ollamaChunk is one line of a streamed Ollama response.
+type ollamaChunk struct {
+	Message Message `json:"message"`
+	Done    bool    `json:"done"`
+	Error   string  `json:"error"`
+}
+
+func (o *Ollama) Name() string { return ProviderOllama }
+
+func (o *Ollama) Chat(ctx context.Context, messages []Message) (string, error) {
+	reply, err := o.ChatWithTools(ctx, messages, nil)
+	return reply.Content, err
+}
+
+func (o *Ollama) ChatWithTools(ctx context.Context, messages []Message, tools []Tool) (Message, error) {
+	req := ollamaRequest{
+		Model:    o.model,
+		Messages: messages,
+		Tools:    toolSpecs(tools),
+		Stream:   false,
+	}
+
+	var resp ollamaResponse
+	if err := o.postJSON(ctx, "/api/chat", req, &resp, ollamaError); err != nil {
+		return Message{}, fmt.Errorf("ollama: %w", err)
+	}
+	if resp.Error != "" {
+		return Message{}, fmt.Errorf("ollama: %s", resp.Error)
+	}
+	if resp.Message.Content == "" && len(resp.Message.ToolCalls) == 0 {
+		return Message{}, fmt.Errorf("ollama: the model sent an empty reply")
+	}
+	return resp.Message, nil
+}
+
+// Version returns the version of the Ollama server.
+func (o *Ollama) Version(ctx context.Context) (string, error) {
+	var resp struct {
+		Version string `json:"version"`
+	}
+	if err := o.getJSON(ctx, "/api/version", &resp, ollamaError); err != nil {
+		return "", fmt.Errorf("ollama: %w", err)
+	}
+	return resp.Version, nil
+}
+
+// Models returns the names of the models installed on the Ollama server.
+func (o *Ollama) Models(ctx context.Context) ([]string, error) {
+	var resp struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := o.getJSON(ctx, "/api/tags", &resp, ollamaError); err != nil {
+		return nil, fmt.Errorf("ollama: %w", err)
+	}
+	names := make([]string, len(resp.Models))
+	for i, m := range resp.Models {
+		names[i] = m.Name
+	}
+	return names, nil
+}
+
+// ChatStream reads Ollama's streamed reply, one JSON object per line.
+func (o *Ollama) ChatStream(ctx context.Context, messages []Message, w io.Writer) (string, error) {
+	req := ollamaRequest{
+		Model:    o.model,
+		Messages: messages,
+		Stream:   true,
+	}
+
+	resp, err := o.post(ctx, "/api/chat", req, ollamaError)
+	if err != nil {
+		return "", fmt.Errorf("ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var reply strings.Builder
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk ollamaChunk
+		if err := decoder.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return reply.String(), fmt.Errorf("ollama: reading stream: %w", err)
+		}
+		if chunk.Error != "" {
+			return reply.String(), fmt.Errorf("ollama: %s", chunk.Error)
+		}
+
+		reply.WriteString(chunk.Message.Content)
+		if _, err := io.WriteString(w, chunk.Message.Content); err != nil {
+			return reply.String(), err
+		}
+		if chunk.Done {
+			break
+		}
+	}
+	return reply.String(), nil
+}
+
+// ollamaError extracts the message from an Ollama error body: {"error": "..."}.
+func ollamaError(body []byte) string {
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &e) != nil {
+		return ""
+	}
+	return e.Error
+}
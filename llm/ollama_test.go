@@ -0,0 +1,159 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestProvider returns a provider of kind talking to a server running handler.
+func newTestProvider(t *testing.T, kind string, handler http.HandlerFunc, configure ...func(*Config)) Provider {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg := Config{Provider: kind, Endpoint: server.URL, Model: "test-model"}
+	for _, f := range configure {
+		f(&cfg)
+	}
+	provider, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return provider
+}
+
+func TestOllamaChat(t *testing.T) {
+	var got ollamaRequest
+	provider := newTestProvider(t, ProviderOllama, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/chat" {
+			t.Errorf("request %s %s, want POST /api/chat", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		fmt.Fprint(w, `{"message": {"role": "assistant", "content": "SELECT 1"}, "done": true}`)
+	})
+
+	reply, err := Ask(context.Background(), provider, "question")
+	if err != nil {
+		t.Fatalf("Ask: %v", err)
+	}
+	if reply != "SELECT 1" {
+		t.Errorf("reply = %q, want SELECT 1", reply)
+	}
+	if got.Model != "test-model" || got.Stream || len(got.Messages) != 1 || got.Messages[0].Content != "question" {
+		t.Errorf("request = %+v", got)
+	}
+}
+
+func TestOllamaErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"error body", http.StatusNotFound, `{"error": "model \"test-model\" not found"}`, `404 Not Found: model "test-model" not found`},
+		{"plain body", http.StatusInternalServerError, `oops`, "500 Internal Server Error"},
+		{"error in reply", http.StatusOK, `{"error": "out of memory"}`, "ollama: out of memory"},
+		{"empty reply", http.StatusOK, `{"message": {"role": "assistant", "content": ""}}`, "empty reply"},
+		{"not JSON", http.StatusOK, `<html>`, "decoding response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestProvider(t, ProviderOllama, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			})
+			_, err := Ask(context.Background(), provider, "question")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOllamaStream(t *testing.T) {
+	provider := newTestProvider(t, ProviderOllama, func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			t.Error("streamed request has stream = false")
+		}
+		for _, part := range []string{"Your ", "total ", "was 22.8."} {
+			fmt.Fprintf(w, `{"message": {"role": "assistant", "content": %q}, "done": false}`+"\n", part)
+		}
+		fmt.Fprint(w, `{"message": {"role": "assistant", "content": ""}, "done": true}`+"\n")
+		fmt.Fprint(w, `{"message": {"role": "assistant", "content": "ignored"}, "done": false}`+"\n")
+	})
+
+	var out strings.Builder
+	reply, err := Stream(context.Background(), provider, []Message{{Role: "user", Content: "q"}}, &out)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if want := "Your total was 22.8."; reply != want || out.String() != want {
+		t.Errorf("reply = %q, written %q; want %q", reply, out.String(), want)
+	}
+}
+
+func TestOllamaStreamError(t *testing.T) {
+	provider := newTestProvider(t, ProviderOllama, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"message": {"role": "assistant", "content": "Your "}, "done": false}`+"\n")
+		fmt.Fprint(w, `{"error": "model crashed"}`+"\n")
+	})
+
+	var out strings.Builder
+	reply, err := Stream(context.Background(), provider, []Message{{Role: "user", Content: "q"}}, &out)
+	if err == nil || !strings.Contains(err.Error(), "model crashed") {
+		t.Errorf("err = %v, want the stream's error", err)
+	}
+	if reply != "Your " {
+		t.Errorf("reply = %q, want the part before the error", reply)
+	}
+}
+
+func TestOllamaToolCalls(t *testing.T) {
+	provider := newTestProvider(t, ProviderOllama, func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Tools) != 1 || req.Tools[0].Function.Name != "rate_at" {
+			t.Errorf("tools = %+v", req.Tools)
+		}
+		fmt.Fprint(w, `{"message": {"role": "assistant", "content": "",
+			"tool_calls": [{"function": {"name": "rate_at", "arguments": {"date": "2024-01-01"}}}]}}`)
+	})
+
+	reply, err := provider.(ToolCaller).ChatWithTools(context.Background(),
+		[]Message{{Role: "user", Content: "q"}},
+		[]Tool{{Name: "rate_at", Parameters: map[string]any{"type": "object"}}})
+	if err != nil {
+		t.Fatalf("ChatWithTools: %v", err)
+	}
+	if len(reply.ToolCalls) != 1 || reply.ToolCalls[0].Function.Name != "rate_at" ||
+		string(reply.ToolCalls[0].Function.Arguments) != `{"date": "2024-01-01"}` {
+		t.Errorf("tool calls = %+v", reply.ToolCalls)
+	}
+}
+
+func TestOllamaModels(t *testing.T) {
+	provider := newTestProvider(t, ProviderOllama, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/tags" {
+			t.Errorf("request %s %s, want GET /api/tags", r.Method, r.URL.Path)
+		}
+		fmt.Fprint(w, `{"models": [{"name": "llama3.1:latest"}, {"name": "qwen2.5:7b"}]}`)
+	})
+
+	models, err := provider.(ModelLister).Models(context.Background())
+	if err != nil {
+		t.Fatalf("Models: %v", err)
+	}
+	if strings.Join(models, ",") != "llama3.1:latest,qwen2.5:7b" {
+		t.Errorf("models = %v", models)
+	}
+}
@@ -0,0 +1,211 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// OpenAI is a Provider using the OpenAI-compatible /v1/chat/completions
+// endpoint, as served by llama.cpp's server, LM Studio, vLLM and others.
+type OpenAI struct {
+	client
+	model string
+}
+
+type openAIRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+	Tools    []toolSpec      `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+}
+
+// openAIMessage differs from Message in that tool call arguments are a
+// string holding JSON rather than a JSON object.
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+	} `json:"choices"`
+}
+
+// openAIChunk is one server-sent event of a streamed response.
+type openAIChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func toOpenAIMessages(messages []Message) []openAIMessage {
+	out := make([]openAIMessage, len(messages))
+	for i, m := range messages {
+		out[i] = openAIMessage{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			var tc openAIToolCall
+			tc.ID = call.ID
+			tc.Type = "function"
+			tc.Function.Name = call.Function.Name
+			tc.Function.Arguments = string(call.Function.Arguments)
+			out[i].ToolCalls = append(out[i].ToolCalls, tc)
+		}
+	}
+	return out
+}
+
+func fromOpenAIMessage(m openAIMessage) Message {
+	msg := Message{Role: m.Role, Content: m.Content}
+	for _, tc := range m.ToolCalls {
+		args := json.RawMessage(tc.Function.Arguments)
+		if len(args) == 0 {
+			args = json.RawMessage("{}")
+		}
+		msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+			ID:       tc.ID,
+			Function: FunctionCall{Name: tc.Function.Name, Arguments: args},
+		})
+	}
+	return msg
+}
+
+func (o *OpenAI) Name() string { return ProviderOpenAI }
+
+func (o *OpenAI) Chat(ctx context.Context, messages []Message) (string, error) {
+	reply, err := o.ChatWithTools(ctx, messages, nil)
+	return reply.Content, err
+}
+
+func (o *OpenAI) ChatWithTools(ctx context.Context, messages []Message, tools []Tool) (Message, error) {
+	req := openAIRequest{
+		Model:    o.model,
+		Messages: toOpenAIMessages(messages),
+		Tools:    toolSpecs(tools),
+		Stream:   false,
+	}
+
+	var resp openAIResponse
+	if err := o.postJSON(ctx, o.path("/chat/completions"), req, &resp, openAIError); err != nil {
+		return Message{}, fmt.Errorf("openai: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return Message{}, fmt.Errorf("openai: response has no choices")
+	}
+	reply := fromOpenAIMessage(resp.Choices[0].Message)
+	if reply.Content == "" && len(reply.ToolCalls) == 0 {
+		return Message{}, fmt.Errorf("openai: the model sent an empty reply")
+	}
+	return reply, nil
+}
+
+// Models returns the IDs of the models the server offers.
+func (o *OpenAI) Models(ctx context.Context) ([]string, error) {
+	var resp struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := o.getJSON(ctx, o.path("/models"), &resp, openAIError); err != nil {
+		return nil, fmt.Errorf("openai: %w", err)
+	}
+	ids := make([]string, len(resp.Data))
+	for i, m := range resp.Data {
+		ids[i] = m.ID
+	}
+	return ids, nil
+}
+
+// ChatStream reads the streamed reply, sent as server-sent events with a
+// JSON chunk in each "data:" line and "[DONE]" at the end.
+func (o *OpenAI) ChatStream(ctx context.Context, messages []Message, w io.Writer) (string, error) {
+	req := openAIRequest{
+		Model:    o.model,
+		Messages: toOpenAIMessages(messages),
+		Stream:   true,
+	}
+
+	resp, err := o.post(ctx, o.path("/chat/completions"), req, openAIError)
+	if err != nil {
+		return "", fmt.Errorf("openai: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var reply strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			// Blank lines separate events; other fields are unused
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk openAIChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return reply.String(), fmt.Errorf("openai: decoding stream: %w", err)
+		}
+		if chunk.Error != nil {
+			return reply.String(), fmt.Errorf("openai: %s", chunk.Error.Message)
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		content := chunk.Choices[0].Delta.Content
+		reply.WriteString(content)
+		if _, err := io.WriteString(w, content); err != nil {
+			return reply.String(), err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return reply.String(), fmt.Errorf("openai: reading stream: %w", err)
+	}
+	return reply.String(), nil
+}
+
+// path returns an API path under /v1, unless the endpoint already ends in /v1.
+func (o *OpenAI) path(p string) string {
+	if strings.HasSuffix(o.endpoint, "/v1") {
+		return p
+	}
+	return "/v1" + p
+}
+
+// openAIError extracts the message from an OpenAI error body:
+// {"error": {"message": "..."}}. Some compatible servers send a plain string
+// instead, as Ollama does.
+func openAIError(body []byte) string {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &e) != nil {
+		return ollamaError(body)
+	}
+	return e.Error.Message
+}
@@ -0,0 +1,150 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIChat(t *testing.T) {
+	var got openAIRequest
+	provider := newTestProvider(t, ProviderOpenAI, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("request %s %s, want POST /v1/chat/completions", r.Method, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		fmt.Fprint(w, `{"choices": [{"message": {"role": "assistant", "content": "SELECT 1"}}]}`)
+	})
+
+	reply, err := Ask(context.Background(), provider, "question")
+	if err != nil {
+		t.Fatalf("Ask: %v", err)
+	}
+	if reply != "SELECT 1" {
+		t.Errorf("reply = %q, want SELECT 1", reply)
+	}
+	if got.Model != "test-model" || got.Stream || len(got.Messages) != 1 || got.Messages[0].Content != "question" {
+		t.Errorf("request = %+v", got)
+	}
+}
+
+func TestOpenAIEndpointWithVersion(t *testing.T) {
+	provider := newTestProvider(t, ProviderOpenAI, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			t.Errorf("path = %s, want /v1/models", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"data": [{"id": "local-model"}]}`)
+	}, func(cfg *Config) { cfg.Endpoint += "/v1/" })
+
+	models, err := provider.(ModelLister).Models(context.Background())
+	if err != nil {
+		t.Fatalf("Models: %v", err)
+	}
+	if len(models) != 1 || models[0] != "local-model" {
+		t.Errorf("models = %v", models)
+	}
+}
+
+func TestOpenAIErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"error object", http.StatusUnauthorized, `{"error": {"message": "invalid API key", "type": "auth"}}`, "401 Unauthorized: invalid API key"},
+		{"error string", http.StatusBadRequest, `{"error": "context too long"}`, "400 Bad Request: context too long"},
+		{"plain body", http.StatusNotFound, `not found`, "404 Not Found"},
+		{"no choices", http.StatusOK, `{"choices": []}`, "no choices"},
+		{"empty reply", http.StatusOK, `{"choices": [{"message": {"role": "assistant", "content": ""}}]}`, "empty reply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newTestProvider(t, ProviderOpenAI, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			})
+			_, err := Ask(context.Background(), provider, "question")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpenAIStream(t *testing.T) {
+	provider := newTestProvider(t, ProviderOpenAI, func(w http.ResponseWriter, r *http.Request) {
+		var req openAIRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			t.Error("streamed request has stream = false")
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": keep-alive comment\n\n")
+		for _, part := range []string{"Your ", "total ", "was 22.8."} {
+			fmt.Fprintf(w, "data: {\"choices\": [{\"delta\": {\"content\": %q}}]}\n\n", part)
+		}
+		fmt.Fprint(w, "data: {\"choices\": []}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		fmt.Fprint(w, "data: {\"choices\": [{\"delta\": {\"content\": \"ignored\"}}]}\n\n")
+	})
+
+	var out strings.Builder
+	reply, err := Stream(context.Background(), provider, []Message{{Role: "user", Content: "q"}}, &out)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if want := "Your total was 22.8."; reply != want || out.String() != want {
+		t.Errorf("reply = %q, written %q; want %q", reply, out.String(), want)
+	}
+}
+
+func TestOpenAIStreamError(t *testing.T) {
+	provider := newTestProvider(t, ProviderOpenAI, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "data: {\"choices\": [{\"delta\": {\"content\": \"Your \"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"error\": {\"message\": \"server overloaded\"}}\n\n")
+	})
+
+	_, err := Stream(context.Background(), provider, []Message{{Role: "user", Content: "q"}}, &strings.Builder{})
+	if err == nil || !strings.Contains(err.Error(), "server overloaded") {
+		t.Errorf("err = %v, want the stream's error", err)
+	}
+}
+
+func TestOpenAIToolCalls(t *testing.T) {
+	var got openAIRequest
+	provider := newTestProvider(t, ProviderOpenAI, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		fmt.Fprint(w, `{"choices": [{"message": {"role": "assistant", "content": "",
+			"tool_calls": [{"id": "call_1", "type": "function",
+				"function": {"name": "rate_at", "arguments": "{\"date\": \"2024-01-01\"}"}}]}}]}`)
+	})
+
+	messages := []Message{
+		{Role: "user", Content: "q"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_0", Function: FunctionCall{Name: "get_schedule", Arguments: json.RawMessage(`{"date":"2024-01-01"}`)}}}},
+		{Role: "tool", Content: "[]", ToolCallID: "call_0"},
+	}
+	reply, err := provider.(ToolCaller).ChatWithTools(context.Background(), messages,
+		[]Tool{{Name: "rate_at", Parameters: map[string]any{"type": "object"}}})
+	if err != nil {
+		t.Fatalf("ChatWithTools: %v", err)
+	}
+
+	// Arguments travel as a string holding JSON in both directions
+	if args := got.Messages[1].ToolCalls[0].Function.Arguments; args != `{"date":"2024-01-01"}` {
+		t.Errorf("sent arguments = %q", args)
+	}
+	if got.Messages[2].ToolCallID != "call_0" {
+		t.Errorf("tool message = %+v", got.Messages[2])
+	}
+	if len(reply.ToolCalls) != 1 || reply.ToolCalls[0].ID != "call_1" ||
+		string(reply.ToolCalls[0].Function.Arguments) != `{"date": "2024-01-01"}` {
+		t.Errorf("tool calls = %+v", reply.ToolCalls)
+	}
+}
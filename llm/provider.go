@@ -0,0 +1,310 @@
+// Package llm talks to the language model that turns questions into SQL. A
+// Provider hides the wire format of each kind of server: Ollama's native API
+// or the OpenAI-compatible API served by llama.cpp, LM Studio, vLLM and others.
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported providers.
+const (
+	ProviderOllama = "ollama"
+	ProviderOpenAI = "openai"
+)
+
+// Providers lists every supported provider name.
+var Providers = []string{ProviderOllama, ProviderOpenAI}
+
+// Requests that fail to connect or get a temporary error status are retried
+// up to maxRetries times, waiting retryDelay and doubling it each time.
+const (
+	maxRetries    = 2
+	retryDelay    = 500 * time.Millisecond
+	maxRetryDelay = 10 * time.Second
+)
+
+// Message is a single message in a chat conversation.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+
+	// ToolCalls are the tools an assistant message asks to call.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolName and ToolCallID identify the call a "tool" message answers.
+	ToolName   string `json:"tool_name,omitempty"`
+	ToolCallID string `json:"tool_call_id,omitempty"`
+}
+
+// Tool describes a function the model may call.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]any // JSON schema of the arguments object
+}
+
+// ToolCall is a request from the model to call a tool.
+type ToolCall struct {
+	ID       string       `json:"id,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall names the tool to call and its arguments as a JSON object.
+type FunctionCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ToolCaller is implemented by providers whose models can call tools.
+type ToolCaller interface {
+	// ChatWithTools sends messages along with the tools the model may call
+	// and returns its reply, which either has content or tool calls.
+	ChatWithTools(ctx context.Context, messages []Message, tools []Tool) (Message, error)
+}
+
+// toolSpec is the wire format of a tool, shared by Ollama and OpenAI.
+type toolSpec struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+func toolSpecs(tools []Tool) []toolSpec {
+	specs := make([]toolSpec, len(tools))
+	for i, t := range tools {
+		specs[i].Type = "function"
+		specs[i].Function.Name = t.Name
+		specs[i].Function.Description = t.Description
+		specs[i].Function.Parameters = t.Parameters
+	}
+	return specs
+}
+
+// Provider sends chat conversations to a language model.
+type Provider interface {
+	// Name returns the provider name, e.g. "ollama".
+	Name() string
+	// Chat sends messages to the model and returns its reply.
+	Chat(ctx context.Context, messages []Message) (string, error)
+}
+
+// Streamer is implemented by providers that can send a reply as the model
+// generates it.
+type Streamer interface {
+	// ChatStream sends messages to the model, writes the reply to w as it
+	// arrives and returns the whole reply.
+	ChatStream(ctx context.Context, messages []Message, w io.Writer) (string, error)
+}
+
+// ModelLister is implemented by providers that can list the models their
+// server offers.
+type ModelLister interface {
+	Models(ctx context.Context) ([]string, error)
+}
+
+// Config describes which provider to use and how to reach it.
+type Config struct {
+	Provider string
+	Endpoint string // base URL of the server, e.g. http://localhost:11434
+	Model    string
+	APIKey   string            // sent as a bearer token if set
+	Headers  map[string]string // extra HTTP headers sent with every request
+	Timeout  time.Duration     // how long to wait for a reply to start; 0 waits forever
+	Audit    io.Writer         // receives a JSON line for every request sent, if set
+}
+
+// New returns the provider described by cfg.
+func New(cfg Config) (Provider, error) {
+	// The timeout only covers waiting for the response headers, so that a
+	// long streamed answer is not cut off once it has started.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = cfg.Timeout
+
+	c := client{
+		endpoint: strings.TrimRight(cfg.Endpoint, "/"),
+		apiKey:   cfg.APIKey,
+		headers:  cfg.Headers,
+		timeout:  cfg.Timeout,
+		auditLog: cfg.Audit,
+		http:     &http.Client{Transport: transport},
+	}
+
+	switch cfg.Provider {
+	case ProviderOllama, "":
+		return &Ollama{client: c, model: cfg.Model}, nil
+	case ProviderOpenAI:
+		return &OpenAI{client: c, model: cfg.Model}, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q (want one of %v)", cfg.Provider, Providers)
+	}
+}
+
+// Ask sends a single user message and returns the reply.
+func Ask(ctx context.Context, p Provider, prompt string) (string, error) {
+	return p.Chat(ctx, []Message{{Role: "user", Content: prompt}})
+}
+
+// Stream sends messages to p and writes the reply to w as it arrives,
+// returning the whole reply. Providers that can't stream write it at once.
+func Stream(ctx context.Context, p Provider, messages []Message, w io.Writer) (string, error) {
+	if s, ok := p.(Streamer); ok {
+		return s.ChatStream(ctx, messages, w)
+	}
+	reply, err := p.Chat(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.WriteString(w, reply)
+	return reply, err
+}
+
+// client holds the HTTP settings shared by every provider.
+type client struct {
+	endpoint string
+	apiKey   string
+	headers  map[string]string
+	timeout  time.Duration
+	auditLog io.Writer
+	http     *http.Client
+}
+
+// postJSON sends body as JSON to path under the endpoint and decodes a
+// successful response into out. errorMessage extracts the server's
+// explanation from an error response body.
+func (c *client) postJSON(ctx context.Context, path string, body, out any, errorMessage func([]byte) string) error {
+	resp, err := c.post(ctx, path, body, errorMessage)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// getJSON fetches path under the endpoint and decodes a successful response
+// into out.
+func (c *client) getJSON(ctx context.Context, path string, out any, errorMessage func([]byte) string) error {
+	resp, err := c.send(ctx, http.MethodGet, path, nil, errorMessage)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// post sends body as JSON to path under the endpoint and returns the
+// response if it was successful. The caller must close its body.
+func (c *client) post(ctx context.Context, path string, body any, errorMessage func([]byte) string) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+	return c.send(ctx, http.MethodPost, path, payload, errorMessage)
+}
+
+// send makes a request, retrying connection failures and temporary error
+// statuses with backoff, and returns the response if it was successful.
+// errorMessage extracts the server's explanation from an error response body.
+func (c *client) send(ctx context.Context, method, path string, payload []byte, errorMessage func([]byte) string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		delay := retryDelay << attempt
+
+		if err := c.audit(method, c.endpoint+path, payload); err != nil {
+			return nil, err
+		}
+		resp, err := c.do(ctx, method, path, payload)
+		switch {
+		case err != nil:
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil {
+				return nil, fmt.Errorf("%s did not start replying within %v; a slow model may need a longer llm.timeout", c.endpoint, c.timeout)
+			}
+			if ctx.Err() != nil || attempt == maxRetries {
+				return nil, fmt.Errorf("calling %s: %w", c.endpoint, err)
+			}
+		case temporaryStatus(resp.StatusCode) && attempt < maxRetries:
+			if after := retryAfter(resp); after > 0 {
+				delay = after
+			}
+			resp.Body.Close()
+		case resp.StatusCode < 200 || resp.StatusCode > 299:
+			defer resp.Body.Close()
+			content, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+			if msg := errorMessage(content); msg != "" {
+				return nil, fmt.Errorf("%s returned %s: %s", c.endpoint, resp.Status, msg)
+			}
+			return nil, fmt.Errorf("%s returned %s", c.endpoint, resp.Status)
+		default:
+			return resp, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("calling %s: %w", c.endpoint, ctx.Err())
+		case <-time.After(min(delay, maxRetryDelay)):
+		}
+	}
+}
+
+// do makes a single request with the configured headers.
+func (c *client) do(ctx context.Context, method, path string, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+	return c.http.Do(req)
+}
+
+// temporaryStatus reports whether a request that got status may succeed if
+// it is sent again, e.g. while the server is loading a model.
+func temporaryStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the wait a response asks for in its Retry-After header,
+// if it gives one in seconds.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
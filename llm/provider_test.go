@@ -0,0 +1,165 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAPIKeyAndHeaders(t *testing.T) {
+	for _, kind := range Providers {
+		t.Run(kind, func(t *testing.T) {
+			var got http.Header
+			provider := newTestProvider(t, kind, func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+				fmt.Fprint(w, `{"message": {"content": "ok"}, "choices": [{"message": {"content": "ok"}}]}`)
+			}, func(cfg *Config) {
+				cfg.APIKey = "secret-key"
+				cfg.Headers = map[string]string{"X-Team": "diabetes", "User-Agent": "basal-test"}
+			})
+
+			if _, err := Ask(context.Background(), provider, "q"); err != nil {
+				t.Fatalf("Ask: %v", err)
+			}
+			if auth := got.Get("Authorization"); auth != "Bearer secret-key" {
+				t.Errorf("Authorization = %q", auth)
+			}
+			if got.Get("X-Team") != "diabetes" || got.Get("User-Agent") != "basal-test" {
+				t.Errorf("custom headers missing: %v", got)
+			}
+			if ct := got.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+		})
+	}
+}
+
+func TestNoAPIKey(t *testing.T) {
+	provider := newTestProvider(t, ProviderOllama, func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("Authorization = %q, want none", auth)
+		}
+		fmt.Fprint(w, `{"message": {"content": "ok"}}`)
+	})
+	if _, err := Ask(context.Background(), provider, "q"); err != nil {
+		t.Fatalf("Ask: %v", err)
+	}
+}
+
+func TestRetriesTemporaryStatus(t *testing.T) {
+	var calls atomic.Int32
+	provider := newTestProvider(t, ProviderOllama, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"error": "loading model"}`)
+			return
+		}
+		fmt.Fprint(w, `{"message": {"content": "ok"}}`)
+	})
+
+	reply, err := Ask(context.Background(), provider, "q")
+	if err != nil || reply != "ok" {
+		t.Fatalf("Ask = %q, %v; want ok after a retry", reply, err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("%d requests, want 2", calls.Load())
+	}
+}
+
+func TestDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	provider := newTestProvider(t, ProviderOpenAI, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": {"message": "bad request"}}`)
+	})
+
+	if _, err := Ask(context.Background(), provider, "q"); err == nil {
+		t.Fatal("Ask succeeded")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("%d requests, want 1", calls.Load())
+	}
+}
+
+func TestCancelledRequest(t *testing.T) {
+	provider := newTestProvider(t, ProviderOllama, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Ask(ctx, provider, "q"); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	var audit bytes.Buffer
+	provider := newTestProvider(t, ProviderOllama, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"message": {"content": "ok"}}`)
+	}, func(cfg *Config) { cfg.Audit = &audit })
+
+	if _, err := Ask(context.Background(), provider, "what was my total?"); err != nil {
+		t.Fatalf("Ask: %v", err)
+	}
+	var record auditRecord
+	if err := json.Unmarshal(audit.Bytes(), &record); err != nil {
+		t.Fatalf("audit line %q: %v", audit.String(), err)
+	}
+	if record.Method != http.MethodPost || !strings.HasSuffix(record.URL, "/api/chat") ||
+		!strings.Contains(string(record.Body), "what was my total?") {
+		t.Errorf("audit record = %+v", record)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestAuditFailureStopsRequest(t *testing.T) {
+	var calls atomic.Int32
+	provider := newTestProvider(t, ProviderOllama, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		fmt.Fprint(w, `{"message": {"content": "ok"}}`)
+	}, func(cfg *Config) { cfg.Audit = failingWriter{} })
+
+	if _, err := Ask(context.Background(), provider, "q"); err == nil {
+		t.Fatal("Ask succeeded without an audit record")
+	}
+	if calls.Load() != 0 {
+		t.Errorf("%d requests sent, want none", calls.Load())
+	}
+}
+
+func TestIsLocal(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     bool
+	}{
+		{"http://localhost:11434", true},
+		{"http://127.0.0.1:8080/v1", true},
+		{"http://[::1]:11434", true},
+		{"http://ollama.localhost", true},
+		{"http://192.168.1.20:11434", false},
+		{"https://api.example.com/v1", false},
+		{"not a url", false},
+	}
+	for _, tt := range tests {
+		if got := IsLocal(tt.endpoint); got != tt.want {
+			t.Errorf("IsLocal(%q) = %v, want %v", tt.endpoint, got, tt.want)
+		}
+	}
+}
+
+func TestUnknownProvider(t *testing.T) {
+	if _, err := New(Config{Provider: "bard"}); err == nil {
+		t.Error("New accepted an unknown provider")
+	}
+}
@@ -0,0 +1,193 @@
+// Package schedule models a day of insulin basal rates independently of how
+// it is stored. A Schedule is an ordered list of segments that together cover
+// a whole day, from midnight to midnight.
+package schedule
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// MinutesPerDay is the number of points in a minute grid.
+const MinutesPerDay = 24 * 60
+
+// Segment is a time range within a day with a constant basal rate.
+type Segment struct {
+	StartTime    TimeOfDay
+	EndTime      TimeOfDay // Midnight means the end of the day
+	UnitsPerHour float64
+}
+
+// Duration returns the length of the segment in seconds.
+func (s Segment) Duration() int {
+	return s.EndTime.EndSeconds() - s.StartTime.Seconds()
+}
+
+// Units returns the insulin delivered over the segment.
+func (s Segment) Units() float64 {
+	return float64(s.Duration()) / 3600.0 * s.UnitsPerHour
+}
+
+// Contains reports whether t falls inside the segment.
+func (s Segment) Contains(t TimeOfDay) bool {
+	return t.Seconds() >= s.StartTime.Seconds() && t.Seconds() < s.EndTime.EndSeconds()
+}
+
+// Schedule is a day of basal rates, ordered by start time.
+type Schedule []Segment
+
+// Validate checks that the schedule covers the whole day with contiguous,
+// non-empty segments and non-negative rates.
+func (s Schedule) Validate() error {
+	if len(s) == 0 {
+		return fmt.Errorf("schedule has no segments")
+	}
+	if s[0].StartTime != Midnight {
+		return fmt.Errorf("first segment must start at %s", Midnight)
+	}
+
+	for i, seg := range s {
+		if !seg.StartTime.Valid() || !seg.EndTime.Valid() {
+			return fmt.Errorf("segment %d: time out of range", i+1)
+		}
+		if i > 0 && seg.StartTime != s[i-1].EndTime {
+			return fmt.Errorf("segment %d: start time %s must match previous end time %s", i+1, seg.StartTime, s[i-1].EndTime)
+		}
+		if seg.Duration() <= 0 {
+			return fmt.Errorf("segment %d: end time %s must be after start time %s", i+1, seg.EndTime, seg.StartTime)
+		}
+		if seg.UnitsPerHour < 0 || math.IsNaN(seg.UnitsPerHour) || math.IsInf(seg.UnitsPerHour, 0) {
+			return fmt.Errorf("segment %d: invalid rate %v", i+1, seg.UnitsPerHour)
+		}
+		if seg.EndTime == Midnight && i != len(s)-1 {
+			return fmt.Errorf("segment %d: only the last segment may end at %s", i+1, Midnight)
+		}
+	}
+
+	if last := s[len(s)-1]; last.EndTime != Midnight {
+		return fmt.Errorf("last segment must end at %s, not %s", Midnight, last.EndTime)
+	}
+
+	return nil
+}
+
+// RateAt returns the rate in effect at t. The second result is false if no
+// segment covers t.
+func (s Schedule) RateAt(t TimeOfDay) (float64, bool) {
+	for _, seg := range s {
+		if seg.Contains(t) {
+			return seg.UnitsPerHour, true
+		}
+	}
+	return 0, false
+}
+
+// TotalUnits returns the insulin delivered over the day.
+func (s Schedule) TotalUnits() float64 {
+	var total float64
+	for _, seg := range s {
+		total += seg.Units()
+	}
+	return total
+}
+
+// Normalize returns a copy of the schedule with adjacent segments of equal
+// rate merged into one.
+func (s Schedule) Normalize() Schedule {
+	var out Schedule
+	for _, seg := range s {
+		if n := len(out); n > 0 && out[n-1].UnitsPerHour == seg.UnitsPerHour && out[n-1].EndTime == seg.StartTime {
+			out[n-1].EndTime = seg.EndTime
+			continue
+		}
+		out = append(out, seg)
+	}
+	return out
+}
+
+// RateDecimals is the number of decimal places Scale rounds rates to. A
+// thousandth of a unit per hour is finer than any pump increment, so rounding
+// only removes floating point noise.
+const RateDecimals = 3
+
+// Scale returns a copy of the schedule with every rate multiplied by factor
+// and rounded to RateDecimals places, so that 0.8 scaled by 1.1 is 0.88
+// rather than 0.8800000000000001.
+func (s Schedule) Scale(factor float64) Schedule {
+	steps := math.Pow10(RateDecimals)
+	out := make(Schedule, len(s))
+	for i, seg := range s {
+		seg.UnitsPerHour = math.Round(seg.UnitsPerHour*factor*steps) / steps
+		out[i] = seg
+	}
+	return out
+}
+
+// MinuteGrid expands the schedule to one rate per minute of the day, taking
+// the rate in effect at the start of each minute.
+func (s Schedule) MinuteGrid() []float64 {
+	grid := make([]float64, MinutesPerDay)
+	for _, seg := range s {
+		start := seg.StartTime.Seconds()
+		end := seg.EndTime.EndSeconds()
+		for i := (start + 59) / 60; i*60 < end && i < MinutesPerDay; i++ {
+			grid[i] = seg.UnitsPerHour
+		}
+	}
+	return grid
+}
+
+// Change describes a time range whose rate differs between two schedules.
+type Change struct {
+	StartTime TimeOfDay
+	EndTime   TimeOfDay // Midnight means the end of the day
+	From      float64
+	To        float64
+}
+
+// Diff returns the time ranges where next differs from s, in order. Adjacent
+// ranges with the same old and new rate are merged.
+func (s Schedule) Diff(next Schedule) []Change {
+	var changes []Change
+	for _, b := range boundaries(s, next) {
+		from, _ := s.RateAt(b.start)
+		to, _ := next.RateAt(b.start)
+		if from == to {
+			continue
+		}
+		if n := len(changes); n > 0 && changes[n-1].EndTime == b.start && changes[n-1].From == from && changes[n-1].To == to {
+			changes[n-1].EndTime = b.end
+			continue
+		}
+		changes = append(changes, Change{StartTime: b.start, EndTime: b.end, From: from, To: to})
+	}
+	return changes
+}
+
+type span struct {
+	start, end TimeOfDay
+}
+
+// boundaries splits the day at every segment boundary of either schedule.
+func boundaries(a, b Schedule) []span {
+	marks := map[int]bool{0: true, SecondsPerDay: true}
+	for _, s := range []Schedule{a, b} {
+		for _, seg := range s {
+			marks[seg.StartTime.Seconds()] = true
+			marks[seg.EndTime.EndSeconds()] = true
+		}
+	}
+
+	points := make([]int, 0, len(marks))
+	for p := range marks {
+		points = append(points, p)
+	}
+	sort.Ints(points)
+
+	spans := make([]span, 0, len(points)-1)
+	for i := 1; i < len(points); i++ {
+		spans = append(spans, span{start: TimeOfDay(points[i-1]), end: TimeOfDay(points[i] % SecondsPerDay)})
+	}
+	return spans
+}
@@ -0,0 +1,344 @@
+package schedule
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func hm(hour, min int) TimeOfDay {
+	return TimeOfDay(hour*3600 + min*60)
+}
+
+// twoRates runs 0.8 U/h until 06:00 and 1.0 U/h for the rest of the day.
+var twoRates = Schedule{
+	{StartTime: Midnight, EndTime: hm(6, 0), UnitsPerHour: 0.8},
+	{StartTime: hm(6, 0), EndTime: Midnight, UnitsPerHour: 1.0},
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		sched Schedule
+		err   string // empty if valid
+	}{
+		{"two segments", twoRates, ""},
+		{"whole day", Schedule{{StartTime: Midnight, EndTime: Midnight, UnitsPerHour: 1}}, ""},
+		{"zero rate", Schedule{{StartTime: Midnight, EndTime: Midnight, UnitsPerHour: 0}}, ""},
+		{"empty", Schedule{}, "no segments"},
+		{"nil", nil, "no segments"},
+		{"late start", Schedule{{StartTime: hm(1, 0), EndTime: Midnight, UnitsPerHour: 1}}, "must start at 00:00"},
+		{"gap", Schedule{
+			{StartTime: Midnight, EndTime: hm(6, 0), UnitsPerHour: 1},
+			{StartTime: hm(7, 0), EndTime: Midnight, UnitsPerHour: 1},
+		}, "must match previous end time"},
+		{"overlap", Schedule{
+			{StartTime: Midnight, EndTime: hm(8, 0), UnitsPerHour: 1},
+			{StartTime: hm(6, 0), EndTime: Midnight, UnitsPerHour: 1},
+		}, "must match previous end time"},
+		{"unsorted", Schedule{
+			{StartTime: hm(6, 0), EndTime: Midnight, UnitsPerHour: 1},
+			{StartTime: Midnight, EndTime: hm(6, 0), UnitsPerHour: 1},
+		}, "must start at 00:00"},
+		{"unsorted after midnight", Schedule{
+			{StartTime: Midnight, EndTime: hm(12, 0), UnitsPerHour: 1},
+			{StartTime: hm(12, 0), EndTime: hm(6, 0), UnitsPerHour: 1},
+			{StartTime: hm(6, 0), EndTime: Midnight, UnitsPerHour: 1},
+		}, "segment 2: end time 06:00 must be after start time 12:00"},
+		{"empty segment", Schedule{
+			{StartTime: Midnight, EndTime: hm(6, 0), UnitsPerHour: 1},
+			{StartTime: hm(6, 0), EndTime: hm(6, 0), UnitsPerHour: 1},
+			{StartTime: hm(6, 0), EndTime: Midnight, UnitsPerHour: 1},
+		}, "segment 2: end time 06:00 must be after start time 06:00"},
+		{"wraps past midnight", Schedule{
+			{StartTime: Midnight, EndTime: hm(22, 0), UnitsPerHour: 1},
+			{StartTime: hm(22, 0), EndTime: hm(2, 0), UnitsPerHour: 1},
+		}, "segment 2: end time 02:00 must be after start time 22:00"},
+		{"midnight before the last segment", Schedule{
+			{StartTime: Midnight, EndTime: hm(6, 0), UnitsPerHour: 1},
+			{StartTime: hm(6, 0), EndTime: Midnight, UnitsPerHour: 1},
+			{StartTime: Midnight, EndTime: Midnight, UnitsPerHour: 1},
+		}, "segment 2: only the last segment may end at 00:00"},
+		{"short day", Schedule{{StartTime: Midnight, EndTime: hm(23, 0), UnitsPerHour: 1}}, "last segment must end at 00:00"},
+		{"24:00 end", Schedule{{StartTime: Midnight, EndTime: SecondsPerDay, UnitsPerHour: 1}}, "time out of range"},
+		{"negative rate", Schedule{{StartTime: Midnight, EndTime: Midnight, UnitsPerHour: -0.1}}, "invalid rate"},
+	}
+	for _, tt := range tests {
+		err := tt.sched.Validate()
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.err != "" && err == nil:
+			t.Errorf("%s: valid, want error containing %q", tt.name, tt.err)
+		case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+			t.Errorf("%s: error %q, want it to contain %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestMidnightEndsTheDay(t *testing.T) {
+	last := twoRates[1]
+	if got := last.Duration(); got != 18*3600 {
+		t.Errorf("Duration of 06:00-00:00 = %d, want %d", got, 18*3600)
+	}
+	if !last.Contains(hm(23, 59) + 59) {
+		t.Error("06:00-00:00 does not contain 23:59:59")
+	}
+	if last.Contains(Midnight) {
+		t.Error("06:00-00:00 contains the start of the day")
+	}
+
+	tests := []struct {
+		at   TimeOfDay
+		want float64
+	}{
+		{Midnight, 0.8},
+		{hm(5, 59) + 59, 0.8},
+		{hm(6, 0), 1.0},
+		{hm(23, 59) + 59, 1.0},
+	}
+	for _, tt := range tests {
+		if got, ok := twoRates.RateAt(tt.at); !ok || got != tt.want {
+			t.Errorf("RateAt(%s) = %v, %v; want %v", tt.at, got, ok, tt.want)
+		}
+	}
+	if _, ok := twoRates.RateAt(SecondsPerDay); ok {
+		t.Error("RateAt(24:00) found a rate")
+	}
+
+	if got, want := twoRates.TotalUnits(), 6*0.8+18*1.0; got != want {
+		t.Errorf("TotalUnits = %v, want %v", got, want)
+	}
+}
+
+func TestEmptySchedule(t *testing.T) {
+	var empty Schedule
+	if _, ok := empty.RateAt(hm(12, 0)); ok {
+		t.Error("RateAt found a rate")
+	}
+	if got := empty.TotalUnits(); got != 0 {
+		t.Errorf("TotalUnits = %v, want 0", got)
+	}
+	if got := empty.Normalize(); len(got) != 0 {
+		t.Errorf("Normalize = %v, want nothing", got)
+	}
+	if got := empty.Scale(2); len(got) != 0 {
+		t.Errorf("Scale = %v, want nothing", got)
+	}
+	grid := empty.MinuteGrid()
+	if len(grid) != MinutesPerDay {
+		t.Fatalf("MinuteGrid has %d points, want %d", len(grid), MinutesPerDay)
+	}
+	for i, rate := range grid {
+		if rate != 0 {
+			t.Fatalf("minute %d = %v, want 0", i, rate)
+		}
+	}
+
+	// Starting from nothing, the whole day changes from zero
+	want := []Change{
+		{StartTime: Midnight, EndTime: hm(6, 0), From: 0, To: 0.8},
+		{StartTime: hm(6, 0), EndTime: Midnight, From: 0, To: 1.0},
+	}
+	if got := empty.Diff(twoRates); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff from empty = %+v, want %+v", got, want)
+	}
+}
+
+func TestScaleRounds(t *testing.T) {
+	tests := []struct {
+		rate, factor, want float64
+	}{
+		{0.8, 1.1, 0.88}, // 0.8800000000000001 before rounding
+		{0.85, 1.2, 1.02},
+		{1.0, 1.0 / 3, 0.333},
+		{0.0125, 1, 0.013}, // half a step rounds away from zero
+		{0.05, 0.9, 0.045},
+		{1.2, 0, 0},
+	}
+	for _, tt := range tests {
+		sched := Schedule{{StartTime: Midnight, EndTime: Midnight, UnitsPerHour: tt.rate}}
+		got := sched.Scale(tt.factor)
+		if got[0].UnitsPerHour != tt.want {
+			t.Errorf("%v scaled by %v = %v, want %v", tt.rate, tt.factor, got[0].UnitsPerHour, tt.want)
+		}
+		if sched[0].UnitsPerHour != tt.rate {
+			t.Errorf("Scale modified the original schedule")
+		}
+	}
+
+	scaled := twoRates.Scale(1.5)
+	if scaled[0].StartTime != Midnight || scaled[0].EndTime != hm(6, 0) || scaled[1].EndTime != Midnight {
+		t.Errorf("Scale changed the segment times: %+v", scaled)
+	}
+	if err := scaled.Validate(); err != nil {
+		t.Errorf("scaled schedule is invalid: %v", err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Schedule
+		want Schedule
+	}{
+		{"nothing to merge", twoRates, twoRates},
+		{"merges equal neighbours", Schedule{
+			{StartTime: Midnight, EndTime: hm(3, 0), UnitsPerHour: 0.8},
+			{StartTime: hm(3, 0), EndTime: hm(6, 0), UnitsPerHour: 0.8},
+			{StartTime: hm(6, 0), EndTime: hm(12, 0), UnitsPerHour: 1.0},
+			{StartTime: hm(12, 0), EndTime: Midnight, UnitsPerHour: 1.0},
+		}, twoRates},
+		{"merges into the whole day", Schedule{
+			{StartTime: Midnight, EndTime: hm(12, 0), UnitsPerHour: 1},
+			{StartTime: hm(12, 0), EndTime: Midnight, UnitsPerHour: 1},
+		}, Schedule{{StartTime: Midnight, EndTime: Midnight, UnitsPerHour: 1}}},
+		{"keeps equal rates that are not adjacent", Schedule{
+			{StartTime: Midnight, EndTime: hm(6, 0), UnitsPerHour: 0.8},
+			{StartTime: hm(6, 0), EndTime: hm(12, 0), UnitsPerHour: 1.0},
+			{StartTime: hm(12, 0), EndTime: Midnight, UnitsPerHour: 0.8},
+		}, Schedule{
+			{StartTime: Midnight, EndTime: hm(6, 0), UnitsPerHour: 0.8},
+			{StartTime: hm(6, 0), EndTime: hm(12, 0), UnitsPerHour: 1.0},
+			{StartTime: hm(12, 0), EndTime: Midnight, UnitsPerHour: 0.8},
+		}},
+	}
+	for _, tt := range tests {
+		in := append(Schedule(nil), tt.in...)
+		got := tt.in.Normalize()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Normalize = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(tt.in, in) {
+			t.Errorf("%s: Normalize modified its input", tt.name)
+		}
+		if got.TotalUnits() != tt.in.TotalUnits() {
+			t.Errorf("%s: Normalize changed the total from %v to %v", tt.name, tt.in.TotalUnits(), got.TotalUnits())
+		}
+	}
+}
+
+func TestMinuteGrid(t *testing.T) {
+	grid := twoRates.MinuteGrid()
+	if len(grid) != MinutesPerDay {
+		t.Fatalf("MinuteGrid has %d points, want %d", len(grid), MinutesPerDay)
+	}
+	for _, check := range []struct {
+		minute int
+		want   float64
+	}{{0, 0.8}, {359, 0.8}, {360, 1.0}, {MinutesPerDay - 1, 1.0}} {
+		if grid[check.minute] != check.want {
+			t.Errorf("minute %d = %v, want %v", check.minute, grid[check.minute], check.want)
+		}
+	}
+
+	// A boundary inside a minute gives that minute the rate in effect at its start
+	offset := Schedule{
+		{StartTime: Midnight, EndTime: hm(6, 0) + 30, UnitsPerHour: 0.8},
+		{StartTime: hm(6, 0) + 30, EndTime: Midnight, UnitsPerHour: 1.0},
+	}
+	grid = offset.MinuteGrid()
+	if grid[360] != 0.8 || grid[361] != 1.0 {
+		t.Errorf("minutes 360 and 361 = %v, %v; want 0.8, 1.0", grid[360], grid[361])
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		next Schedule
+		want []Change
+	}{
+		{"identical", twoRates, nil},
+		{"same rates split differently", Schedule{
+			{StartTime: Midnight, EndTime: hm(3, 0), UnitsPerHour: 0.8},
+			{StartTime: hm(3, 0), EndTime: hm(6, 0), UnitsPerHour: 0.8},
+			{StartTime: hm(6, 0), EndTime: Midnight, UnitsPerHour: 1.0},
+		}, nil},
+		{"one segment changes", Schedule{
+			{StartTime: Midnight, EndTime: hm(6, 0), UnitsPerHour: 0.7},
+			{StartTime: hm(6, 0), EndTime: Midnight, UnitsPerHour: 1.0},
+		}, []Change{{StartTime: Midnight, EndTime: hm(6, 0), From: 0.8, To: 0.7}}},
+		{"boundary moves", Schedule{
+			{StartTime: Midnight, EndTime: hm(7, 0), UnitsPerHour: 0.8},
+			{StartTime: hm(7, 0), EndTime: Midnight, UnitsPerHour: 1.0},
+		}, []Change{{StartTime: hm(6, 0), EndTime: hm(7, 0), From: 1.0, To: 0.8}}},
+		{"change runs to midnight", Schedule{
+			{StartTime: Midnight, EndTime: hm(6, 0), UnitsPerHour: 0.8},
+			{StartTime: hm(6, 0), EndTime: hm(12, 0), UnitsPerHour: 1.0},
+			{StartTime: hm(12, 0), EndTime: hm(18, 0), UnitsPerHour: 1.2},
+			{StartTime: hm(18, 0), EndTime: Midnight, UnitsPerHour: 1.2},
+		}, []Change{{StartTime: hm(12, 0), EndTime: Midnight, From: 1.0, To: 1.2}}},
+		{"whole day", Schedule{{StartTime: Midnight, EndTime: Midnight, UnitsPerHour: 0.9}}, []Change{
+			{StartTime: Midnight, EndTime: hm(6, 0), From: 0.8, To: 0.9},
+			{StartTime: hm(6, 0), EndTime: Midnight, From: 1.0, To: 0.9},
+		}},
+		{"to nothing", nil, []Change{
+			{StartTime: Midnight, EndTime: hm(6, 0), From: 0.8, To: 0},
+			{StartTime: hm(6, 0), EndTime: Midnight, From: 1.0, To: 0},
+		}},
+	}
+	for _, tt := range tests {
+		if got := twoRates.Diff(tt.next); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Diff = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTimeOfDay(t *testing.T) {
+	tests := []struct {
+		input string
+		want  TimeOfDay
+		ok    bool
+	}{
+		{"06:00", hm(6, 0), true},
+		{"6:00", hm(6, 0), true},
+		{" 06:30 ", hm(6, 30), true},
+		{"00:00", Midnight, true},
+		{"23:59:59", hm(23, 59) + 59, true},
+		{"07:15:30", hm(7, 15) + 30, true},
+		{"230", hm(2, 30), true},
+		{"0230", hm(2, 30), true},
+		{"2359", hm(23, 59), true},
+		{"0000", Midnight, true},
+
+		{"24:00", 0, false},
+		{"2400", 0, false},
+		{"12:60", 0, false},
+		{"1260", 0, false},
+		{"12:00:60", 0, false},
+		{"6:5", 0, false},
+		{"006:00", 0, false},
+		{"12:00:", 0, false},
+		{":30", 0, false},
+		{"-1:00", 0, false},
+		{"+1:00", 0, false},
+		{"6", 0, false},
+		{"12345", 0, false},
+		{"ab:cd", 0, false},
+		{"6am", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimeOfDay(tt.input)
+		if tt.ok != (err == nil) || got != tt.want {
+			t.Errorf("ParseTimeOfDay(%q) = %v, %v; want %v, ok %v", tt.input, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTimeOfDayString(t *testing.T) {
+	tests := []struct {
+		t    TimeOfDay
+		want string
+	}{
+		{Midnight, "00:00"},
+		{hm(6, 30), "06:30"},
+		{hm(23, 59) + 59, "23:59:59"},
+	}
+	for _, tt := range tests {
+		if got := tt.t.String(); got != tt.want {
+			t.Errorf("String(%d) = %q, want %q", int(tt.t), got, tt.want)
+		}
+	}
+}
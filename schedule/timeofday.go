@@ -0,0 +1,132 @@
+package schedule
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SecondsPerDay is the number of seconds in a day.
+const SecondsPerDay = 24 * 60 * 60
+
+// TimeOfDay is a time within a day, stored as whole seconds after midnight.
+// An interval ending at Midnight runs to the end of the day.
+type TimeOfDay int
+
+// Midnight is the start (and, as an end time, the end) of a day.
+const Midnight TimeOfDay = 0
+
+// NewTimeOfDay builds a TimeOfDay from its components, rejecting out of range values.
+func NewTimeOfDay(hour, min, sec int) (TimeOfDay, error) {
+	if hour < 0 || hour > 23 || min < 0 || min > 59 || sec < 0 || sec > 59 {
+		return 0, fmt.Errorf("invalid time: hours must be 0-23, minutes and seconds must be 0-59")
+	}
+	return TimeOfDay(hour*3600 + min*60 + sec), nil
+}
+
+// ParseTimeOfDay parses a time in HH:MM, H:MM, HH:MM:SS, HMM or HHMM format.
+// Malformed input is rejected rather than read as midnight.
+func ParseTimeOfDay(input string) (TimeOfDay, error) {
+	input = strings.TrimSpace(input)
+
+	parts := strings.Split(input, ":")
+	switch {
+	case len(parts) == 2 || len(parts) == 3:
+		// H:MM, HH:MM or HH:MM:SS
+		if len(parts[0]) < 1 || len(parts[0]) > 2 {
+			break
+		}
+		for _, p := range parts[1:] {
+			if len(p) != 2 {
+				return 0, fmt.Errorf("invalid time format %q: use HH:MM, H:MM, HH:MM:SS, HMM, or HHMM", input)
+			}
+		}
+		fields := make([]int, 3)
+		for i, p := range parts {
+			n, err := parseDigits(p)
+			if err != nil {
+				return 0, fmt.Errorf("invalid time format %q: use HH:MM, H:MM, HH:MM:SS, HMM, or HHMM", input)
+			}
+			fields[i] = n
+		}
+		return NewTimeOfDay(fields[0], fields[1], fields[2])
+	case len(input) == 3 || len(input) == 4:
+		// HMM (e.g., "230" for 2:30) or HHMM (e.g., "0230" for 02:30)
+		split := len(input) - 2
+		hour, err := parseDigits(input[:split])
+		if err != nil {
+			break
+		}
+		min, err := parseDigits(input[split:])
+		if err != nil {
+			break
+		}
+		return NewTimeOfDay(hour, min, 0)
+	}
+
+	return 0, fmt.Errorf("invalid time format %q: use HH:MM, H:MM, HH:MM:SS, HMM, or HHMM", input)
+}
+
+// parseDigits parses a non-empty string made up only of ASCII digits.
+func parseDigits(s string) (int, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty number")
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("invalid digit %q", r)
+		}
+	}
+	return strconv.Atoi(s)
+}
+
+// Seconds returns the number of seconds after midnight.
+func (t TimeOfDay) Seconds() int {
+	return int(t)
+}
+
+// EndSeconds returns the number of seconds after midnight when t is used as
+// the end of an interval, so that Midnight counts as the end of the day.
+func (t TimeOfDay) EndSeconds() int {
+	if t == Midnight {
+		return SecondsPerDay
+	}
+	return int(t)
+}
+
+// String formats the time as HH:MM, or HH:MM:SS when it has a seconds component.
+func (t TimeOfDay) String() string {
+	hour, min, sec := int(t)/3600, int(t)%3600/60, int(t)%60
+	if sec != 0 {
+		return fmt.Sprintf("%02d:%02d:%02d", hour, min, sec)
+	}
+	return fmt.Sprintf("%02d:%02d", hour, min)
+}
+
+// Valid reports whether t lies within a single day.
+func (t TimeOfDay) Valid() bool {
+	return t >= 0 && t < SecondsPerDay
+}
+
+// Scan implements sql.Scanner, reading an integer offset from midnight.
+func (t *TimeOfDay) Scan(src any) error {
+	v, ok := src.(int64)
+	if !ok {
+		return fmt.Errorf("scanning time of day: unsupported type %T", src)
+	}
+	tod := TimeOfDay(v)
+	if !tod.Valid() {
+		return fmt.Errorf("scanning time of day: %d is out of range", v)
+	}
+	*t = tod
+	return nil
+}
+
+// Value implements driver.Valuer, storing the integer offset from midnight.
+func (t TimeOfDay) Value() (driver.Value, error) {
+	if !t.Valid() {
+		return nil, fmt.Errorf("time of day %d is out of range", int(t))
+	}
+	return int64(t), nil
+}